@@ -1,12 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/arylatt/go-monzo"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 var (
@@ -24,14 +31,41 @@ var (
 		RunE:      accountsRunE,
 		Args:      cobra.MaximumNArgs(1),
 		ValidArgs: accountsValidArgs,
+
+		Annotations: map[string]string{annotationOffline: "true"},
 	}
 
 	ErrAccountTypeInvalid = fmt.Errorf("account type invalid. valid types [%s]", strings.Join(accountsValidArgs[1:], ", "))
+
+	// accountTypeNames are the friendly names of account types accepted by --account-id.
+	accountTypeNames = map[string]monzo.AccountType{
+		"personal": monzo.AccountTypeUKRetail,
+		"joint":    monzo.AccountTypeUKRetailJoint,
+	}
+
+	ErrAccountRequired = errors.New("--account-id flag is required")
+
+	ErrAccountNotFound = errors.New("no account matches")
+
+	ErrAccountAmbiguous = errors.New("more than one account matches, use the account ID or an alias instead")
+
+	ErrAccountSelectionInvalid = errors.New("account selection invalid")
 )
 
 func init() {
 	root.AddGroup(&cobra.Group{ID: "accounts", Title: "Accounts"})
 	root.AddCommand(accounts)
+
+	root.SetGlobalNormalizationFunc(accountFlagAlias)
+}
+
+// accountFlagAlias makes --account an alias of --account-id.
+func accountFlagAlias(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "account" {
+		name = "account-id"
+	}
+
+	return pflag.NormalizedName(name)
 }
 
 func accountsPreRunE(cmd *cobra.Command, args []string) (err error) {
@@ -55,16 +89,216 @@ func accountsRunE(cmd *cobra.Command, args []string) (err error) {
 		accTypes = append(accTypes, monzo.AccountType(args[0]))
 	}
 
+	if Offline() {
+		return accountsOffline(cmd, accTypes)
+	}
+
 	who, err := _client.Accounts.List(accTypes...)
 	if err != nil {
 		return
 	}
 
-	data, err := json.MarshalIndent(who, "", "  ")
+	if len(accTypes) == 0 {
+		if err = saveAccountsSnapshot(who); err != nil {
+			return
+		}
+	}
+
+	return Output(cmd, who)
+}
+
+// accountsOffline outputs the cached accounts, filtered to the account types.
+func accountsOffline(cmd *cobra.Command, accTypes []monzo.AccountType) (err error) {
+	snapshot := &Snapshot[*monzo.AccountsList]{}
+	if err = LoadCache(CacheFileAccounts, snapshot); err != nil || snapshot.Data == nil {
+		return ErrOfflineNotCached
+	}
+
+	reportOffline(cmd, "accounts", snapshot.Fetched)
+
+	if len(accTypes) == 0 {
+		return Output(cmd, snapshot.Data)
+	}
+
+	filtered := &monzo.AccountsList{Accounts: []monzo.Account{}}
+
+	for _, acc := range snapshot.Data.Accounts {
+		for _, accType := range accTypes {
+			if acc.Type == accType {
+				filtered.Accounts = append(filtered.Accounts, acc)
+			}
+		}
+	}
+
+	return Output(cmd, filtered)
+}
+
+// ResolveAccount returns the ID of the account selected with --account-id.
+//
+// The flag can be an account ID, an alias from the config file, an account type (personal, joint, or the API's type
+// names), or the account description. If the flag is not set and stdin is a terminal, the user picks an account
+// interactively.
+func ResolveAccount(cmd *cobra.Command) (string, error) {
+	if ref := strings.TrimSpace(viper.GetString("account-id")); ref != "" {
+		return ResolveAccountRef(ref)
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", ErrAccountRequired
+	}
+
+	return pickAccount(cmd)
+}
+
+// ResolveAccountRef returns the ID of the account matching the reference, which is an account ID, alias, type or
+// description.
+func ResolveAccountRef(ref string) (string, error) {
+	ref = ResolveAccountAlias(ref)
+
+	if strings.HasPrefix(ref, "acc_") {
+		return ref, nil
+	}
+
+	list, err := listAccounts()
 	if err != nil {
+		return "", err
+	}
+
+	matches := matchAccounts(list.Accounts, ref)
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w '%s', accounts are:%s", ErrAccountNotFound, ref, describeAccounts(openAccounts(list.Accounts)))
+	case 1:
+		return matches[0].ID, nil
+	}
+
+	return "", fmt.Errorf("%w. '%s' matches:%s", ErrAccountAmbiguous, ref, describeAccounts(matches))
+}
+
+// matchAccounts returns the open accounts matching the type name, or failing that the description. Descriptions are
+// compared case-insensitively, and an exact match is preferred over a partial one.
+func matchAccounts(accounts []monzo.Account, ref string) (matches []monzo.Account) {
+	accounts = openAccounts(accounts)
+	ref = strings.ToLower(ref)
+
+	accType, ok := accountTypeNames[ref]
+	if !ok {
+		accType = monzo.AccountType(ref)
+	}
+
+	for _, acc := range accounts {
+		if acc.Type == accType {
+			matches = append(matches, acc)
+		}
+	}
+
+	if len(matches) != 0 {
 		return
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+	for _, acc := range accounts {
+		if strings.ToLower(acc.Description) == ref {
+			matches = append(matches, acc)
+		}
+	}
+
+	if len(matches) != 0 {
+		return
+	}
+
+	for _, acc := range accounts {
+		if strings.Contains(strings.ToLower(acc.Description), ref) {
+			matches = append(matches, acc)
+		}
+	}
+
+	return
+}
+
+// pickAccount asks the user to choose one of their open accounts. If there is only one, it is used without asking.
+func pickAccount(cmd *cobra.Command) (string, error) {
+	list, err := listAccounts()
+	if err != nil {
+		return "", err
+	}
+
+	accounts := openAccounts(list.Accounts)
+
+	switch len(accounts) {
+	case 0:
+		return "", fmt.Errorf("%w, and no open accounts were found", ErrAccountRequired)
+	case 1:
+		return accounts[0].ID, nil
+	}
+
+	out := cmd.ErrOrStderr()
+
+	fmt.Fprintln(out, "Select an account (or set --account-id):")
+
+	for n, acc := range accounts {
+		fmt.Fprintf(out, "  %d) %s\n", n+1, describeAccount(acc))
+	}
+
+	fmt.Fprint(out, "Account: ")
+
+	input, err := readLine(bufio.NewReader(cmd.InOrStdin()))
+	if err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(input)
+	if err != nil || n < 1 || n > len(accounts) {
+		return "", fmt.Errorf("%w '%s'", ErrAccountSelectionInvalid, input)
+	}
+
+	return accounts[n-1].ID, nil
+}
+
+// listAccounts returns the user's accounts. The cached accounts are used offline, or if they were fetched within the
+// cache TTL.
+func listAccounts() (*monzo.AccountsList, error) {
+	snapshot := &Snapshot[*monzo.AccountsList]{}
+	cached := LoadCache(CacheFileAccounts, snapshot) == nil && snapshot.Data != nil
+
+	if Offline() {
+		if !cached {
+			return nil, ErrOfflineNotCached
+		}
+
+		return snapshot.Data, nil
+	}
+
+	if cached && time.Since(snapshot.Fetched) < viper.GetDuration("cache-ttl") {
+		return snapshot.Data, nil
+	}
+
+	list, err := _client.Accounts.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, saveAccountsSnapshot(list)
+}
+
+func openAccounts(accounts []monzo.Account) (open []monzo.Account) {
+	for _, acc := range accounts {
+		if !acc.Closed {
+			open = append(open, acc)
+		}
+	}
+
+	return
+}
+
+func describeAccount(acc monzo.Account) string {
+	return fmt.Sprintf("%s (%s, %s)", acc.ID, acc.Description, acc.Type)
+}
+
+func describeAccounts(accounts []monzo.Account) (s string) {
+	for _, acc := range accounts {
+		s += "\n  " + describeAccount(acc)
+	}
+
 	return
 }
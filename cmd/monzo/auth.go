@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,11 +24,12 @@ import (
 
 var (
 	login = &cobra.Command{
-		Use:     "login --token | --client-id --client-secret",
-		Short:   "Authenticate to the Monzo API",
-		GroupID: "auth",
-		PreRunE: loginPreRunE,
-		RunE:    loginRunE,
+		Use:         "login --token | --client-id [--client-secret]",
+		Short:       "Authenticate to the Monzo API",
+		GroupID:     "auth",
+		PreRunE:     loginPreRunE,
+		RunE:        loginRunE,
+		Annotations: map[string]string{annotationNoAuth: "true"},
 	}
 
 	refreshToken = &cobra.Command{
@@ -35,17 +41,39 @@ var (
 	}
 
 	logout = &cobra.Command{
-		Use:     "logout",
-		Short:   "Delete all cached data",
-		GroupID: "auth",
-		RunE:    logoutRunE,
+		Use:         "logout",
+		Short:       "Revoke the token and delete all cached data for the profile",
+		GroupID:     "auth",
+		RunE:        logoutRunE,
+		Annotations: map[string]string{annotationNoAuth: "true"},
 	}
 
 	ErrLoginAuthTypesMutuallyExclusive = errors.New("cannot use --token with --client-id and --client-secret")
 
-	ErrLoginAuthTypesOAuth2MissingPart = errors.New("--client-id and --client-secret must both be provided for oauth2")
+	ErrLoginAuthTypesOAuth2MissingPart = errors.New("--client-id must be provided with --client-secret for oauth2")
+
+	ErrLoginAuthTypesMissing = errors.New("--token or --client-id (and --client-secret for confidential clients) must be supplied")
+
+	ErrTokenExpired = errors.New("access token has expired and cannot be refreshed, try running monzo login")
+
+	ErrLoginCodeMissing = errors.New("authorization code missing from pasted input")
+
+	ErrLoginStateMismatch = errors.New("state mismatch - the pasted URL is not from this login attempt")
+
+	ErrLoginApprovalTimeout = errors.New("timed out waiting for access to be approved in the Monzo app")
+)
+
+const (
+	// DefaultRedirectHost, DefaultRedirectPort and DefaultRedirectPath make up the default OAuth2 redirect URL.
+	DefaultRedirectHost = "127.0.0.1"
+	DefaultRedirectPort = 54092
+	DefaultRedirectPath = "/callback"
+
+	// DefaultApprovalTimeout is how long login waits for the user to approve access in the Monzo app.
+	DefaultApprovalTimeout = time.Minute * 5
 
-	ErrLoginAuthTypesMissing = errors.New("--token or --client-id and --client-secret must be supplied")
+	approvalPollInitial = time.Second * 2
+	approvalPollMax     = time.Second * 15
 )
 
 func init() {
@@ -64,15 +92,16 @@ func loginPreRunE(cmd *cobra.Command, args []string) error {
 		return ErrLoginAuthTypesMutuallyExclusive
 	}
 
-	if viper.GetString("token") == "" && (viper.GetString("client-id") == "" || viper.GetString("client-secret") == "") {
-		return ErrLoginAuthTypesOAuth2MissingPart
-	}
-
 	if viper.GetString("token") == "" && viper.GetString("client-id") == "" && viper.GetString("client-secret") == "" {
 		return ErrLoginAuthTypesMissing
 	}
 
-	return nil
+	if viper.GetString("token") == "" && viper.GetString("client-id") == "" {
+		return ErrLoginAuthTypesOAuth2MissingPart
+	}
+
+	_, err := BackfillMode()
+	return err
 }
 
 func loginRunE(cmd *cobra.Command, args []string) (err error) {
@@ -81,7 +110,7 @@ func loginRunE(cmd *cobra.Command, args []string) (err error) {
 	if tokenStr := viper.GetString("token"); tokenStr != "" {
 		token.Token = &oauth2.Token{AccessToken: tokenStr}
 	} else {
-		token, err = LoginOAuth2(cmd.Context(), viper.GetString("client-id"), viper.GetString("client-secret"))
+		token, err = LoginOAuth2(cmd.Context(), NewOAuth2Login(cmd))
 	}
 
 	if err != nil {
@@ -95,46 +124,264 @@ func loginRunE(cmd *cobra.Command, args []string) (err error) {
 		return
 	}
 
+	token.Authorised, err = WaitForApproval(cmd.Context(), cmd.ErrOrStderr(), c, viper.GetDuration("approval-timeout"))
+	if err != nil {
+		return
+	}
+
+	if token.Authorised && viper.GetString("token") == "" {
+		token.AuthorisedAt = time.Now().UTC()
+	}
+
 	err = token.Save()
 	if err != nil {
 		return
 	}
 
-	fmt.Fprintf(os.Stdout, "Authenticated to Monzo! User: %s\n\n", who.UserID)
+	if !token.Authorised {
+		return fmt.Errorf("%w - approve access in the app, then run monzo login again", ErrLoginApprovalTimeout)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Authenticated to Monzo! User: %s\n\n", who.UserID)
+
+	mode, _ := BackfillMode()
+
+	switch {
+	case mode == BackfillOff || token.AuthorisedAt.IsZero():
+		return nil
+	case mode == BackfillBackground:
+		return StartBackgroundSync(cmd, token)
+	}
+
+	return loginBackfill(cmd, c, token)
+}
+
+// loginBackfill fetches the full transaction history of every account while the token is still inside the
+// full history window, or explains how far back the cache goes if the window has been missed. Login does not return
+// until it is done.
+func loginBackfill(cmd *cobra.Command, c *monzo.Client, token *Token) (err error) {
+	w := cmd.ErrOrStderr()
+
+	if token.InFullHistoryWindow() {
+		err = Backfill(w, c, token)
+		if err == nil {
+			return
+		}
+
+		fmt.Fprintf(w, "\nFull history backfill did not complete: %s\n", err.Error())
+
+		if token.InFullHistoryWindow() {
+			fmt.Fprintf(w, "Run monzo sync before %s to resume it.\n",
+				token.AuthorisedAt.Add(FullHistoryWindow).Local().Format(time.Kitchen))
+
+			return nil
+		}
+	}
+
+	accounts, err := c.Accounts.List()
+	if err != nil {
+		return
+	}
+
+	state := openCaches.SyncState()
+
+	fmt.Fprintf(w, "\nThe %s full history window after login has passed, so only the last 90 days of transactions are accessible. "+
+		"To fetch the full history, run monzo login again and leave the backfill to complete.\n\nCached history:\n%s",
+		FullHistoryWindow, DescribeCacheCoverage(state, accounts.Accounts))
+
 	return nil
 }
 
+// WaitForApproval checks whether the client has been granted access to the user's data, and if not, asks the user to
+// approve access in the Monzo app and polls (with backoff) until access is granted or the timeout is reached.
+//
+// A newly issued token can call /ping/whoami, but any request for account data fails with insufficient permissions until
+// the user has approved it. Any other error is returned immediately.
+func WaitForApproval(ctx context.Context, w io.Writer, c *monzo.Client, timeout time.Duration) (approved bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := approvalPollInitial
+
+	for attempt := 0; ; attempt++ {
+		if _, err = c.Whoami(); err == nil {
+			_, err = c.Accounts.List()
+		}
+
+		if err == nil {
+			if attempt != 0 {
+				fmt.Fprintln(w, "Access approved.")
+			}
+
+			return true, nil
+		}
+
+		if !monzo.IsInsufficientPermissions(err) {
+			return false, err
+		}
+
+		if attempt == 0 {
+			fmt.Fprintf(w, "Please approve access for this client in the Monzo app. Waiting up to %s...\n", timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(interval):
+		}
+
+		if interval *= 2; interval > approvalPollMax {
+			interval = approvalPollMax
+		}
+	}
+}
+
 type Token struct {
 	*oauth2.Token
 
 	ClientID     string `json:"client_id,omitempty"`
 	ClientSecret string `json:"client_secret,omitempty"`
+
+	// Authorised records whether the user has approved the client's access to their data in the Monzo app.
+	Authorised bool `json:"authorised"`
+
+	// AuthorisedAt is when the token was granted access to the user's accounts, if known.
+	AuthorisedAt time.Time `json:"authorised_at,omitempty"`
+
+	// profile is the profile the token was loaded from, or empty for the current profile.
+	profile string
+}
+
+const (
+	// ClientTypeStatic is used for tokens supplied directly with --token.
+	ClientTypeStatic = "static"
+
+	// ClientTypeConfidential is used for OAuth2 clients with a client secret, which are issued refresh tokens.
+	ClientTypeConfidential = "confidential"
+
+	// ClientTypeNonConfidential is used for OAuth2 clients without a client secret, which are not issued refresh tokens.
+	ClientTypeNonConfidential = "non-confidential"
+)
+
+// LoadToken reads the token cache of the profile.
+func LoadToken(profile string) (token *Token, err error) {
+	token = &Token{profile: profile}
+	err = LoadProfileCache(profile, CacheFileToken, token)
+
+	return
+}
+
+// Profile returns the profile the token belongs to.
+func (t *Token) Profile() string {
+	if t.profile == "" {
+		return CurrentProfile()
+	}
+
+	return t.profile
 }
 
+// Save writes the token to its profile's cache, holding the profile lock.
 func (t *Token) Save() error {
-	return SaveCache(CacheFileToken, t)
+	return WithProfileLock(t.Profile(), func() error {
+		return SaveProfileCache(t.Profile(), CacheFileToken, t)
+	})
 }
 
-func LoginOAuth2(ctx context.Context, clientID, clientSecret string) (t *Token, err error) {
-	state := uuid.NewString()
-	tokenChan := make(chan *Token, 1)
+// ClientType returns which type of client the token was issued to.
+func (t *Token) ClientType() string {
+	switch {
+	case t.ClientID == "":
+		return ClientTypeStatic
+	case t.ClientSecret == "":
+		return ClientTypeNonConfidential
+	}
 
-	config := monzo.OAuth2Config(clientID, clientSecret, "http://127.0.0.1:54092/callback")
+	return ClientTypeConfidential
+}
+
+// CanRefresh reports whether the token can be refreshed once it expires.
+func (t *Token) CanRefresh() bool {
+	return t.Token != nil && t.RefreshToken != "" && t.ClientID != ""
+}
+
+// Expired reports whether the token has expired and cannot be refreshed, meaning a new login is required.
+func (t *Token) Expired() bool {
+	return t.Token != nil && !t.CanRefresh() && !t.Expiry.IsZero() && time.Now().After(t.Expiry)
+}
+
+// OAuth2Login configures the OAuth2 authorization code flow used by login.
+type OAuth2Login struct {
+	ClientID     string
+	ClientSecret string
+
+	// RedirectHost, RedirectPort and RedirectPath make up the redirect URL, which must match the redirect URL registered
+	// for the OAuth2 client.
+	RedirectHost string
+	RedirectPort int
+	RedirectPath string
+
+	// NoBrowser disables opening the browser and the local callback server. Instead, the user opens the auth URL on any
+	// device, and pastes the redirected URL (or the code) back in.
+	NoBrowser bool
+
+	In  io.Reader
+	Out io.Writer
+}
+
+// RedirectURL returns the OAuth2 redirect URL.
+func (l *OAuth2Login) RedirectURL() string {
+	return (&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(l.RedirectHost, strconv.Itoa(l.RedirectPort)),
+		Path:   l.RedirectPath,
+	}).String()
+}
+
+// NewOAuth2Login creates the OAuth2 login configuration from the flag/env configuration.
+func NewOAuth2Login(cmd *cobra.Command) *OAuth2Login {
+	redirectPath := viper.GetString("redirect-path")
+	if !strings.HasPrefix(redirectPath, "/") {
+		redirectPath = "/" + redirectPath
+	}
+
+	return &OAuth2Login{
+		ClientID:     viper.GetString("client-id"),
+		ClientSecret: viper.GetString("client-secret"),
+		RedirectHost: viper.GetString("redirect-host"),
+		RedirectPort: viper.GetInt("redirect-port"),
+		RedirectPath: redirectPath,
+		NoBrowser:    viper.GetBool("no-browser"),
+		In:           cmd.InOrStdin(),
+		Out:          cmd.ErrOrStderr(),
+	}
+}
+
+func LoginOAuth2(ctx context.Context, l *OAuth2Login) (t *Token, err error) {
+	state := uuid.NewString()
+	config := monzo.OAuth2ConfigWithEndpoint(l.ClientID, l.ClientSecret, l.RedirectURL(), OAuth2Endpoint())
 
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
 
+	if l.NoBrowser {
+		return LoginOAuth2Manual(ctx, l, state, config)
+	}
+
+	tokenChan := make(chan *Token, 1)
+
+	mux := http.NewServeMux()
+	mux.Handle(l.RedirectPath, CallbackHandler(ctx, state, tokenChan, config))
+
 	srv := http.Server{
-		Addr:    "127.0.0.1:54092",
-		Handler: http.HandlerFunc(CallbackHandler(ctx, state, tokenChan, config)),
+		Addr:    net.JoinHostPort(l.RedirectHost, strconv.Itoa(l.RedirectPort)),
+		Handler: mux,
 	}
 
 	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
 
 	err = browser.OpenURL(authURL)
 	if err != nil {
-		// debug
-		fmt.Fprintf(os.Stdout, "Failed to launch browser. Please copy and paste auth URL into browser:\n\n\t%s\n", authURL)
+		fmt.Fprintf(l.Out, "Failed to launch browser. Please copy and paste auth URL into browser:\n\n\t%s\n", authURL)
 	}
 
 	errChan := make(chan error, 1)
@@ -173,6 +420,81 @@ func LoginOAuth2(ctx context.Context, clientID, clientSecret string) (t *Token,
 	return
 }
 
+// LoginOAuth2Manual runs the OAuth2 flow without a browser or callback server, for use on remote/headless machines.
+//
+// The user opens the printed auth URL on any device, and pastes back either the full URL they were redirected to, or
+// the code. If only the code is pasted, the state is asked for separately so it can still be verified.
+func LoginOAuth2Manual(ctx context.Context, l *OAuth2Login, state string, config *oauth2.Config) (t *Token, err error) {
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	reader := bufio.NewReader(l.In)
+
+	fmt.Fprintf(l.Out, "Open the following URL in a browser on any device and log in:\n\n\t%s\n\n", authURL)
+	fmt.Fprintf(l.Out, "You will be redirected to %s, which may fail to load. Paste the full URL from the address bar (or just the code):\n", config.RedirectURL)
+
+	input, err := readLine(reader)
+	if err != nil {
+		return
+	}
+
+	code, reqState := ParseAuthRedirect(input)
+
+	if code != "" && reqState == "" {
+		fmt.Fprintln(l.Out, "Paste the state parameter from the redirected URL:")
+
+		if reqState, err = readLine(reader); err != nil {
+			return
+		}
+	}
+
+	if code == "" {
+		return nil, ErrLoginCodeMissing
+	}
+
+	if reqState != state {
+		return nil, ErrLoginStateMismatch
+	}
+
+	innerToken, err := config.Exchange(ctx, code, oauth2.AccessTypeOffline)
+	if err != nil {
+		return
+	}
+
+	return &Token{
+		Token:        innerToken,
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+	}, nil
+}
+
+// ParseAuthRedirect extracts the code and state from a pasted redirect URL or query string. If the input is not a URL or
+// query string, it is treated as a bare code.
+func ParseAuthRedirect(input string) (code, state string) {
+	input = strings.TrimSpace(input)
+
+	if !strings.Contains(input, "code=") {
+		return input, ""
+	}
+
+	query := input
+	if i := strings.Index(input, "?"); i != -1 {
+		query = input[i+1:]
+	}
+
+	values, _ := url.ParseQuery(query)
+
+	return values.Get("code"), values.Get("state")
+}
+
+// readLine reads a single line of input, without the trailing newline.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+
+	return strings.TrimSpace(line), err
+}
+
 func CallbackHandler(ctx context.Context, state string, tokenChan chan *Token, config *oauth2.Config) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		code, reqState := r.URL.Query().Get("code"), r.URL.Query().Get("state")
@@ -205,44 +527,39 @@ func CallbackHandler(ctx context.Context, state string, tokenChan chan *Token, c
 }
 
 func refreshTokenPreRunE(cmd *cobra.Command, args []string) (err error) {
-	token := &Token{}
-	err = LoadCache(CacheFileToken, token)
+	token, err := LoadToken(CurrentProfile())
 	if err != nil {
 		return
 	}
 
-	if token.ClientID == "" || token.ClientSecret == "" || token.RefreshToken == "" {
-		return errors.New("cannot refresh - missing client id, client secret, or refresh token")
+	if !token.CanRefresh() {
+		return fmt.Errorf("cannot refresh - %s client tokens cannot be refreshed, missing client id or refresh token", token.ClientType())
 	}
 
 	return nil
 }
 
 func refreshTokenRunE(cmd *cobra.Command, args []string) (err error) {
-	token := &Token{}
-	LoadCache(CacheFileToken, token)
-
 	err = _client.RefreshToken()
 	if err != nil {
 		return
 	}
 
-	token.Token, err = _client.Token()
+	token, err := _client.Token()
 	if err != nil {
 		return
 	}
 
 	fmt.Fprintf(cmd.OutOrStdout(), "Token refreshed, new expiry: %s", token.Expiry.String())
 
-	return token.Save()
+	return
 }
 
 func logoutRunE(cmd *cobra.Command, args []string) error {
-	token := &Token{}
-	if err := LoadCache(CacheFileToken, token); err == nil {
+	if token, err := LoadToken(CurrentProfile()); err == nil {
 		_client = BuildClient(cmd.Context(), token)
 		_client.LogOut()
 	}
 
-	return os.RemoveAll(viper.GetString("home-dir"))
+	return os.RemoveAll(ProfileDir(CurrentProfile()))
 }
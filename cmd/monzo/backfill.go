@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// BackfillBackground starts a detached monzo sync to fetch the full history, so login returns straight away.
+	BackfillBackground = "background"
+
+	// BackfillForeground fetches the full history before login returns, printing its progress.
+	BackfillForeground = "foreground"
+
+	// BackfillOff does not fetch the full history after login.
+	BackfillOff = "off"
+
+	// SyncLogFile is the file in each profile directory that a background sync writes its progress to.
+	SyncLogFile = "sync.log"
+
+	// FullHistoryWindow is how long after authorisation the Monzo API allows a client to fetch an account's
+	// entire transaction history. After this, only the last 90 days of transactions are accessible.
+	FullHistoryWindow = time.Minute * 5
+
+	// RecentHistory is how far back transactions can be fetched once the full history window has passed.
+	RecentHistory = time.Hour * 24 * 90
+)
+
+var (
+	BackfillModes = []string{BackfillBackground, BackfillForeground, BackfillOff}
+
+	ErrBackfillModeInvalid = fmt.Errorf("backfill mode invalid. valid modes [%s]", strings.Join(BackfillModes, ", "))
+)
+
+// BackfillMode returns the mode selected with --backfill. --no-backfill is an alias for --backfill=off.
+func BackfillMode() (string, error) {
+	if viper.GetBool("no-backfill") {
+		return BackfillOff, nil
+	}
+
+	mode := viper.GetString("backfill")
+
+	for _, m := range BackfillModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+
+	return "", ErrBackfillModeInvalid
+}
+
+// InFullHistoryWindow reports whether the token was authorised recently enough to fetch the full transaction history.
+func (t *Token) InFullHistoryWindow() bool {
+	return !t.AuthorisedAt.IsZero() && time.Since(t.AuthorisedAt) < FullHistoryWindow
+}
+
+// HistoryFrom returns the earliest creation time of the transactions the token can fetch at the time, or zero if it is
+// inside the full history window.
+func (t *Token) HistoryFrom(at time.Time) time.Time {
+	if t != nil && t.InFullHistoryWindow() {
+		return time.Time{}
+	}
+
+	return at.Add(-RecentHistory)
+}
+
+// Backfill syncs the entire transaction history of every account into the cache, with each account synced concurrently
+// (see Syncer.SyncAccounts).
+//
+// Progress is written to w as each page of transactions is stored. As the cache is flushed every few pages, an
+// interrupted backfill can be resumed with the sync command (while still inside the full history window).
+func Backfill(w io.Writer, c *monzo.Client, token *Token) (err error) {
+	accounts, err := c.Accounts.List()
+	if err != nil {
+		return
+	}
+
+	syncer, err := NewSyncer(c, token)
+	if err != nil {
+		return
+	}
+
+	counts := map[string]int{}
+
+	syncer.Progress = func(accountID string, page *monzo.TransactionList) {
+		counts[accountID] += len(page.Transactions)
+
+		fmt.Fprintf(w, "Backfilling %s: %d transactions, back to %s\n", accountID, counts[accountID],
+			formatDate(syncer.State.Account(accountID).EarliestTransactionCreated))
+	}
+
+	fmt.Fprintf(w, "Fetching full transaction history for %d account(s), this must complete by %s...\n",
+		len(accounts.Accounts), token.AuthorisedAt.Add(FullHistoryWindow).Local().Format(time.Kitchen))
+
+	accountIDs := []string{}
+
+	for _, acc := range accounts.Accounts {
+		accountIDs = append(accountIDs, acc.ID)
+	}
+
+	_, err = syncer.SyncAccounts(accountIDs)
+
+	fmt.Fprintf(w, "\nCached history:\n%s", DescribeCacheCoverage(syncer.State, accounts.Accounts))
+
+	return
+}
+
+// StartBackgroundSync starts monzo sync for the token's profile as a detached process, so the full history can be
+// fetched inside the full history window without keeping login waiting. It is started while holding the profile lock,
+// and its progress is written to the profile's sync log, which is shown by monzo sync status.
+//
+// The cache passphrase (if the cache is encrypted) is passed to the process in its environment, as it cannot prompt for
+// it.
+func StartBackgroundSync(cmd *cobra.Command, token *Token) (err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+
+	args := []string{"sync", "--progress", "--profile", token.Profile()}
+
+	for _, name := range []string{"config", "api-url", "auth-url", "token-url"} {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			args = append(args, fmt.Sprintf("--%s=%s", name, flag.Value.String()))
+		}
+	}
+
+	process := exec.Command(exe, args...)
+	process.Env = os.Environ()
+
+	if CacheEncryptionEnabled() {
+		passphrase, err := CachePassphrase(false)
+		if err != nil {
+			return err
+		}
+
+		process.Env = append(process.Env, "MONZO_CACHE_PASSPHRASE="+string(passphrase))
+	}
+
+	detach(process)
+
+	err = WithProfileLock(token.Profile(), func() (err error) {
+		log, err := os.OpenFile(path.Join(ProfileDir(token.Profile()), SyncLogFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, CacheFilePerm)
+		if err != nil {
+			return
+		}
+
+		defer log.Close()
+
+		fmt.Fprintf(log, "Sync started by monzo login at %s, to fetch the full history by %s\n",
+			time.Now().Local().Format(time.RFC1123), token.AuthorisedAt.Add(FullHistoryWindow).Local().Format(time.Kitchen))
+
+		process.Stdout, process.Stderr = log, log
+
+		if err = process.Start(); err != nil {
+			return
+		}
+
+		return process.Process.Release()
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to start background sync - %w", err)
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Fetching the full transaction history in the background, which must complete by %s. "+
+		"Run monzo sync status to check on it.\n", token.AuthorisedAt.Add(FullHistoryWindow).Local().Format(time.Kitchen))
+
+	return
+}
+
+// DescribeCacheCoverage returns a human readable summary of how far back the cached history of each account goes.
+func DescribeCacheCoverage(state SyncState, accounts []monzo.Account) (summary string) {
+	for _, acc := range accounts {
+		earliest := state.Account(acc.ID).EarliestTransactionCreated
+
+		if earliest.IsZero() {
+			summary += fmt.Sprintf("  %s (%s): no transactions cached\n", acc.ID, acc.Description)
+			continue
+		}
+
+		summary += fmt.Sprintf("  %s (%s): cached back to %s\n", acc.ID, acc.Description, formatDate(earliest))
+	}
+
+	return
+}
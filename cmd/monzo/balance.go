@@ -1,40 +1,56 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-
+	"github.com/arylatt/go-monzo"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
 	balance = &cobra.Command{
-		Use:     "balance --account-id",
+		Use:     "balance [--account-id account]",
 		Short:   "Returns balance information for a specific account.",
 		GroupID: "balance",
 		RunE:    balanceRunE,
+
+		Annotations: map[string]string{annotationOffline: "true"},
 	}
 )
 
 func init() {
 	balance.Flags().AddFlagSet(FlagSets["account"])
+	balance.RegisterFlagCompletionFunc("account-id", completeAccounts)
 
 	root.AddGroup(&cobra.Group{ID: "balance", Title: "Balance"})
 	root.AddCommand(balance)
 }
 
 func balanceRunE(cmd *cobra.Command, args []string) (err error) {
-	balance, err := _client.Balance.Get(viper.GetString("account-id"))
+	accountID, err := ResolveAccount(cmd)
 	if err != nil {
 		return
 	}
 
-	data, err := json.MarshalIndent(balance, "", "  ")
+	if Offline() {
+		balances := map[string]*Snapshot[*monzo.Balance]{}
+		LoadCache(CacheFileBalances, &balances)
+
+		if balances[accountID] == nil {
+			return ErrOfflineNotCached
+		}
+
+		reportOffline(cmd, "balance", balances[accountID].Fetched)
+
+		return Output(cmd, balances[accountID].Data)
+	}
+
+	balance, err := _client.Balance.Get(accountID)
 	if err != nil {
 		return
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
-	return
+	if err = saveBalanceSnapshot(accountID, balance); err != nil {
+		return
+	}
+
+	return Output(cmd, balance)
 }
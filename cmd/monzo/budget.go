@@ -0,0 +1,630 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configKeyBudgets is the config file section defining budgets, by name.
+	configKeyBudgets = "budgets"
+
+	BudgetPeriodMonth  = "month"
+	BudgetPeriodPayday = "payday"
+
+	// DefaultBudgetAlert is the percentage of a budget that must be spent before an alert is posted, unless the budget
+	// sets its own.
+	DefaultBudgetAlert = 100
+
+	BudgetStatusOK      = "ok"
+	BudgetStatusAtRisk  = "at-risk"
+	BudgetStatusWarning = "warning"
+	BudgetStatusOver    = "over"
+)
+
+var (
+	budgetCmd = &cobra.Command{
+		Use:     "budget",
+		Short:   "Track spending against budgets defined in the config file",
+		GroupID: "reports",
+	}
+
+	budgetStatusCmd = &cobra.Command{
+		Use:     "status [budget...]",
+		Short:   "Show how much of each budget has been spent, and the projected spending by the end of the period",
+		PreRunE: budgetStatusPreRunE,
+		RunE:    budgetStatusRunE,
+
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	budgetAlertCmd = &cobra.Command{
+		Use:     "alert",
+		Short:   "Read a webhook payload from stdin, and post a feed item for each budget the transaction pushes over its alert threshold",
+		PreRunE: budgetAlertPreRunE,
+		RunE:    budgetAlertRunE,
+		Args:    cobra.NoArgs,
+	}
+
+	BudgetPeriods = []string{BudgetPeriodMonth, BudgetPeriodPayday}
+
+	ErrBudgetInvalid = errors.New("budget invalid")
+
+	ErrBudgetNotFound = errors.New("budget not found")
+
+	ErrBudgetsNotConfigured = fmt.Errorf("no budgets are configured, add them to the %s section of the config file", configKeyBudgets)
+
+	ErrBudgetImageURLRequired = errors.New("--feed-image-url must be set to post budget alerts to the feed")
+)
+
+func init() {
+	FlagSets["budget"] = pflag.NewFlagSet("budget", pflag.ContinueOnError)
+	FlagSets["budget"].String("feed-image-url", "", "URL of the image shown on budget alert feed items")
+	viper.BindPFlags(FlagSets["budget"])
+
+	budgetStatusCmd.Flags().AddFlagSet(FlagSets["account"])
+	budgetStatusCmd.Flags().AddFlagSet(FlagSets["cache"])
+	budgetStatusCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+	budgetStatusCmd.ValidArgsFunction = completeBudgets
+
+	budgetAlertCmd.Flags().AddFlagSet(FlagSets["budget"])
+	budgetAlertCmd.Flags().AddFlagSet(FlagSets["cache"])
+
+	root.AddCommand(budgetCmd)
+
+	budgetCmd.AddCommand(budgetStatusCmd)
+	budgetCmd.AddCommand(budgetAlertCmd)
+}
+
+// Budget is a limit on spending in a category or at a merchant, over each month or pay cycle.
+type Budget struct {
+	Name string `mapstructure:"-"`
+
+	// Account is the account the budget applies to (an ID, alias, type or description). If it is not set, the budget
+	// applies to the default account (--account-id).
+	Account string `mapstructure:"account"`
+
+	// Category and Merchant select the spending that counts towards the budget. Merchant matches the merchant's group
+	// ID, ID or name. If both are set, both must match.
+	Category string `mapstructure:"category"`
+	Merchant string `mapstructure:"merchant"`
+
+	// Amount is the budget for each period, in major units (e.g. 250.00).
+	Amount string `mapstructure:"amount"`
+
+	// Period is month (calendar months) or payday (starting on Payday each month).
+	Period string `mapstructure:"period"`
+	Payday int    `mapstructure:"payday"`
+
+	// Alert is the percentage of the budget spent at which an alert is posted.
+	Alert int `mapstructure:"alert"`
+}
+
+// LoadBudgets returns the budgets defined in the config file, ordered by name.
+func LoadBudgets() (budgets []Budget, err error) {
+	defined := map[string]Budget{}
+	if err = viper.UnmarshalKey(configKeyBudgets, &defined); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBudgetInvalid, err.Error())
+	}
+
+	for name, budget := range defined {
+		budget.Name = name
+
+		if budget.Period == "" {
+			budget.Period = BudgetPeriodMonth
+		}
+
+		if budget.Alert == 0 {
+			budget.Alert = DefaultBudgetAlert
+		}
+
+		if err = budget.validate(); err != nil {
+			return nil, err
+		}
+
+		budgets = append(budgets, budget)
+	}
+
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Name < budgets[j].Name })
+
+	return
+}
+
+func (b Budget) validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w '%s': %s", ErrBudgetInvalid, b.Name, reason)
+	}
+
+	switch {
+	case b.Category == "" && b.Merchant == "":
+		return invalid("category or merchant must be set")
+	case b.Period != BudgetPeriodMonth && b.Period != BudgetPeriodPayday:
+		return invalid(fmt.Sprintf("period must be one of [%s]", strings.Join(BudgetPeriods, ", ")))
+	case b.Period == BudgetPeriodPayday && (b.Payday < 1 || b.Payday > 31):
+		return invalid("payday must be a day of the month (1-31)")
+	case b.Alert < 0:
+		return invalid("alert must be a percentage")
+	}
+
+	if amount, err := ParseAmount(b.Amount, ""); err != nil || amount <= 0 {
+		return invalid(fmt.Sprintf("amount must be a positive decimal number, not '%s'", b.Amount))
+	}
+
+	return nil
+}
+
+// accountRef returns the reference of the account the budget applies to, or an empty string if no account is set.
+func (b Budget) accountRef() string {
+	return strings.TrimSpace(firstNonEmpty(b.Account, viper.GetString("account-id")))
+}
+
+// PeriodAt returns the budget period containing the time: the calendar month, or the pay cycle starting on the payday
+// (or the last day of shorter months).
+func (b Budget) PeriodAt(t time.Time) Period {
+	if b.Period != BudgetPeriodPayday {
+		return UnitPeriod(PeriodMonth, t)
+	}
+
+	t = t.In(time.Local)
+
+	payday := func(year int, month time.Month) time.Time {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.Local).Day()
+		if day := b.Payday; day < last {
+			last = day
+		}
+
+		return time.Date(year, month, last, 0, 0, 0, 0, time.Local)
+	}
+
+	start := payday(t.Year(), t.Month())
+	if t.Before(start) {
+		start = payday(t.Year(), t.Month()-1)
+	}
+
+	return Period{From: start, To: payday(start.Year(), start.Month()+1), unit: PeriodCustom}
+}
+
+// Spent returns how much of the transaction counts towards the budget, with money spent positive and refunds negative.
+func (b Budget) Spent(tx monzo.Transaction) (spent int64) {
+	if b.Merchant != "" {
+		merchant := strings.ToLower(b.Merchant)
+
+		found := false
+		for _, value := range []string{tx.Merchant.GroupID, tx.Merchant.ID, tx.Merchant.Name} {
+			found = found || (value != "" && strings.ToLower(value) == merchant)
+		}
+
+		if !found {
+			return 0
+		}
+	}
+
+	if b.Category == "" {
+		return -tx.Amount
+	}
+
+	for _, share := range spendingShares(SpendingByCategory, tx) {
+		if share.key == b.Category {
+			spent -= share.amount
+		}
+	}
+
+	return
+}
+
+// BudgetStatus is the spending against a budget in its current period.
+type BudgetStatus struct {
+	Name      string `json:"name"`
+	AccountID string `json:"account_id"`
+	Category  string `json:"category,omitempty"`
+	Merchant  string `json:"merchant,omitempty"`
+	Period    Period `json:"period"`
+	Currency  string `json:"currency"`
+
+	// Amounts are in minor units.
+	Amount    int64 `json:"amount"`
+	Spent     int64 `json:"spent"`
+	Remaining int64 `json:"remaining"`
+
+	// Projected is the spending by the end of the period, if it continues at the rate so far.
+	Projected int64 `json:"projected"`
+
+	// Status is ok, at-risk (projected to go over), warning (over the alert threshold) or over.
+	Status string `json:"status"`
+
+	alert int
+}
+
+var (
+	budgetDefaults = []string{"name", "spent", "amount", "percent", "remaining", "projected", "status"}
+
+	budgetColumns = []Column{
+		{"name", func(r any) string { return r.(BudgetStatus).Name }},
+		{"account", func(r any) string { return r.(BudgetStatus).AccountID }},
+		{"category", func(r any) string { return r.(BudgetStatus).Category }},
+		{"merchant", func(r any) string { return r.(BudgetStatus).Merchant }},
+		{"from", func(r any) string { return formatDate(r.(BudgetStatus).Period.From) }},
+		{"to", func(r any) string { return formatDate(r.(BudgetStatus).Period.To.Add(-time.Nanosecond)) }},
+		{"amount", func(r any) string { b := r.(BudgetStatus); return FormatAmount(b.Amount, b.Currency) }},
+		{"spent", func(r any) string { b := r.(BudgetStatus); return FormatAmount(b.Spent, b.Currency) }},
+		{"percent", func(r any) string { return r.(BudgetStatus).PercentSpent() }},
+		{"remaining", func(r any) string { b := r.(BudgetStatus); return FormatAmount(b.Remaining, b.Currency) }},
+		{"projected", func(r any) string { b := r.(BudgetStatus); return FormatAmount(b.Projected, b.Currency) }},
+		{"currency", func(r any) string { return r.(BudgetStatus).Currency }},
+		{"status", func(r any) string { return r.(BudgetStatus).Status }},
+	}
+)
+
+// BudgetReport is the status of each budget.
+type BudgetReport struct {
+	Budgets []BudgetStatus `json:"budgets"`
+}
+
+// Table returns the table layout of the report, with a row per budget.
+func (r *BudgetReport) Table() *Table {
+	return &Table{Columns: budgetColumns, Defaults: budgetDefaults, Rows: rowsOf(r.Budgets)}
+}
+
+// NewBudgetStatus returns the status of the budget at the time, from the account's transactions (which must include
+// every transaction in the budget's current period).
+func NewBudgetStatus(b Budget, accountID, currency string, txs []monzo.Transaction, now time.Time) BudgetStatus {
+	amount, _ := ParseAmount(b.Amount, currency)
+
+	s := BudgetStatus{
+		Name:      b.Name,
+		AccountID: accountID,
+		Category:  b.Category,
+		Merchant:  b.Merchant,
+		Period:    b.PeriodAt(now),
+		Currency:  currency,
+		Amount:    amount,
+		alert:     b.Alert,
+	}
+
+	for _, tx := range txs {
+		if s.Period.Contains(tx.CreatedTime()) && tx.CreatedTime().Before(now) && CountsAsSpending(tx, false) {
+			s.Spent += b.Spent(tx)
+		}
+	}
+
+	s.Remaining = s.Amount - s.Spent
+
+	// Spending is projected by day, so a large purchase early in the day does not dominate the projection.
+	days := math.Round(s.Period.To.Sub(s.Period.From).Hours() / 24)
+	elapsed := math.Ceil(now.Sub(s.Period.From).Hours() / 24)
+
+	s.Projected = s.Spent
+	if elapsed > 0 && elapsed < days {
+		s.Projected = int64(math.Round(float64(s.Spent) * days / elapsed))
+	}
+
+	switch {
+	case s.Spent > s.Amount:
+		s.Status = BudgetStatusOver
+	case s.Spent*100 >= s.Amount*int64(s.alert):
+		s.Status = BudgetStatusWarning
+	case s.Projected > s.Amount:
+		s.Status = BudgetStatusAtRisk
+	default:
+		s.Status = BudgetStatusOK
+	}
+
+	return s
+}
+
+// PercentSpent returns the percentage of the budget that has been spent, e.g. 85%.
+func (s BudgetStatus) PercentSpent() string {
+	if s.Amount <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d%%", s.Spent*100/s.Amount)
+}
+
+// OverAlert reports whether the spending has reached the budget's alert threshold.
+func (s BudgetStatus) OverAlert() bool {
+	return s.Spent*100 >= s.Amount*int64(s.alert)
+}
+
+// selectBudgets returns the configured budgets with the names, or every budget if no names are given.
+func selectBudgets(names []string) (selected []Budget, err error) {
+	budgets, err := LoadBudgets()
+	if err != nil {
+		return
+	}
+
+	if len(budgets) == 0 {
+		return nil, ErrBudgetsNotConfigured
+	}
+
+	if len(names) == 0 {
+		return budgets, nil
+	}
+
+	for _, name := range names {
+		found := false
+
+		for _, budget := range budgets {
+			if budget.Name == name {
+				selected = append(selected, budget)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w '%s'", ErrBudgetNotFound, name)
+		}
+	}
+
+	return
+}
+
+func completeBudgets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	budgets, _ := LoadBudgets()
+
+	names := []string{}
+	for _, budget := range budgets {
+		names = append(names, budget.Name)
+	}
+
+	return filterCompletions(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func budgetStatusPreRunE(cmd *cobra.Command, args []string) (err error) {
+	if _, err = CacheMode(); err != nil {
+		return
+	}
+
+	_, err = selectBudgets(args)
+	return
+}
+
+func budgetStatusRunE(cmd *cobra.Command, args []string) (err error) {
+	budgets, err := selectBudgets(args)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	report := &BudgetReport{Budgets: []BudgetStatus{}}
+
+	// Budgets on the same account share its transactions, which are loaded once from the earliest period start.
+	type accountTransactions struct {
+		since time.Time
+		txs   []monzo.Transaction
+	}
+
+	accounts := map[string]*accountTransactions{}
+
+	for _, budget := range budgets {
+		accountID, err := budgetAccount(cmd, budget)
+		if err != nil {
+			return err
+		}
+
+		period := budget.PeriodAt(now)
+
+		loaded := accounts[accountID]
+		if loaded == nil || period.From.Before(loaded.since) {
+			loaded = &accountTransactions{since: period.From}
+
+			if loaded.txs, err = transactionsSince(cmd, accountID, period.From); err != nil {
+				return err
+			}
+
+			accounts[accountID] = loaded
+		}
+
+		report.Budgets = append(report.Budgets, NewBudgetStatus(budget, accountID, transactionsCurrency(loaded.txs), loaded.txs, now))
+	}
+
+	return Output(cmd, report)
+}
+
+// transactionsCurrency returns the currency of the transactions, which is the account's currency.
+func transactionsCurrency(txs []monzo.Transaction) string {
+	for _, tx := range txs {
+		if tx.Currency != "" {
+			return tx.Currency
+		}
+	}
+
+	return "GBP"
+}
+
+// budgetAccount resolves the account the budget applies to, prompting for it if neither the budget nor --account-id
+// set it.
+func budgetAccount(cmd *cobra.Command, b Budget) (string, error) {
+	if ref := b.accountRef(); ref != "" {
+		return ResolveAccountRef(ref)
+	}
+
+	return ResolveAccount(cmd)
+}
+
+// BudgetAlerts returns the status of each budget that a transaction delivered by a webhook pushed over its alert
+// threshold, given the version of the transaction seen before (if any). The transaction's spending is compared with the
+// other transactions in the store, whether or not the transaction has been stored yet. Budgets without an account apply
+// to the transaction's account.
+func BudgetAlerts(store Store, budgets []Budget, tx monzo.Transaction, previous *monzo.Transaction) (alerts []BudgetStatus, err error) {
+	// The status is checked just after the transaction, so later transactions (if any) do not count, and a webhook
+	// delivered late is checked against the period the transaction was made in.
+	now := tx.CreatedTime().Add(time.Nanosecond)
+
+	for _, budget := range budgets {
+		if ref := budget.accountRef(); ref != "" {
+			accountID, err := ResolveAccountRef(ref)
+			if err != nil {
+				return nil, err
+			}
+
+			if accountID != tx.AccountID {
+				continue
+			}
+		}
+
+		period := budget.PeriodAt(now)
+		if !period.Contains(tx.CreatedTime()) {
+			continue
+		}
+
+		stored, err := store.Range(tx.AccountID, StoreQuery{Since: period.From, Before: period.To})
+		if err != nil {
+			return nil, err
+		}
+
+		others := []monzo.Transaction{}
+		for _, other := range stored {
+			if other.ID != tx.ID {
+				others = append(others, other)
+			}
+		}
+
+		before := others
+		if previous != nil {
+			before = append(append([]monzo.Transaction{}, others...), *previous)
+		}
+
+		after := NewBudgetStatus(budget, tx.AccountID, tx.Currency, append(others, tx), now)
+
+		if after.OverAlert() && !NewBudgetStatus(budget, tx.AccountID, tx.Currency, before, now).OverAlert() {
+			alerts = append(alerts, after)
+		}
+	}
+
+	return
+}
+
+// budgetFeedItem returns the feed item alerting that the budget has reached its threshold.
+func budgetFeedItem(s BudgetStatus, imageURL string) monzo.FeedItem {
+	title := fmt.Sprintf("%s budget: %s spent", s.Name, s.PercentSpent())
+	if s.Status == BudgetStatusOver {
+		title = fmt.Sprintf("%s budget: over by %s", s.Name, FormatAmount(-s.Remaining, s.Currency))
+	}
+
+	return monzo.FeedItem{
+		AccountID: s.AccountID,
+		Type:      monzo.FeedTypeBasic,
+		Params: monzo.FeedItemParamsBasic{
+			Title:    title,
+			ImageURL: imageURL,
+			Body: fmt.Sprintf("%s of %s spent this period, which ends on %s.",
+				FormatAmount(s.Spent, s.Currency), FormatAmount(s.Amount, s.Currency), formatDate(s.Period.To.Add(-time.Nanosecond))),
+		},
+	}
+}
+
+// postBudgetAlerts checks the budgets against a transaction delivered by a webhook, given the version of it seen before
+// (if any), and posts a feed item for each budget it pushed over its alert threshold.
+//
+// The transactions in the budgets' periods are loaded as budget status loads them (fetching them where the cache mode
+// requires), so the transaction is not compared with an incomplete or outdated cache.
+func postBudgetAlerts(cmd *cobra.Command, tx monzo.Transaction, previous *monzo.Transaction) (alerts []BudgetStatus, err error) {
+	imageURL := viper.GetString("feed-image-url")
+	if imageURL == "" {
+		return nil, ErrBudgetImageURLRequired
+	}
+
+	budgets, err := LoadBudgets()
+	if err != nil || len(budgets) == 0 {
+		return
+	}
+
+	since := tx.CreatedTime()
+	for _, budget := range budgets {
+		if from := budget.PeriodAt(since).From; from.Before(since) {
+			since = from
+		}
+	}
+
+	txs, err := transactionsSince(cmd, tx.AccountID, since)
+	if err != nil {
+		return
+	}
+
+	store := NewMemoryStore()
+	if _, _, err = store.Upsert(tx.AccountID, txs...); err != nil {
+		return
+	}
+
+	if alerts, err = BudgetAlerts(store, budgets, tx, previous); err != nil {
+		return
+	}
+
+	for _, alert := range alerts {
+		if err = _client.Feed.Create(budgetFeedItem(alert, imageURL)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func budgetAlertPreRunE(cmd *cobra.Command, args []string) (err error) {
+	_, err = CacheMode()
+	return
+}
+
+func budgetAlertRunE(cmd *cobra.Command, args []string) (err error) {
+	payload, err := readWebhookPayload(cmd.InOrStdin())
+	if err != nil {
+		return
+	}
+
+	report := &BudgetReport{Budgets: []BudgetStatus{}}
+
+	if strings.HasPrefix(payload.Type, "transaction.") && payload.Data.ID != "" {
+		previous, err := storeWebhookTransaction(payload.Data)
+		if err != nil {
+			return err
+		}
+
+		if report.Budgets, err = postBudgetAlerts(cmd, payload.Data, previous); err != nil {
+			return err
+		}
+	}
+
+	if report.Budgets == nil {
+		report.Budgets = []BudgetStatus{}
+	}
+
+	return Output(cmd, report)
+}
+
+// storeWebhookTransaction records a transaction delivered by a webhook in the transaction store, and returns the version
+// of it stored before, if any.
+func storeWebhookTransaction(tx monzo.Transaction) (previous *monzo.Transaction, err error) {
+	store, err := openCaches.Store()
+	if err != nil {
+		return
+	}
+
+	if previous, err = store.Get(tx.ID); err != nil {
+		return
+	}
+
+	_, _, err = store.Upsert(tx.AccountID, tx)
+	return
+}
+
+// readWebhookPayload decodes a webhook payload, as delivered by Monzo.
+func readWebhookPayload(r io.Reader) (payload *monzo.WebhookPayload, err error) {
+	payload = &monzo.WebhookPayload{}
+
+	if err = json.NewDecoder(r).Decode(payload); err != nil {
+		return nil, fmt.Errorf("webhook payload invalid - %w", err)
+	}
+
+	return
+}
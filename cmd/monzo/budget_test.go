@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+// localTime returns midnight on the date in the local time zone, plus the hours.
+func localTime(year int, month time.Month, day, hours int) time.Time {
+	return time.Date(year, month, day, hours, 0, 0, 0, time.Local)
+}
+
+// spending returns a transaction on acc_1 that counts as spending, created at the time.
+func spending(id string, amount int64, category string, created time.Time) monzo.Transaction {
+	return monzo.Transaction{ID: id, AccountID: "acc_1", Amount: amount, Category: category, Created: created.UTC().Format(time.RFC3339Nano), IncludeInSpending: true}
+}
+
+func TestBudgetPeriodAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   Budget
+		at       time.Time
+		from, to time.Time
+	}{
+		{"month", Budget{Period: BudgetPeriodMonth}, localTime(2023, 2, 15, 12), localTime(2023, 2, 1, 0), localTime(2023, 3, 1, 0)},
+		{"payday after", Budget{Period: BudgetPeriodPayday, Payday: 25}, localTime(2023, 3, 25, 0), localTime(2023, 3, 25, 0), localTime(2023, 4, 25, 0)},
+		{"payday before", Budget{Period: BudgetPeriodPayday, Payday: 25}, localTime(2023, 3, 24, 23), localTime(2023, 2, 25, 0), localTime(2023, 3, 25, 0)},
+		{"payday 29 in February", Budget{Period: BudgetPeriodPayday, Payday: 29}, localTime(2023, 2, 28, 12), localTime(2023, 2, 28, 0), localTime(2023, 3, 29, 0)},
+		{"payday 29 in a leap year", Budget{Period: BudgetPeriodPayday, Payday: 29}, localTime(2024, 2, 28, 12), localTime(2024, 1, 29, 0), localTime(2024, 2, 29, 0)},
+		{"payday 30 before February ends", Budget{Period: BudgetPeriodPayday, Payday: 30}, localTime(2023, 2, 27, 12), localTime(2023, 1, 30, 0), localTime(2023, 2, 28, 0)},
+		{"payday 31 in April", Budget{Period: BudgetPeriodPayday, Payday: 31}, localTime(2023, 4, 30, 12), localTime(2023, 4, 30, 0), localTime(2023, 5, 31, 0)},
+		{"payday 31 in March", Budget{Period: BudgetPeriodPayday, Payday: 31}, localTime(2023, 3, 30, 12), localTime(2023, 2, 28, 0), localTime(2023, 3, 31, 0)},
+		{"payday in January", Budget{Period: BudgetPeriodPayday, Payday: 15}, localTime(2024, 1, 10, 12), localTime(2023, 12, 15, 0), localTime(2024, 1, 15, 0)},
+		{"payday in December", Budget{Period: BudgetPeriodPayday, Payday: 20}, localTime(2023, 12, 25, 12), localTime(2023, 12, 20, 0), localTime(2024, 1, 20, 0)},
+	}
+
+	for _, test := range tests {
+		period := test.budget.PeriodAt(test.at)
+
+		assert.Equal(t, test.from, period.From, test.name)
+		assert.Equal(t, test.to, period.To, test.name)
+	}
+}
+
+func TestBudgetSpent(t *testing.T) {
+	split := monzo.Transaction{Amount: -4000, Category: "groceries", Categories: map[string]int64{"groceries": -3000, "eating_out": -1000}}
+	split.Merchant.Name = "Tesco"
+
+	tests := []struct {
+		name     string
+		budget   Budget
+		tx       monzo.Transaction
+		expected int64
+	}{
+		{"category", Budget{Category: "groceries"}, monzo.Transaction{Amount: -1250, Category: "groceries"}, 1250},
+		{"other category", Budget{Category: "groceries"}, monzo.Transaction{Amount: -1250, Category: "transport"}, 0},
+		{"refund", Budget{Category: "groceries"}, monzo.Transaction{Amount: 500, Category: "groceries"}, -500},
+		{"uncategorised", Budget{Category: "general"}, monzo.Transaction{Amount: -100}, 100},
+		{"split category", Budget{Category: "groceries"}, split, 3000},
+		{"other split category", Budget{Category: "eating_out"}, split, 1000},
+		{"split category not in split", Budget{Category: "transport"}, split, 0},
+		{"merchant", Budget{Merchant: "tesco"}, split, 4000},
+		{"merchant and split category", Budget{Merchant: "Tesco", Category: "eating_out"}, split, 1000},
+		{"other merchant", Budget{Merchant: "Sainsbury's", Category: "groceries"}, split, 0},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.budget.Spent(test.tx), test.name)
+	}
+}
+
+func TestNewBudgetStatus(t *testing.T) {
+	budget := Budget{Name: "food", Category: "groceries", Amount: "100.00", Period: BudgetPeriodMonth, Alert: 80}
+
+	// Halfway through day 16 of 31, so spending is projected at 31/16 of the spending so far.
+	now := localTime(2023, 3, 16, 12)
+
+	tests := []struct {
+		name      string
+		txs       []monzo.Transaction
+		spent     int64
+		projected int64
+		status    string
+	}{
+		{"none", nil, 0, 0, BudgetStatusOK},
+		{"ok", []monzo.Transaction{spending("tx_1", -1000, "groceries", now.Add(-time.Hour))}, 1000, 1938, BudgetStatusOK},
+		{"at risk", []monzo.Transaction{spending("tx_1", -6000, "groceries", now.Add(-time.Hour))}, 6000, 11625, BudgetStatusAtRisk},
+		{"warning", []monzo.Transaction{spending("tx_1", -8000, "groceries", now.Add(-time.Hour))}, 8000, 15500, BudgetStatusWarning},
+		{"over", []monzo.Transaction{spending("tx_1", -12000, "groceries", now.Add(-time.Hour))}, 12000, 23250, BudgetStatusOver},
+		{"refund", []monzo.Transaction{
+			spending("tx_1", -9000, "groceries", now.Add(-time.Hour*2)),
+			spending("tx_2", 2000, "groceries", now.Add(-time.Hour)),
+		}, 7000, 13563, BudgetStatusAtRisk},
+		{"outside period", []monzo.Transaction{
+			spending("tx_1", -9000, "groceries", localTime(2023, 2, 28, 23)),
+			spending("tx_2", -9000, "groceries", now.Add(time.Hour)),
+		}, 0, 0, BudgetStatusOK},
+		{"not spending", []monzo.Transaction{
+			{ID: "tx_1", Amount: -9000, Category: "groceries", Created: now.Add(-time.Hour).Format(time.RFC3339)},
+			{ID: "tx_2", Amount: -9000, Category: "groceries", Created: now.Add(-time.Hour).Format(time.RFC3339), IncludeInSpending: true, DeclineReason: "INSUFFICIENT_FUNDS"},
+		}, 0, 0, BudgetStatusOK},
+	}
+
+	for _, test := range tests {
+		s := NewBudgetStatus(budget, "acc_1", "GBP", test.txs, now)
+
+		assert.Equal(t, localTime(2023, 3, 1, 0), s.Period.From, test.name)
+		assert.Equal(t, int64(10000), s.Amount, test.name)
+		assert.Equal(t, test.spent, s.Spent, test.name)
+		assert.Equal(t, 10000-test.spent, s.Remaining, test.name)
+		assert.Equal(t, test.projected, s.Projected, test.name)
+		assert.Equal(t, test.status, s.Status, test.name)
+	}
+}
+
+func TestBudgetAlerts(t *testing.T) {
+	budget := Budget{Name: "food", Category: "groceries", Amount: "100.00", Period: BudgetPeriodMonth, Alert: 80}
+
+	// The transactions are in a past month, as if the webhook was delivered late, so they are checked against the
+	// month they were made in.
+	created := localTime(2023, 3, 16, 12)
+
+	store := NewMemoryStore()
+	store.Upsert("acc_1", spending("tx_1", -7000, "groceries", created.Add(-time.Hour)))
+
+	alerts, err := BudgetAlerts(store, []Budget{budget}, spending("tx_2", -500, "groceries", created), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	// The transaction pushing the budget over the threshold alerts, whether or not it has been stored.
+	tx := spending("tx_3", -1000, "groceries", created.Add(time.Hour))
+
+	for _, stored := range []bool{false, true} {
+		if stored {
+			store.Upsert("acc_1", tx)
+		}
+
+		alerts, err = BudgetAlerts(store, []Budget{budget}, tx, nil)
+		assert.NoError(t, err)
+
+		if assert.Len(t, alerts, 1) {
+			assert.Equal(t, "food", alerts[0].Name)
+			assert.Equal(t, localTime(2023, 3, 1, 0), alerts[0].Period.From)
+			assert.Equal(t, int64(8000), alerts[0].Spent)
+		}
+	}
+
+	// An update to a transaction already over the threshold does not alert again.
+	updated := tx
+	updated.Amount = -1100
+
+	alerts, err = BudgetAlerts(store, []Budget{budget}, updated, &tx)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	// Nor does a later transaction.
+	alerts, err = BudgetAlerts(store, []Budget{budget}, spending("tx_4", -100, "groceries", created.Add(time.Hour*2)), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+}
@@ -2,40 +2,369 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"strings"
+	"sync"
 
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
 	CacheFileToken        = "token"
 	CacheFileTransactions = "transactions"
+	CacheFileSync         = "sync"
+
+	// CacheDirPerm and CacheFilePerm are the permissions used for cache directories and files, which may contain secrets.
+	CacheDirPerm  os.FileMode = 0700
+	CacheFilePerm os.FileMode = 0600
 )
 
+var (
+	cache = &cobra.Command{
+		Use:         "cache",
+		Short:       "Manage the profile's cache files",
+		GroupID:     "auth",
+		Annotations: map[string]string{annotationNoAuth: "true", annotationOffline: "true"},
+	}
+
+	cacheEncrypt = &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt all of the profile's cache files with a passphrase, and keep them encrypted",
+		RunE:  cacheEncryptRunE,
+		Args:  cobra.NoArgs,
+	}
+
+	cacheDecrypt = &cobra.Command{
+		Use:   "decrypt",
+		Short: "Decrypt all of the profile's cache files, and stop encrypting them",
+		RunE:  cacheDecryptRunE,
+		Args:  cobra.NoArgs,
+	}
+)
+
+func init() {
+	root.PersistentFlags().AddFlagSet(FlagSets["encryption"])
+
+	cache.AddCommand(cacheEncrypt)
+	cache.AddCommand(cacheDecrypt)
+
+	root.AddCommand(cache)
+}
+
+// LoadCache reads the named cache file of the current profile.
 func LoadCache(fileName string, out any) (err error) {
-	filePath := path.Join(viper.GetString("home-dir"), fmt.Sprintf("%s.json", fileName))
+	return LoadProfileCache(CurrentProfile(), fileName, out)
+}
+
+// SaveCache writes the named cache file of the current profile.
+func SaveCache(fileName string, in any) (err error) {
+	return SaveProfileCache(CurrentProfile(), fileName, in)
+}
+
+// LoadProfileCache reads the named cache file of the given profile, decrypting it if it is encrypted.
+func LoadProfileCache(profile, fileName string, out any) (err error) {
+	data, err := readCacheFile(profile, cachePath(profile, fileName))
+	if err != nil {
+		return
+	}
 
-	file, err := os.Open(filePath)
+	return json.Unmarshal(data, out)
+}
+
+// SaveProfileCache writes the named cache file of the given profile, encrypting it if cache encryption is enabled.
+func SaveProfileCache(profile, fileName string, in any) (err error) {
+	data, err := json.Marshal(in)
 	if err != nil {
+		return err
+	}
+
+	if CacheEncryptionEnabled() {
+		if data, err = EncryptCache(profile, path.Base(cachePath(profile, fileName)), data); err != nil {
+			return
+		}
+	}
+
+	return writeCacheFile(cachePath(profile, fileName), data)
+}
+
+func cachePath(profile, fileName string) string {
+	return path.Join(ProfileDir(profile), fmt.Sprintf("%s.json", fileName))
+}
+
+// readCacheFile returns the plaintext contents of one of the profile's cache files.
+func readCacheFile(profile, filePath string) (data []byte, err error) {
+	data, err = os.ReadFile(filePath)
+	if err != nil || !IsEncryptedCache(data) {
 		return
 	}
 
-	defer file.Close()
+	return DecryptCache(profile, path.Base(filePath), data)
+}
 
-	err = json.NewDecoder(file).Decode(&out)
+// writeCacheFile atomically replaces a cache file, ensuring it is only accessible by the current user.
+//
+// The data is written to a temporary file in the same directory, which is then renamed over the cache file, so readers
+// (and a crash part way through the write) never see a partially written file.
+func writeCacheFile(filePath string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(path.Dir(filePath), fmt.Sprintf(".%s-*.tmp", path.Base(filePath)))
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// EnsureCacheDir creates the cache directory if required, and corrects the permissions of an existing directory.
+func EnsureCacheDir(dir string) (err error) {
+	if err = os.MkdirAll(dir, CacheDirPerm); err != nil {
+		return
+	}
+
+	return os.Chmod(dir, CacheDirPerm)
+}
+
+// profileCacheFiles lists the paths of the cache files in the profile directory.
+func profileCacheFiles(profile string) (files []string, err error) {
+	entries, err := os.ReadDir(ProfileDir(profile))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		files = append(files, path.Join(ProfileDir(profile), name))
+	}
 
 	return
 }
 
-func SaveCache(fileName string, in any) (err error) {
-	filePath := path.Join(viper.GetString("home-dir"), fmt.Sprintf("%s.json", fileName))
+// setCacheEncryption enables or disables encryption of the profile's cache, and rewrites every cache file to match.
+func setCacheEncryption(cmd *cobra.Command, enabled bool) (err error) {
+	profile := CurrentProfile()
 
-	data, err := json.Marshal(in)
+	if enabled {
+		if _, err = CachePassphrase(true); err != nil {
+			return
+		}
+	}
+
+	unlock, err := LockProfile(profile)
 	if err != nil {
-		return err
+		return
 	}
 
-	return os.WriteFile(filePath, data, 0700)
+	defer unlock()
+
+	files, err := profileCacheFiles(profile)
+	if err != nil {
+		return
+	}
+
+	rewritten := len(files)
+
+	// The transaction log is encrypted record by record, so it is rewritten rather than encrypted as a whole.
+	if _, statErr := os.Stat(transactionLogPath(profile)); statErr == nil {
+		store, err := OpenLogStore(profile)
+		if err != nil {
+			return err
+		}
+
+		if err = store.Compact(enabled); err != nil {
+			return err
+		}
+
+		rewritten++
+	}
+
+	for _, filePath := range files {
+		raw, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		if IsEncryptedCache(raw) == enabled {
+			continue
+		}
+
+		data := raw
+
+		if enabled {
+			data, err = EncryptCache(profile, path.Base(filePath), raw)
+		} else {
+			data, err = DecryptCache(profile, path.Base(filePath), raw)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.Base(filePath), err)
+		}
+
+		if err = writeCacheFile(filePath, data); err != nil {
+			return err
+		}
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if enabled {
+		err = config.Set("true", configKeyProfiles, profile, "encrypt-cache")
+	} else {
+		config.Unset(configKeyProfiles, profile, "encrypt-cache")
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = config.Save(); err != nil {
+		return
+	}
+
+	viper.Set("encrypt-cache", enabled)
+
+	state := "decrypted"
+	if enabled {
+		state = "encrypted"
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Cache for profile %s %s (%d files)\n", profile, state, rewritten)
+	return
+}
+
+func cacheEncryptRunE(cmd *cobra.Command, args []string) error {
+	return setCacheEncryption(cmd, true)
+}
+
+func cacheDecryptRunE(cmd *cobra.Command, args []string) error {
+	return setCacheEncryption(cmd, false)
+}
+
+// Caches holds the transaction store and sync state opened by the running command. Changes are kept in memory, and
+// written by Flush (once, when the command completes) merged into the latest version on disk, so changes made by other
+// processes in the meantime are not lost.
+//
+// The caches must not be modified while Flush is running.
+type Caches struct {
+	mu sync.Mutex
+
+	store Store
+
+	syncState SyncState
+	syncSaved map[string][]byte
+}
+
+// openCaches are the caches of the current profile used by the running command.
+var openCaches = &Caches{}
+
+// Store returns the transaction store, opening it (and migrating it if required) on first use.
+func (c *Caches) Store() (store Store, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != nil {
+		return c.store, nil
+	}
+
+	err = WithProfileLock(CurrentProfile(), func() (err error) {
+		c.store, err = OpenLogStore(CurrentProfile())
+		return
+	})
+
+	if err != nil {
+		c.store = nil
+		return
+	}
+
+	return c.store, nil
+}
+
+// SyncState returns the sync state, loading it on first use.
+func (c *Caches) SyncState() SyncState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.syncState == nil {
+		c.syncState = SyncState{}
+		LoadCache(CacheFileSync, &c.syncState)
+
+		c.syncSaved = c.syncState.snapshot()
+	}
+
+	return c.syncState
+}
+
+// Flush writes any changes to the caches while holding the profile lock.
+func (c *Caches) Flush() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil && !c.syncState.changedSince(c.syncSaved) {
+		return
+	}
+
+	return WithProfileLock(CurrentProfile(), func() (err error) {
+		if c.store != nil {
+			if err = c.store.Flush(); err != nil {
+				return
+			}
+		}
+
+		if !c.syncState.changedSince(c.syncSaved) {
+			return
+		}
+
+		latest := SyncState{}
+		if err = LoadCache(CacheFileSync, &latest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+
+		latest.merge(c.syncState, c.syncSaved)
+
+		if err = SaveCache(CacheFileSync, latest); err != nil {
+			return
+		}
+
+		for accountID, state := range latest {
+			if c.syncState[accountID] == nil {
+				c.syncState[accountID] = state
+			} else {
+				*c.syncState[accountID] = *state
+			}
+		}
+
+		c.syncSaved = c.syncState.snapshot()
+
+		return
+	})
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// maxTransactionCompletions is the number of most recent matching transactions suggested when completing an ID.
+const maxTransactionCompletions = 100
+
+// There are no completions for pot names or webhook IDs, as no command takes them as an argument: pots are only named in
+// exports, and listen registers and deletes its own webhook. They belong here once pot or webhook commands are added.
+
+// completing reports whether the command is cobra's hidden shell completion command. Completion only reads the local
+// caches, so suggestions are instant, work offline, and never prompt for input.
+func completing(cmd *cobra.Command) bool {
+	return cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd
+}
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeFirstArg only completes the first positional argument.
+func completeFirstArg(fn completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		return fn(cmd, args, toComplete)
+	}
+}
+
+// completeAccounts suggests the cached accounts, account aliases and account type names.
+func completeAccounts(cmd *cobra.Command, args []string, toComplete string) (suggestions []string, _ cobra.ShellCompDirective) {
+	snapshot := &Snapshot[*monzo.AccountsList]{}
+	if LoadCache(CacheFileAccounts, snapshot) == nil && snapshot.Data != nil {
+		for _, acc := range openAccounts(snapshot.Data.Accounts) {
+			suggestions = append(suggestions, fmt.Sprintf("%s\t%s (%s)", acc.ID, acc.Description, acc.Type))
+		}
+	}
+
+	for alias, accountID := range viper.GetStringMapString(configKeyAliases) {
+		suggestions = append(suggestions, fmt.Sprintf("%s\talias for %s", alias, accountID))
+	}
+
+	for name, accType := range accountTypeNames {
+		suggestions = append(suggestions, fmt.Sprintf("%s\t%s account", name, accType))
+	}
+
+	return filterCompletions(suggestions, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTransactions suggests the most recent cached transactions, for the account in --account-id if it is set.
+func completeTransactions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	store, err := openCaches.Store()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	accountIDs, err := store.Accounts()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	if ref := viper.GetString("account-id"); ref != "" {
+		accountID, err := ResolveAccountRef(ref)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		accountIDs = []string{accountID}
+	}
+
+	matches := []monzo.Transaction{}
+
+	for _, accountID := range accountIDs {
+		txs, err := store.Range(accountID, StoreQuery{})
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		for _, tx := range txs {
+			if strings.HasPrefix(tx.ID, toComplete) {
+				matches = append(matches, tx)
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].CreatedTime().After(matches[j].CreatedTime())
+	})
+
+	if len(matches) > maxTransactionCompletions {
+		matches = matches[:maxTransactionCompletions]
+	}
+
+	suggestions := []string{}
+
+	for _, tx := range matches {
+		name := tx.Merchant.Name
+		if name == "" {
+			name = tx.Description
+		}
+
+		suggestions = append(suggestions, fmt.Sprintf("%s\t%s %s %s", tx.ID, formatDate(tx.CreatedTime()), name, FormatAmount(tx.Amount, tx.Currency)))
+	}
+
+	return suggestions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProfiles suggests the names of the existing profiles.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) (suggestions []string, _ cobra.ShellCompDirective) {
+	entries, _ := os.ReadDir(path.Join(viper.GetString("home-dir"), ProfilesDir))
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			suggestions = append(suggestions, entry.Name())
+		}
+	}
+
+	return filterCompletions(suggestions, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeConfigKeys suggests the settings that can be set in the config file.
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) (suggestions []string, _ cobra.ShellCompDirective) {
+	for key := range configKeys() {
+		suggestions = append(suggestions, key)
+	}
+
+	suggestions = append(suggestions, configMapKeys()...)
+
+	sort.Strings(suggestions)
+
+	return filterCompletions(suggestions, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// filterCompletions returns the suggestions starting with the text being completed.
+func filterCompletions(suggestions []string, toComplete string) (filtered []string) {
+	for _, suggestion := range suggestions {
+		if strings.HasPrefix(suggestion, toComplete) {
+			filtered = append(filtered, suggestion)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFile is the config file in home-dir, used unless --config is set.
+	ConfigFile = "config.yaml"
+
+	// configKeyAliases and configKeyProfiles are the config file sections holding account aliases, and per-profile config.
+	configKeyAliases  = "aliases"
+	configKeyProfiles = "profiles"
+)
+
+var (
+	configCmd = &cobra.Command{
+		Use:         "config",
+		Short:       "Manage the CLI configuration file",
+		GroupID:     "auth",
+		Annotations: map[string]string{annotationNoAuth: "true", annotationOffline: "true"},
+	}
+
+	configGet = &cobra.Command{
+		Use:   "get key",
+		Short: "Print the value of a setting, after applying flags, env and the config file",
+		RunE:  configGetRunE,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	configSet = &cobra.Command{
+		Use:   "set key value",
+		Short: "Set a value in the config file (e.g. output table, or aliases.joint acc_...)",
+		RunE:  configSetRunE,
+		Args:  cobra.ExactArgs(2),
+	}
+
+	configUnset = &cobra.Command{
+		Use:   "unset key",
+		Short: "Remove a value from the config file",
+		RunE:  configUnsetRunE,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	configList = &cobra.Command{
+		Use:   "list",
+		Short: "List the settings in effect, and where each value comes from",
+		RunE:  configListRunE,
+		Args:  cobra.NoArgs,
+	}
+
+	ErrConfigKeyUnknown = errors.New("unknown setting, settings are named after flags (e.g. output, account-id), or are a name in a section (e.g. aliases.<alias>)")
+
+	ErrConfigKeyNotSet = errors.New("setting not found in the config file")
+
+	// configMapSections are the config file sections that map names to values (e.g. aliases.<alias>), rather than
+	// holding flag settings.
+	configMapSections = []string{configKeyAliases}
+)
+
+func init() {
+	FlagSets["config"] = pflag.NewFlagSet("config", pflag.ContinueOnError)
+	FlagSets["config"].String("config", "", fmt.Sprintf("Config file (default: %s in the home directory)", ConfigFile))
+	viper.BindPFlags(FlagSets["config"])
+
+	root.PersistentFlags().AddFlagSet(FlagSets["config"])
+
+	for _, cmd := range []*cobra.Command{configSet, configUnset} {
+		cmd.Flags().Bool("profile-scope", false, "Change the value for the current profile only")
+	}
+
+	for _, cmd := range []*cobra.Command{configGet, configSet, configUnset} {
+		cmd.ValidArgsFunction = completeFirstArg(completeConfigKeys)
+	}
+
+	configCmd.AddCommand(configGet)
+	configCmd.AddCommand(configSet)
+	configCmd.AddCommand(configUnset)
+	configCmd.AddCommand(configList)
+
+	root.AddCommand(configCmd)
+}
+
+// ConfigSetting describes a setting for the config list command.
+type ConfigSetting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+var (
+	configDefaults = []string{"key", "value", "source"}
+
+	configColumns = []Column{
+		{"key", func(r any) string { return r.(ConfigSetting).Key }},
+		{"value", func(r any) string { return r.(ConfigSetting).Value }},
+		{"source", func(r any) string { return r.(ConfigSetting).Source }},
+	}
+)
+
+// ConfigList is the output of the config list command.
+type ConfigList struct {
+	Settings []ConfigSetting `json:"settings"`
+}
+
+// Table returns the table layout of the list, with a row per setting.
+func (l *ConfigList) Table() *Table {
+	return &Table{Columns: configColumns, Defaults: configDefaults, Rows: rowsOf(l.Settings)}
+}
+
+// Config is the YAML config file. It is edited as a document node, so comments and ordering are preserved.
+type Config struct {
+	Path string
+
+	doc *yaml.Node
+}
+
+// ConfigPath returns the path of the config file: --config (or MONZO_CONFIG), or config.yaml in home-dir.
+func ConfigPath() string {
+	if configPath := viper.GetString("config"); configPath != "" {
+		return configPath
+	}
+
+	return path.Join(viper.GetString("home-dir"), ConfigFile)
+}
+
+// LoadConfig reads the config file, which is empty if it does not exist yet.
+func LoadConfig() (c *Config, err error) {
+	c = &Config{Path: ConfigPath(), doc: &yaml.Node{Kind: yaml.DocumentNode}}
+
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+
+	if err = yaml.Unmarshal(data, c.doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s - %w", c.Path, err)
+	}
+
+	return
+}
+
+// Save writes the config file.
+func (c *Config) Save() (err error) {
+	buf := &bytes.Buffer{}
+
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	if c.root().Kind == yaml.MappingNode && len(c.root().Content) != 0 {
+		if err = enc.Encode(c.doc); err != nil {
+			return
+		}
+	}
+
+	if err = EnsureCacheDir(path.Dir(c.Path)); err != nil {
+		return
+	}
+
+	return writeCacheFile(c.Path, buf.Bytes())
+}
+
+// Get returns the node at the key path, or nil if it is not set.
+func (c *Config) Get(keys ...string) *yaml.Node {
+	node := c.root()
+
+	for _, key := range keys {
+		if node = mappingValue(node, key); node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+// Set sets the value at the key path, creating any parent mappings. The value is parsed as YAML, so numbers and booleans
+// keep their type.
+func (c *Config) Set(value string, keys ...string) (err error) {
+	var parsed any
+	if err = yaml.Unmarshal([]byte(value), &parsed); err != nil || parsed == nil {
+		parsed = value
+	}
+
+	valueNode := &yaml.Node{}
+	if err = valueNode.Encode(parsed); err != nil {
+		return
+	}
+
+	node := c.root()
+
+	for i, key := range keys {
+		next := mappingValue(node, key)
+
+		if i == len(keys)-1 {
+			if next != nil {
+				*next = *valueNode
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, valueNode)
+			}
+
+			return
+		}
+
+		if next == nil || next.Kind != yaml.MappingNode {
+			if next == nil {
+				next = &yaml.Node{}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+			}
+
+			*next = yaml.Node{Kind: yaml.MappingNode}
+		}
+
+		node = next
+	}
+
+	return
+}
+
+// Unset removes the value at the key path, and reports whether it was set. Sections left empty are removed too.
+func (c *Config) Unset(keys ...string) bool {
+	parent := c.Get(keys[:len(keys)-1]...)
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return false
+	}
+
+	for n := 0; n+1 < len(parent.Content); n += 2 {
+		if parent.Content[n].Value == keys[len(keys)-1] {
+			parent.Content = append(parent.Content[:n], parent.Content[n+2:]...)
+
+			if len(parent.Content) == 0 && len(keys) > 1 {
+				c.Unset(keys[:len(keys)-1]...)
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Values decodes the mapping at the key path (or the whole file with no keys).
+func (c *Config) Values(keys ...string) (values map[string]any) {
+	values = map[string]any{}
+
+	if node := c.Get(keys...); node != nil && node.Kind == yaml.MappingNode {
+		node.Decode(&values)
+	}
+
+	return
+}
+
+// root returns the top-level mapping of the document, creating it if the document is empty.
+func (c *Config) root() *yaml.Node {
+	if len(c.doc.Content) == 0 {
+		c.doc.Content = append(c.doc.Content, &yaml.Node{Kind: yaml.MappingNode})
+	}
+
+	return c.doc.Content[0]
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for n := 0; n+1 < len(node.Content); n += 2 {
+		if node.Content[n].Value == key {
+			return node.Content[n+1]
+		}
+	}
+
+	return nil
+}
+
+// InitConfig loads the config file into viper, below flags and env vars in precedence. The section for the current
+// profile (under profiles) takes precedence over the top-level values.
+//
+// Profile settings files from before the config file existed are migrated into it first.
+func InitConfig() (err error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if err = migrateProfileSettings(config); err != nil {
+		return
+	}
+
+	viper.SetConfigType("yaml")
+
+	if err = viper.MergeConfigMap(config.Values()); err != nil {
+		return
+	}
+
+	if err = viper.MergeConfigMap(config.Values(configKeyProfiles, CurrentProfile())); err != nil {
+		return
+	}
+
+	if tz := viper.GetString("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return fmt.Errorf("invalid timezone '%s' - %w", tz, err)
+		}
+
+		time.Local = loc
+	}
+
+	return
+}
+
+// ResolveAccountAlias returns the account set for an alias in the config file (e.g. aliases.joint), or the value
+// unchanged if it is not an alias.
+func ResolveAccountAlias(value string) string {
+	if accountID := viper.GetStringMapString(configKeyAliases)[strings.ToLower(value)]; accountID != "" {
+		return accountID
+	}
+
+	return value
+}
+
+// configKeys returns the names of every flag of the command tree, which are the settings that can be configured.
+func configKeys() map[string]bool {
+	keys := map[string]bool{}
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, flags := range []*pflag.FlagSet{cmd.PersistentFlags(), cmd.Flags()} {
+			flags.VisitAll(func(f *pflag.Flag) {
+				keys[f.Name] = true
+			})
+		}
+
+		for _, child := range cmd.Commands() {
+			walk(child)
+		}
+	}
+
+	walk(root)
+
+	delete(keys, "config")
+	delete(keys, "help")
+
+	return keys
+}
+
+// configKeyPath validates the setting name and splits it into its path in the config file.
+func configKeyPath(key string) ([]string, error) {
+	for _, section := range configMapSections {
+		if name := strings.TrimPrefix(key, section+"."); name != key && name != "" && !strings.Contains(name, ".") {
+			return append(strings.Split(section, "."), strings.ToLower(name)), nil
+		}
+	}
+
+	if configKeys()[key] {
+		return []string{key}, nil
+	}
+
+	return nil, fmt.Errorf("%w '%s'", ErrConfigKeyUnknown, key)
+}
+
+// configMapKeys returns the setting names of the values in the config map sections, e.g. aliases.joint.
+func configMapKeys() (keys []string) {
+	for _, section := range configMapSections {
+		for name := range viper.GetStringMapString(section) {
+			keys = append(keys, fmt.Sprintf("%s.%s", section, name))
+		}
+	}
+
+	return
+}
+
+// configScope prefixes the key path with the current profile's section if --profile-scope is set.
+func configScope(cmd *cobra.Command, keys []string) []string {
+	if profileScope, _ := cmd.Flags().GetBool("profile-scope"); profileScope {
+		return append([]string{configKeyProfiles, CurrentProfile()}, keys...)
+	}
+
+	return keys
+}
+
+// isSecretConfigKey reports whether the setting's value should be masked when listed.
+func isSecretConfigKey(key string) bool {
+	for _, secret := range []string{"secret", "passphrase", "token"} {
+		if strings.Contains(key, secret) && !strings.HasSuffix(key, "-url") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func configGetRunE(cmd *cobra.Command, args []string) (err error) {
+	if _, err = configKeyPath(args[0]); err != nil {
+		return
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), viper.GetString(args[0]))
+	return
+}
+
+func configSetRunE(cmd *cobra.Command, args []string) (err error) {
+	keys, err := configKeyPath(args[0])
+	if err != nil {
+		return
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if args[0] == "timezone" {
+		if _, err = time.LoadLocation(args[1]); err != nil {
+			return fmt.Errorf("invalid timezone '%s' - %w", args[1], err)
+		}
+	}
+
+	if err = config.Set(args[1], configScope(cmd, keys)...); err != nil {
+		return
+	}
+
+	return config.Save()
+}
+
+func configUnsetRunE(cmd *cobra.Command, args []string) (err error) {
+	keys, err := configKeyPath(args[0])
+	if err != nil {
+		return
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if !config.Unset(configScope(cmd, keys)...) {
+		return fmt.Errorf("%w '%s'", ErrConfigKeyNotSet, args[0])
+	}
+
+	return config.Save()
+}
+
+func configListRunE(cmd *cobra.Command, args []string) (err error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	profile := CurrentProfile()
+	list := &ConfigList{Settings: []ConfigSetting{}}
+
+	keys := []string{}
+	for key := range configKeys() {
+		keys = append(keys, key)
+	}
+
+	keys = append(keys, configMapKeys()...)
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		setting := ConfigSetting{Key: key, Value: viper.GetString(key), Source: "default"}
+		path := strings.Split(key, ".")
+
+		flag := cmd.Flags().Lookup(key)
+		_, inEnv := os.LookupEnv("MONZO_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_")))
+
+		switch {
+		case flag != nil && flag.Changed:
+			setting.Source = "flag"
+		case inEnv:
+			setting.Source = "env"
+		case config.Get(append([]string{configKeyProfiles, profile}, path...)...) != nil:
+			setting.Source = fmt.Sprintf("config (profile %s)", profile)
+		case config.Get(path...) != nil:
+			setting.Source = "config"
+		}
+
+		if setting.Value != "" && isSecretConfigKey(key) {
+			setting.Value = "********"
+		}
+
+		list.Settings = append(list.Settings, setting)
+	}
+
+	return Output(cmd, list)
+}
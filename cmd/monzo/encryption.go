@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	// encryptedCacheVersion identifies the format of encrypted cache files. Version 1 files were sealed with the same
+	// additional data for every file, and are still read (they are sealed as version 2 when next saved).
+	encryptedCacheVersion       = 2
+	encryptedCacheLegacyVersion = 1
+
+	// scrypt parameters used to derive cache keys from the passphrase.
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+)
+
+var (
+	ErrCachePassphraseMissing = errors.New("cache is encrypted, but no passphrase is available. set MONZO_CACHE_PASSPHRASE, --cache-passphrase-command, or run interactively")
+
+	ErrCachePassphraseMismatch = errors.New("passphrases do not match")
+
+	ErrCacheDecrypt = errors.New("failed to decrypt cache file, is the passphrase correct?")
+
+	passphrase     []byte
+	passphraseOnce sync.Once
+	passphraseErr  error
+
+	// passphrasePrompt is whether the passphrase can be prompted for. Shell completion must never wait for input.
+	passphrasePrompt = true
+
+	derivedKeys   = map[string][]byte{}
+	derivedKeysMu sync.Mutex
+
+	// writeSalt is generated once per process and shared by every file encrypted by the process, so the (deliberately
+	// slow) key derivation only runs once. Each file still uses a unique random nonce.
+	writeSalt     []byte
+	writeSaltOnce sync.Once
+	writeSaltErr  error
+)
+
+// EncryptedCache is the on-disk format of an encrypted cache file. The plaintext JSON is sealed with AES-256-GCM,
+// using a key derived from the passphrase with scrypt. The profile and file name are authenticated as additional data,
+// so a file cannot be swapped for another (or another profile's) encrypted with the same passphrase.
+type EncryptedCache struct {
+	Version    int    `json:"monzo_encrypted_cache"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// CacheEncryptionEnabled reports whether cache files for the current profile should be encrypted when saved.
+func CacheEncryptionEnabled() bool {
+	return viper.GetBool("encrypt-cache")
+}
+
+// IsEncryptedCache reports whether the file contents are an encrypted cache file.
+func IsEncryptedCache(data []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+
+	envelope := &EncryptedCache{}
+
+	return json.Unmarshal(data, envelope) == nil && envelope.Version != 0
+}
+
+// EncryptCache seals the plaintext of the profile's named cache file with a key derived from the cache passphrase and a
+// random salt.
+func EncryptCache(profile, fileName string, plaintext []byte) (data []byte, err error) {
+	writeSaltOnce.Do(func() {
+		writeSalt = make([]byte, scryptSalt)
+		_, writeSaltErr = rand.Read(writeSalt)
+	})
+
+	if writeSaltErr != nil {
+		return nil, writeSaltErr
+	}
+
+	envelope := &EncryptedCache{
+		Version: encryptedCacheVersion,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    writeSalt,
+	}
+
+	aead, err := cacheAEAD(envelope)
+	if err != nil {
+		return
+	}
+
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(envelope.Nonce); err != nil {
+		return
+	}
+
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, cacheAdditionalData(envelope.Version, profile, fileName))
+
+	return json.Marshal(envelope)
+}
+
+// DecryptCache opens the profile's named encrypted cache file with a key derived from the cache passphrase.
+func DecryptCache(profile, fileName string, data []byte) (plaintext []byte, err error) {
+	envelope := &EncryptedCache{}
+	if err = json.Unmarshal(data, envelope); err != nil {
+		return
+	}
+
+	if (envelope.Version != encryptedCacheVersion && envelope.Version != encryptedCacheLegacyVersion) || envelope.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported encrypted cache format (version %d, kdf %s)", envelope.Version, envelope.KDF)
+	}
+
+	aead, err := cacheAEAD(envelope)
+	if err != nil {
+		return
+	}
+
+	plaintext, err = aead.Open(nil, envelope.Nonce, envelope.Ciphertext, cacheAdditionalData(envelope.Version, profile, fileName))
+	if err != nil {
+		return nil, ErrCacheDecrypt
+	}
+
+	return
+}
+
+// cacheAdditionalData returns the data authenticated with the profile's named cache file.
+func cacheAdditionalData(version int, profile, fileName string) []byte {
+	if version == encryptedCacheLegacyVersion {
+		return []byte("monzo-cache")
+	}
+
+	return []byte(fmt.Sprintf("monzo-cache/%s/%s", profile, fileName))
+}
+
+// cacheAEAD derives (or reuses) the key for the envelope's salt and KDF parameters, and returns the AEAD cipher.
+func cacheAEAD(envelope *EncryptedCache) (aead cipher.AEAD, err error) {
+	pass, err := CachePassphrase(false)
+	if err != nil {
+		return
+	}
+
+	derivedKeysMu.Lock()
+	defer derivedKeysMu.Unlock()
+
+	cacheKey := fmt.Sprintf("%x/%d/%d/%d", envelope.Salt, envelope.N, envelope.R, envelope.P)
+
+	key, ok := derivedKeys[cacheKey]
+	if !ok {
+		key, err = scrypt.Key(pass, envelope.Salt, envelope.N, envelope.R, envelope.P, scryptKeyLen)
+		if err != nil {
+			return
+		}
+
+		derivedKeys[cacheKey] = key
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// CachePassphrase returns the passphrase used to encrypt the cache, which is read once per process from (in order):
+// MONZO_CACHE_PASSPHRASE, the output of --cache-passphrase-command, or a prompt on the terminal.
+//
+// If confirm is true and the passphrase is prompted for, it must be entered twice.
+func CachePassphrase(confirm bool) ([]byte, error) {
+	passphraseOnce.Do(func() {
+		passphrase, passphraseErr = readPassphrase(confirm)
+	})
+
+	return passphrase, passphraseErr
+}
+
+func readPassphrase(confirm bool) (pass []byte, err error) {
+	if env := viper.GetString("cache-passphrase"); env != "" {
+		return []byte(env), nil
+	}
+
+	if command := viper.GetString("cache-passphrase-command"); command != "" {
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+
+		cmd := exec.Command(shell, flag, command)
+		cmd.Stderr = os.Stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("cache passphrase command failed - %w", err)
+		}
+
+		return bytes.TrimRight(out, "\r\n"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !passphrasePrompt || !term.IsTerminal(fd) {
+		return nil, ErrCachePassphraseMissing
+	}
+
+	fmt.Fprint(os.Stderr, "Cache passphrase: ")
+	pass, err = term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return
+	}
+
+	if strings.TrimSpace(string(pass)) == "" {
+		return nil, ErrCachePassphraseMissing
+	}
+
+	if !confirm {
+		return
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm cache passphrase: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(pass, again) {
+		return nil, ErrCachePassphraseMismatch
+	}
+
+	return
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptCache(t *testing.T) {
+	viper.Set("cache-passphrase", "correct horse battery staple")
+
+	plaintext := []byte(`{"access_token":"secret"}`)
+
+	data, err := EncryptCache("personal", "token.json", plaintext)
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedCache(data))
+	assert.NotContains(t, string(data), "secret")
+
+	decrypted, err := DecryptCache("personal", "token.json", data)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// The file cannot be opened as another file, or as another profile's.
+	_, err = DecryptCache("personal", "sync.json", data)
+	assert.ErrorIs(t, err, ErrCacheDecrypt)
+
+	_, err = DecryptCache("joint", "token.json", data)
+	assert.ErrorIs(t, err, ErrCacheDecrypt)
+}
+
+func TestDecryptCacheLegacy(t *testing.T) {
+	viper.Set("cache-passphrase", "correct horse battery staple")
+
+	plaintext := []byte(`{"access_token":"secret"}`)
+
+	envelope := &EncryptedCache{Version: encryptedCacheLegacyVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, scryptSalt)}
+	rand.Read(envelope.Salt)
+
+	aead, err := cacheAEAD(envelope)
+	assert.NoError(t, err)
+
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, []byte("monzo-cache"))
+
+	data, err := json.Marshal(envelope)
+	assert.NoError(t, err)
+
+	decrypted, err := DecryptCache("personal", "token.json", data)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
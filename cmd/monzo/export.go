@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	exportCmd = &cobra.Command{
+		Use:     "export --format format [--account-id account] [--from date] [--to date]",
+		Short:   "Export an account's transactions as a statement for accounting software",
+		GroupID: "transactions",
+		PreRunE: exportPreRunE,
+		RunE:    exportRunE,
+		Args:    cobra.NoArgs,
+
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	// Exporters write a statement in each of the formats supported by the export command.
+	Exporters = map[string]Exporter{
+		"beancount": exportBeancount,
+		"camt053":   exportCamt053,
+		"csv":       exportCSV,
+		"hledger":   exportLedger,
+		"ledger":    exportLedger,
+		"ofx":       exportOFX,
+		"qif":       exportQIF,
+	}
+
+	ErrExportFormatInvalid = fmt.Errorf("export format invalid. valid formats [%s]", strings.Join(exportFormats(), ", "))
+
+	ErrStatementIncomplete = errors.New("transactions since the start of the statement are not all available, so its balances cannot be calculated")
+)
+
+// Exporter writes a statement in an export format.
+type Exporter func(w io.Writer, s *Statement) error
+
+func init() {
+	FlagSets["export"] = pflag.NewFlagSet("export", pflag.ContinueOnError)
+	FlagSets["export"].String("format", "", fmt.Sprintf("Export format [%s]", strings.Join(exportFormats(), ", ")))
+	FlagSets["export"].StringSlice("csv-columns", nil, "Columns to include in csv exports, in order (default: date, payee, description, category, amount, balance)")
+	FlagSets["export"].String("csv-delimiter", ",", "Field delimiter for csv exports (a single character, or tab)")
+	viper.BindPFlags(FlagSets["export"])
+
+	exportCmd.Flags().AddFlagSet(FlagSets["export"])
+	exportCmd.Flags().AddFlagSet(FlagSets["period"])
+	exportCmd.Flags().AddFlagSet(FlagSets["account"])
+	exportCmd.Flags().AddFlagSet(FlagSets["cache"])
+
+	exportCmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(exportFormats(), cobra.ShellCompDirectiveNoFileComp))
+	exportCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	root.AddCommand(exportCmd)
+}
+
+// Statement is an account's transactions over a period, with the account's balance at the start and end of it.
+type Statement struct {
+	Account monzo.Account
+
+	// From and To bound the period covered by the statement, [From, To).
+	From time.Time
+	To   time.Time
+
+	// Transactions are the transactions created in the period, in the order they were created. Declined transactions
+	// are not included, as they did not move any money.
+	Transactions []monzo.Transaction
+
+	// OpeningBalance and ClosingBalance are the account's balance at From and To, in minor units.
+	OpeningBalance int64
+	ClosingBalance int64
+	Currency       string
+
+	// Pots are the names of the account's pots, by ID.
+	Pots map[string]string
+
+	Generated time.Time
+}
+
+// Payee returns the name of the other party of a transaction: the pot, merchant or counterparty, falling back to the
+// transaction description.
+func (s *Statement) Payee(tx monzo.Transaction) string {
+	if potID := tx.PotID(); potID != "" {
+		if name := s.Pots[potID]; name != "" {
+			return name
+		}
+
+		return potID
+	}
+
+	if tx.Merchant.Name != "" {
+		return tx.Merchant.Name
+	}
+
+	counterparty := tx.CounterpartyDetails()
+
+	for _, name := range []string{counterparty.Name, counterparty.PreferredName} {
+		if name != "" {
+			return name
+		}
+	}
+
+	return tx.Description
+}
+
+// Posted returns when a transaction was posted to the account: when it settled, or when it was created if it has not
+// settled yet.
+func (s *Statement) Posted(tx monzo.Transaction) time.Time {
+	if settled := tx.SettledTime(); !settled.IsZero() {
+		return settled
+	}
+
+	return tx.CreatedTime()
+}
+
+func exportFormats() (formats []string) {
+	for format := range Exporters {
+		formats = append(formats, format)
+	}
+
+	sort.Strings(formats)
+
+	return
+}
+
+// singleLine collapses whitespace (including line breaks) in the string to single spaces.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// categoryName converts a Monzo category (e.g. eating_out) into a display name (e.g. Eating Out).
+func categoryName(category string) string {
+	if category == "" {
+		category = "general"
+	}
+
+	words := strings.Fields(strings.ReplaceAll(category, "_", " "))
+
+	for n, word := range words {
+		words[n] = strings.ToUpper(word[:1]) + word[1:]
+	}
+
+	return strings.Join(words, " ")
+}
+
+func exportPreRunE(cmd *cobra.Command, args []string) (err error) {
+	if _, ok := Exporters[viper.GetString("format")]; !ok {
+		return fmt.Errorf("%w '%s'", ErrExportFormatInvalid, viper.GetString("format"))
+	}
+
+	if _, err = CacheMode(); err != nil {
+		return
+	}
+
+	if _, err = csvDelimiter(); err != nil {
+		return
+	}
+
+	_, err = SelectedPeriod()
+	return
+}
+
+func exportRunE(cmd *cobra.Command, args []string) (err error) {
+	accountID, err := ResolveAccount(cmd)
+	if err != nil {
+		return
+	}
+
+	statement, err := BuildStatement(cmd, accountID)
+	if err != nil {
+		return
+	}
+
+	return Exporters[viper.GetString("format")](cmd.OutOrStdout(), statement)
+}
+
+// BuildStatement builds the statement for the account over the period selected with --from and --to.
+//
+// The closing balance is reconstructed from the account's current balance, less the transactions created since the
+// end of the period, so every transaction since the start of the period is loaded (from the cache where possible). If
+// they cannot all be loaded, ErrStatementIncomplete is returned rather than a statement with the wrong balances.
+func BuildStatement(cmd *cobra.Command, accountID string) (s *Statement, err error) {
+	period, err := SelectedPeriod()
+	if err != nil {
+		return
+	}
+
+	from, to := period.From, period.To
+
+	s = &Statement{Account: monzo.Account{ID: accountID}, From: from, To: to, Generated: time.Now().UTC()}
+
+	list, err := listAccounts()
+	if err != nil {
+		return
+	}
+
+	for _, acc := range list.Accounts {
+		if acc.ID == accountID {
+			s.Account = acc
+		}
+	}
+
+	balance, asOf, err := statementBalance(accountID)
+	if err != nil {
+		return
+	}
+
+	if s.Pots, err = statementPots(accountID); err != nil {
+		return
+	}
+
+	txs, err := transactionsSince(cmd, accountID, from)
+	if err != nil {
+		return
+	}
+
+	if available := statementHistoryFrom(accountID); !available.IsZero() && from.Before(available) {
+		return nil, fmt.Errorf("%w - history is only available from %s, so export from a later --from date, or run monzo login to backfill the full history",
+			ErrStatementIncomplete, formatDate(available))
+	}
+
+	s.Currency, s.ClosingBalance = balance.Currency, balance.Balance
+
+	for _, tx := range txs {
+		created := tx.CreatedTime()
+
+		switch {
+		case tx.Declined():
+			continue
+		case created.Before(to):
+			s.Transactions = append(s.Transactions, tx)
+		case created.Before(asOf):
+			s.ClosingBalance -= tx.Amount
+		}
+	}
+
+	s.OpeningBalance = s.ClosingBalance
+
+	for _, tx := range s.Transactions {
+		s.OpeningBalance -= tx.Amount
+	}
+
+	return
+}
+
+// statementHistoryFrom returns the creation time the account's transactions have all been loaded from, or zero if the
+// full history was loaded: the start of the cached range, or of the history the API serves when the cache is off.
+func statementHistoryFrom(accountID string) time.Time {
+	if mode, _ := CacheMode(); mode == CacheModeOff {
+		return _token.HistoryFrom(time.Now().UTC())
+	}
+
+	state := openCaches.SyncState()[accountID]
+	if state == nil || state.coveredUntil().IsZero() {
+		return time.Now().UTC()
+	}
+
+	return state.CoveredFrom
+}
+
+// statementBalance returns the account's balance, and when it was fetched. The cached balance is used offline.
+func statementBalance(accountID string) (*monzo.Balance, time.Time, error) {
+	if Offline() {
+		balances := map[string]*Snapshot[*monzo.Balance]{}
+		LoadCache(CacheFileBalances, &balances)
+
+		if balances[accountID] == nil {
+			return nil, time.Time{}, ErrOfflineNotCached
+		}
+
+		return balances[accountID].Data, balances[accountID].Fetched, nil
+	}
+
+	asOf := time.Now()
+
+	balance, err := _client.Balance.Get(accountID)
+	if err != nil {
+		return nil, asOf, err
+	}
+
+	return balance, asOf, saveBalanceSnapshot(accountID, balance)
+}
+
+// statementPots returns the names of the account's pots, by ID. The cached pots are used offline.
+func statementPots(accountID string) (names map[string]string, err error) {
+	names = map[string]string{}
+
+	pots := &monzo.PotsList{}
+
+	if Offline() {
+		snapshots := map[string]*Snapshot[*monzo.PotsList]{}
+		LoadCache(CacheFilePots, &snapshots)
+
+		if snapshots[accountID] != nil {
+			pots = snapshots[accountID].Data
+		}
+	} else {
+		if pots, err = _client.Pots.List(accountID); err != nil {
+			return
+		}
+
+		if err = savePotsSnapshot(accountID, pots); err != nil {
+			return
+		}
+	}
+
+	for _, pot := range pots.Pots {
+		names[pot.ID] = pot.Name
+	}
+
+	return
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+const (
+	// camtNamespace is the XML namespace of camt.053.001.02 bank to customer statements.
+	camtNamespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+	// camtDateTimeFormat is the ISO 8601 date/time format used by camt.053.
+	camtDateTimeFormat = "2006-01-02T15:04:05Z"
+
+	camtCredit = "CRDT"
+	camtDebit  = "DBIT"
+)
+
+type camtDocument struct {
+	XMLName   xml.Name      `xml:"Document"`
+	Namespace string        `xml:"xmlns,attr"`
+	GroupHdr  camtGroupHdr  `xml:"BkToCstmrStmt>GrpHdr"`
+	Statement camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtGroupHdr struct {
+	MsgID    string `xml:"MsgId"`
+	CreDtTm  string `xml:"CreDtTm"`
+	MsgPgntn struct {
+		PgNb      int  `xml:"PgNb"`
+		LastPgInd bool `xml:"LastPgInd"`
+	} `xml:"MsgPgntn"`
+}
+
+type camtStatement struct {
+	ID       string        `xml:"Id"`
+	CreDtTm  string        `xml:"CreDtTm"`
+	FrDtTm   string        `xml:"FrToDt>FrDtTm"`
+	ToDtTm   string        `xml:"FrToDt>ToDtTm"`
+	Account  camtAccount   `xml:"Acct"`
+	Balances []camtBalance `xml:"Bal"`
+	Summary  camtSummary   `xml:"TxsSummry"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAccount struct {
+	ID       camtAccountID    `xml:"Id"`
+	Currency string           `xml:"Ccy,omitempty"`
+	Name     string           `xml:"Nm,omitempty"`
+	Servicer *camtInstitution `xml:"Svcr>FinInstnId,omitempty"`
+}
+
+// camtInstitution is a financial institution. Optional nested elements are pointers, as encoding/xml writes the parents
+// of an empty element tagged omitempty.
+type camtInstitution struct {
+	Name string `xml:"Nm"`
+}
+
+type camtAccountID struct {
+	ID     string      `xml:"Othr>Id"`
+	Scheme *camtScheme `xml:"Othr>SchmeNm,omitempty"`
+}
+
+type camtScheme struct {
+	Code string `xml:"Cd"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Type      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	CdtDbtInd string     `xml:"CdtDbtInd"`
+	Date      string     `xml:"Dt>DtTm"`
+}
+
+type camtSummary struct {
+	Count     int              `xml:"TtlNtries>NbOfNtries"`
+	Sum       string           `xml:"TtlNtries>Sum"`
+	Net       string           `xml:"TtlNtries>TtlNetNtryAmt"`
+	CdtDbtInd string           `xml:"TtlNtries>CdtDbtInd"`
+	Credits   camtSummaryTotal `xml:"TtlCdtNtries"`
+	Debits    camtSummaryTotal `xml:"TtlDbtNtries"`
+}
+
+type camtSummaryTotal struct {
+	Count int    `xml:"NbOfNtries"`
+	Sum   string `xml:"Sum"`
+}
+
+type camtEntry struct {
+	Ref         string          `xml:"NtryRef"`
+	Amount      camtAmount      `xml:"Amt"`
+	CdtDbtInd   string          `xml:"CdtDbtInd"`
+	Status      string          `xml:"Sts"`
+	BookingDate string          `xml:"BookgDt>DtTm"`
+	ValueDate   string          `xml:"ValDt>DtTm"`
+	ServicerRef string          `xml:"AcctSvcrRef"`
+	BankTxCode  string          `xml:"BkTxCd>Prtry>Cd"`
+	Details     camtEntryDetail `xml:"NtryDtls>TxDtls"`
+}
+
+type camtEntryDetail struct {
+	ServicerRef     string          `xml:"Refs>AcctSvcrRef"`
+	Debtor          *camtParty      `xml:"RltdPties>Dbtr,omitempty"`
+	DebtorAccount   *camtAccount    `xml:"RltdPties>DbtrAcct,omitempty"`
+	Creditor        *camtParty      `xml:"RltdPties>Cdtr,omitempty"`
+	CreditorAccount *camtAccount    `xml:"RltdPties>CdtrAcct,omitempty"`
+	Remittance      *camtRemittance `xml:"RmtInf,omitempty"`
+}
+
+type camtRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+type camtParty struct {
+	Name string `xml:"Nm"`
+}
+
+// exportCamt053 writes the statement as an ISO 20022 camt.053.001.02 bank to customer statement, with the opening and
+// closing balances of the period. Counterparties are identified by their UK sort code and account number, when known.
+func exportCamt053(w io.Writer, s *Statement) (err error) {
+	doc := &camtDocument{
+		Namespace: camtNamespace,
+		GroupHdr: camtGroupHdr{
+			MsgID:   fmt.Sprintf("%s-%s", s.Account.ID, s.Generated.Format("20060102150405")),
+			CreDtTm: camtDateTime(s.Generated),
+		},
+		Statement: camtStatement{
+			ID:      fmt.Sprintf("%s-%s-%s", s.Account.ID, s.From.Format("20060102"), s.To.Format("20060102")),
+			CreDtTm: camtDateTime(s.Generated),
+			FrDtTm:  camtDateTime(s.From),
+			ToDtTm:  camtDateTime(s.To),
+			Account: camtAccount{
+				ID:       camtIdentification(s.Account.PaymentDetails.LocaleUK),
+				Currency: s.Currency,
+				Name:     s.Account.Description,
+				Servicer: &camtInstitution{Name: "Monzo Bank"},
+			},
+			Balances: []camtBalance{
+				camtBalanceOf("OPBD", s.OpeningBalance, s.Currency, s.From),
+				camtBalanceOf("CLBD", s.ClosingBalance, s.Currency, s.To),
+			},
+		},
+	}
+
+	doc.GroupHdr.MsgPgntn.PgNb, doc.GroupHdr.MsgPgntn.LastPgInd = 1, true
+
+	if doc.Statement.Account.ID.ID == "" {
+		doc.Statement.Account.ID = camtAccountID{ID: s.Account.ID}
+	}
+
+	var credits, debits int64
+
+	for _, tx := range s.Transactions {
+		entry := camtEntry{
+			Ref:         tx.ID,
+			Amount:      camtAmountOf(tx.Amount, tx.Currency),
+			CdtDbtInd:   camtIndicator(tx.Amount),
+			Status:      "BOOK",
+			BookingDate: camtDateTime(s.Posted(tx)),
+			ValueDate:   camtDateTime(tx.CreatedTime()),
+			ServicerRef: tx.ID,
+			BankTxCode:  tx.Scheme,
+			Details:     camtEntryDetail{ServicerRef: tx.ID},
+		}
+
+		if tx.AmountIsPending || tx.SettledTime().IsZero() {
+			entry.Status = "PDNG"
+		}
+
+		if entry.BankTxCode == "" {
+			entry.BankTxCode = "NOTPROVIDED"
+		}
+
+		remittance := singleLine(tx.Notes)
+		if remittance == "" {
+			remittance = singleLine(tx.Description)
+		}
+
+		if remittance != "" {
+			entry.Details.Remittance = &camtRemittance{Unstructured: remittance}
+		}
+
+		party, account := camtCounterparty(s, tx)
+
+		if tx.Amount < 0 {
+			entry.Details.Creditor, entry.Details.CreditorAccount = party, account
+			debits += -tx.Amount
+			doc.Statement.Summary.Debits.Count++
+		} else {
+			entry.Details.Debtor, entry.Details.DebtorAccount = party, account
+			credits += tx.Amount
+			doc.Statement.Summary.Credits.Count++
+		}
+
+		doc.Statement.Entries = append(doc.Statement.Entries, entry)
+	}
+
+	summary := &doc.Statement.Summary
+
+	summary.Count = len(s.Transactions)
+	summary.Sum = FormatAmount(credits+debits, s.Currency)
+	summary.Net = FormatAmount(abs(credits-debits), s.Currency)
+	summary.CdtDbtInd = camtIndicator(credits - debits)
+	summary.Credits.Sum = FormatAmount(credits, s.Currency)
+	summary.Debits.Sum = FormatAmount(debits, s.Currency)
+
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err = enc.Encode(doc); err != nil {
+		return
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+// camtCounterparty returns the other party of a transaction, and their account if it is known.
+func camtCounterparty(s *Statement, tx monzo.Transaction) (*camtParty, *camtAccount) {
+	party := &camtParty{Name: singleLine(s.Payee(tx))}
+
+	counterparty := tx.CounterpartyDetails()
+	if counterparty.AccountNumber == "" {
+		return party, nil
+	}
+
+	return party, &camtAccount{ID: camtIdentification(monzo.PaymentDetailsLocaleUK{
+		AccountNumber: counterparty.AccountNumber,
+		SortCode:      counterparty.SortCode,
+	})}
+}
+
+// camtIdentification identifies a UK account by its BBAN: the sort code followed by the account number.
+func camtIdentification(details monzo.PaymentDetailsLocaleUK) camtAccountID {
+	if details.AccountNumber == "" {
+		return camtAccountID{}
+	}
+
+	return camtAccountID{ID: details.SortCode + details.AccountNumber, Scheme: &camtScheme{Code: "BBAN"}}
+}
+
+func camtBalanceOf(balanceType string, amount int64, currency string, asOf time.Time) camtBalance {
+	return camtBalance{
+		Type:      balanceType,
+		Amount:    camtAmountOf(amount, currency),
+		CdtDbtInd: camtIndicator(amount),
+		Date:      camtDateTime(asOf),
+	}
+}
+
+// camtAmountOf returns the absolute amount, as camt.053 gives the direction separately.
+func camtAmountOf(amount int64, currency string) camtAmount {
+	return camtAmount{Currency: currency, Value: FormatAmount(abs(amount), currency)}
+}
+
+func camtIndicator(amount int64) string {
+	if amount < 0 {
+		return camtDebit
+	}
+
+	return camtCredit
+}
+
+func camtDateTime(t time.Time) string {
+	return t.UTC().Format(camtDateTimeFormat)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrExportDelimiterInvalid = errors.New("--csv-delimiter must be a single character, or tab")
+)
+
+// StatementEntry is a transaction on a statement, with the account's balance after it.
+type StatementEntry struct {
+	monzo.Transaction
+
+	// Balance is the running balance of the account after the transaction, in minor units.
+	Balance int64
+
+	statement *Statement
+}
+
+// Entries returns the statement's transactions, with the running balance after each of them.
+func (s *Statement) Entries() (entries []StatementEntry) {
+	balance := s.OpeningBalance
+
+	for _, tx := range s.Transactions {
+		balance += tx.Amount
+		entries = append(entries, StatementEntry{Transaction: tx, Balance: balance, statement: s})
+	}
+
+	return
+}
+
+var (
+	statementDefaults = []string{"date", "payee", "description", "category", "amount", "balance"}
+
+	statementColumns = []Column{
+		{"date", func(r any) string { return ledgerDate(r.(StatementEntry).CreatedTime()) }},
+		{"posted", func(r any) string { e := r.(StatementEntry); return ledgerDate(e.statement.Posted(e.Transaction)) }},
+		{"created", func(r any) string { return r.(StatementEntry).Created }},
+		{"settled", func(r any) string { return r.(StatementEntry).Settled }},
+		{"id", func(r any) string { return r.(StatementEntry).ID }},
+		{"payee", func(r any) string { e := r.(StatementEntry); return singleLine(e.statement.Payee(e.Transaction)) }},
+		{"description", func(r any) string { return singleLine(r.(StatementEntry).Description) }},
+		{"category", func(r any) string { return categoryName(r.(StatementEntry).Category) }},
+		{"notes", func(r any) string { return singleLine(r.(StatementEntry).Notes) }},
+		{"amount", func(r any) string { e := r.(StatementEntry); return FormatAmount(e.Amount, e.Currency) }},
+		{"debit", func(r any) string {
+			if e := r.(StatementEntry); e.Amount < 0 {
+				return FormatAmount(-e.Amount, e.Currency)
+			}
+
+			return ""
+		}},
+		{"credit", func(r any) string {
+			if e := r.(StatementEntry); e.Amount >= 0 {
+				return FormatAmount(e.Amount, e.Currency)
+			}
+
+			return ""
+		}},
+		{"balance", func(r any) string { e := r.(StatementEntry); return FormatAmount(e.Balance, e.statement.Currency) }},
+		{"currency", func(r any) string { return r.(StatementEntry).Currency }},
+		{"local_amount", func(r any) string { e := r.(StatementEntry); return FormatAmount(e.LocalAmount, e.LocalCurrency) }},
+		{"local_currency", func(r any) string { return r.(StatementEntry).LocalCurrency }},
+		{"pending", func(r any) string { return strconv.FormatBool(r.(StatementEntry).AmountIsPending) }},
+		{"counterparty_name", func(r any) string { return r.(StatementEntry).CounterpartyDetails().Name }},
+		{"counterparty_sort_code", func(r any) string { return r.(StatementEntry).CounterpartyDetails().SortCode }},
+		{"counterparty_account_number", func(r any) string { return r.(StatementEntry).CounterpartyDetails().AccountNumber }},
+	}
+)
+
+// csvDelimiter returns the field delimiter selected with --csv-delimiter.
+func csvDelimiter() (rune, error) {
+	value := viper.GetString("csv-delimiter")
+	if value == "tab" || value == `\t` {
+		return '\t', nil
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%w '%s'", ErrExportDelimiterInvalid, value)
+	}
+
+	r, _ := utf8.DecodeRuneInString(value)
+	if r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("%w '%s'", ErrExportDelimiterInvalid, value)
+	}
+
+	return r, nil
+}
+
+// exportCSV writes the statement as CSV, one row per transaction with the running balance. The columns and delimiter
+// are selected with --csv-columns and --csv-delimiter, so they can be set once in the config file.
+func exportCSV(w io.Writer, s *Statement) (err error) {
+	delimiter, err := csvDelimiter()
+	if err != nil {
+		return
+	}
+
+	table := &Table{Columns: statementColumns, Defaults: statementDefaults, Rows: rowsOf(s.Entries())}
+
+	columns, rows, err := selectRows(table, viper.GetStringSlice("csv-columns"))
+	if err != nil {
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+
+	if err = cw.Write(columns); err != nil {
+		return
+	}
+
+	if err = cw.WriteAll(rows); err != nil {
+		return
+	}
+
+	return cw.Error()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configKeyLedgerAccounts, configKeyLedgerPots and configKeyLedgerCategories are the config file sections that
+	// override the account names used for Monzo accounts (by ID), pots (by ID) and categories.
+	configKeyLedgerAccounts   = "ledger.accounts"
+	configKeyLedgerPots       = "ledger.pots"
+	configKeyLedgerCategories = "ledger.categories"
+
+	// ledgerMetadataKey is the metadata key holding the Monzo transaction ID of each entry.
+	ledgerMetadataKey = "monzo-id"
+)
+
+func init() {
+	configMapSections = append(configMapSections, configKeyLedgerAccounts, configKeyLedgerPots, configKeyLedgerCategories)
+}
+
+// ledgerNames maps Monzo accounts, pots and categories to plain-text accounting account names. The defaults are
+// overridden by the ledger sections of the config file.
+type ledgerNames struct {
+	statement *Statement
+
+	accounts   map[string]string
+	pots       map[string]string
+	categories map[string]string
+}
+
+func newLedgerNames(s *Statement) *ledgerNames {
+	return &ledgerNames{
+		statement:  s,
+		accounts:   viper.GetStringMapString(configKeyLedgerAccounts),
+		pots:       viper.GetStringMapString(configKeyLedgerPots),
+		categories: viper.GetStringMapString(configKeyLedgerCategories),
+	}
+}
+
+// Account returns the name of the statement's Monzo account, e.g. Assets:Monzo:Personal.
+func (n *ledgerNames) Account() string {
+	acc := n.statement.Account
+
+	if name := n.accounts[strings.ToLower(acc.ID)]; name != "" {
+		return name
+	}
+
+	switch acc.Type {
+	case monzo.AccountTypeUKRetail:
+		return "Assets:Monzo:Personal"
+	case monzo.AccountTypeUKRetailJoint:
+		return "Assets:Monzo:Joint"
+	}
+
+	if acc.Description != "" {
+		return "Assets:Monzo:" + ledgerComponent(acc.Description)
+	}
+
+	return "Assets:Monzo:" + ledgerComponent(acc.ID)
+}
+
+// Counterpart returns the name of the account on the other side of a transaction: the pot for pot transfers, or the
+// income or expense account for the transaction's category.
+func (n *ledgerNames) Counterpart(tx monzo.Transaction) string {
+	if potID := tx.PotID(); potID != "" {
+		if name := n.pots[strings.ToLower(potID)]; name != "" {
+			return name
+		}
+
+		return "Assets:Monzo:Pots:" + ledgerComponent(n.statement.Payee(tx))
+	}
+
+	category := tx.Category
+	if category == "" {
+		category = "general"
+	}
+
+	if name := n.categories[category]; name != "" {
+		return name
+	}
+
+	if category == "income" {
+		return "Income:General"
+	}
+
+	return "Expenses:" + ledgerComponent(categoryName(category))
+}
+
+// ledgerComponent converts a name into an account name component, which starts with a capital letter and only contains
+// letters, digits and dashes (the intersection of what ledger, hledger and beancount accept).
+func ledgerComponent(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for n, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[n] = string(runes)
+	}
+
+	component := strings.Join(words, "")
+
+	if component == "" || !unicode.IsUpper([]rune(component)[0]) {
+		component = "X" + component
+	}
+
+	return component
+}
+
+// ledgerDate returns the date of the time in the local time zone, in the format used by ledger, hledger and beancount.
+func ledgerDate(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+// exportLedger writes the statement as a ledger journal, which hledger also reads. Pending transactions are marked
+// with !, and each entry has the Monzo transaction ID as metadata. The closing balance is asserted after the last entry.
+func exportLedger(w io.Writer, s *Statement) (err error) {
+	b := &strings.Builder{}
+	names := newLedgerNames(s)
+	account := names.Account()
+
+	for _, tx := range s.Transactions {
+		flag := "*"
+		if tx.AmountIsPending {
+			flag = "!"
+		}
+
+		fmt.Fprintf(b, "%s %s %s\n", ledgerDate(tx.CreatedTime()), flag, singleLine(s.Payee(tx)))
+
+		if notes := singleLine(tx.Notes); notes != "" {
+			fmt.Fprintf(b, "    ; %s\n", notes)
+		}
+
+		fmt.Fprintf(b, "    ; %s: %s\n", ledgerMetadataKey, tx.ID)
+		fmt.Fprintf(b, "    %-50s  %s %s\n", names.Counterpart(tx), FormatAmount(-tx.Amount, tx.Currency), tx.Currency)
+		fmt.Fprintf(b, "    %s\n\n", account)
+	}
+
+	fmt.Fprintf(b, "%s * Balance assertion\n", ledgerDate(s.To.Add(-time.Nanosecond)))
+	fmt.Fprintf(b, "    %-50s  0 %s = %s %s\n", account, s.Currency, FormatAmount(s.ClosingBalance, s.Currency), s.Currency)
+
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// exportBeancount writes the statement as beancount entries. Pending transactions are flagged with !, and each entry
+// has the Monzo transaction ID as metadata. The closing balance is asserted at the end of the period.
+//
+// Open directives are not written, so that exports of different periods can be combined.
+func exportBeancount(w io.Writer, s *Statement) (err error) {
+	b := &strings.Builder{}
+	names := newLedgerNames(s)
+	account := names.Account()
+
+	for _, tx := range s.Transactions {
+		flag := "*"
+		if tx.AmountIsPending {
+			flag = "!"
+		}
+
+		fmt.Fprintf(b, "%s %s %s %s\n", ledgerDate(tx.CreatedTime()), flag, beancountString(s.Payee(tx)), beancountString(tx.Notes))
+		fmt.Fprintf(b, "  %s: %s\n", ledgerMetadataKey, beancountString(tx.ID))
+		fmt.Fprintf(b, "  %-50s  %s %s\n", names.Counterpart(tx), FormatAmount(-tx.Amount, tx.Currency), tx.Currency)
+		fmt.Fprintf(b, "  %-50s  %s %s\n\n", account, FormatAmount(tx.Amount, tx.Currency), tx.Currency)
+	}
+
+	// Beancount checks balance assertions at the start of the day, so the assertion is dated the day after the period.
+	fmt.Fprintf(b, "%s balance %s  %s %s\n", ledgerDate(s.To.Add(-time.Nanosecond).AddDate(0, 0, 1)), account, FormatAmount(s.ClosingBalance, s.Currency), s.Currency)
+
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// beancountString quotes the string for beancount.
+func beancountString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(singleLine(s)) + `"`
+}
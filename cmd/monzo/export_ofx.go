@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+const (
+	// ofxHeader is the header of an OFX 2.2 (XML) document.
+	ofxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n" +
+		`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n"
+
+	// ofxDateFormat is the OFX date/time format. Times are always written in UTC.
+	ofxDateFormat = "20060102150405.000[0:UTC]"
+
+	// ofxNameLength and ofxMemoLength are the maximum lengths of the NAME and MEMO elements.
+	ofxNameLength = 32
+	ofxMemoLength = 255
+)
+
+type ofxDocument struct {
+	XMLName   xml.Name             `xml:"OFX"`
+	SignOn    ofxSignOn            `xml:"SIGNONMSGSRSV1>SONRS"`
+	Statement ofxStatementResponse `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxSignOn struct {
+	Status   ofxStatus `xml:"STATUS"`
+	DTServer string    `xml:"DTSERVER"`
+	Language string    `xml:"LANGUAGE"`
+}
+
+type ofxStatementResponse struct {
+	TrnUID           string             `xml:"TRNUID"`
+	Status           ofxStatus          `xml:"STATUS"`
+	Currency         string             `xml:"STMTRS>CURDEF"`
+	Account          ofxBankAccount     `xml:"STMTRS>BANKACCTFROM"`
+	Transactions     ofxTransactionList `xml:"STMTRS>BANKTRANLIST"`
+	LedgerBalance    ofxBalance         `xml:"STMTRS>LEDGERBAL"`
+	AvailableBalance ofxBalance         `xml:"STMTRS>AVAILBAL"`
+}
+
+type ofxBankAccount struct {
+	BankID   string `xml:"BANKID"`
+	AcctID   string `xml:"ACCTID"`
+	AcctType string `xml:"ACCTTYPE"`
+}
+
+type ofxTransactionList struct {
+	DTStart      string           `xml:"DTSTART"`
+	DTEnd        string           `xml:"DTEND"`
+	Transactions []ofxTransaction `xml:"STMTTRN"`
+}
+
+type ofxTransaction struct {
+	TrnType  string `xml:"TRNTYPE"`
+	DTPosted string `xml:"DTPOSTED"`
+	DTUser   string `xml:"DTUSER"`
+	TrnAmt   string `xml:"TRNAMT"`
+	FITID    string `xml:"FITID"`
+	Name     string `xml:"NAME,omitempty"`
+	Memo     string `xml:"MEMO,omitempty"`
+}
+
+type ofxBalance struct {
+	BalAmt string `xml:"BALAMT"`
+	DTAsOf string `xml:"DTASOF"`
+}
+
+// exportOFX writes the statement as an OFX 2.2 bank statement. Transaction IDs are used as FITIDs, so importing
+// overlapping statements does not duplicate transactions.
+func exportOFX(w io.Writer, s *Statement) (err error) {
+	ok := ofxStatus{Code: 0, Severity: "INFO"}
+
+	doc := &ofxDocument{
+		SignOn: ofxSignOn{Status: ok, DTServer: ofxDate(s.Generated), Language: "ENG"},
+		Statement: ofxStatementResponse{
+			TrnUID:   "0",
+			Status:   ok,
+			Currency: s.Currency,
+			Account: ofxBankAccount{
+				BankID:   s.Account.PaymentDetails.LocaleUK.SortCode,
+				AcctID:   s.Account.PaymentDetails.LocaleUK.AccountNumber,
+				AcctType: "CHECKING",
+			},
+			Transactions: ofxTransactionList{DTStart: ofxDate(s.From), DTEnd: ofxDate(s.To)},
+			LedgerBalance: ofxBalance{
+				BalAmt: FormatAmount(s.ClosingBalance, s.Currency),
+				DTAsOf: ofxDate(s.To),
+			},
+			AvailableBalance: ofxBalance{
+				BalAmt: FormatAmount(s.ClosingBalance, s.Currency),
+				DTAsOf: ofxDate(s.To),
+			},
+		},
+	}
+
+	if doc.Statement.Account.AcctID == "" {
+		doc.Statement.Account.AcctID = s.Account.ID
+	}
+
+	for _, tx := range s.Transactions {
+		trn := ofxTransaction{
+			TrnType:  ofxTransactionType(tx),
+			DTPosted: ofxDate(s.Posted(tx)),
+			DTUser:   ofxDate(tx.CreatedTime()),
+			TrnAmt:   FormatAmount(tx.Amount, tx.Currency),
+			FITID:    tx.ID,
+			Name:     truncate(singleLine(s.Payee(tx)), ofxNameLength),
+			Memo:     truncate(singleLine(tx.Notes), ofxMemoLength),
+		}
+
+		if trn.Memo == "" && tx.PotID() != "" {
+			trn.Memo = "Pot transfer"
+		}
+
+		doc.Statement.Transactions.Transactions = append(doc.Statement.Transactions.Transactions, trn)
+	}
+
+	if _, err = io.WriteString(w, ofxHeader); err != nil {
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err = enc.Encode(doc); err != nil {
+		return
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+// ofxTransactionType returns the OFX transaction type: pot transfers are transfers, and other transactions are typed
+// by how the money moved, where that is known.
+func ofxTransactionType(tx monzo.Transaction) string {
+	switch {
+	case tx.PotID() != "":
+		return "XFER"
+	case tx.Merchant.ATM:
+		return "ATM"
+	case tx.Scheme == "bacs" && tx.Amount < 0:
+		return "DIRECTDEBIT"
+	case tx.Amount < 0:
+		return "DEBIT"
+	}
+
+	return "CREDIT"
+}
+
+func ofxDate(t time.Time) string {
+	return t.UTC().Format(ofxDateFormat)
+}
+
+// truncate shortens the string to at most n characters.
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+
+	return s
+}
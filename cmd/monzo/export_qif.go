@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// qifDateFormat is the date format of QIF files. QIF has no standard date format, so the UK format is used.
+	qifDateFormat = "02/01/2006"
+)
+
+// exportQIF writes the statement as a QIF bank account, starting with an opening balance entry. Pot transfers are
+// written as transfers to an account named after the pot.
+func exportQIF(w io.Writer, s *Statement) (err error) {
+	b := &strings.Builder{}
+
+	name := s.Account.Description
+	if name == "" {
+		name = s.Account.ID
+	}
+
+	fmt.Fprintln(b, "!Type:Bank")
+
+	writeQIFEntry(b, map[byte]string{
+		'D': s.From.Local().Format(qifDateFormat),
+		'T': FormatAmount(s.OpeningBalance, s.Currency),
+		'C': "X",
+		'P': "Opening Balance",
+		'L': fmt.Sprintf("[%s]", singleLine(name)),
+	})
+
+	for _, tx := range s.Transactions {
+		entry := map[byte]string{
+			'D': s.Posted(tx).Local().Format(qifDateFormat),
+			'T': FormatAmount(tx.Amount, tx.Currency),
+			'N': tx.ID,
+			'P': singleLine(s.Payee(tx)),
+			'M': singleLine(tx.Notes),
+			'L': categoryName(tx.Category),
+		}
+
+		if !tx.SettledTime().IsZero() {
+			entry['C'] = "c"
+		}
+
+		if tx.PotID() != "" {
+			entry['L'] = fmt.Sprintf("[%s]", singleLine(s.Payee(tx)))
+		}
+
+		writeQIFEntry(b, entry)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// qifFields is the order fields are written in each QIF entry.
+var qifFields = []byte{'D', 'T', 'C', 'N', 'P', 'M', 'L'}
+
+func writeQIFEntry(b *strings.Builder, entry map[byte]string) {
+	for _, field := range qifFields {
+		if value := entry[field]; value != "" {
+			fmt.Fprintf(b, "%c%s\n", field, value)
+		}
+	}
+
+	fmt.Fprintln(b, "^")
+}
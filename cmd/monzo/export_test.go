@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// exportStatement returns a statement with one of each kind of transaction.
+func exportStatement() *Statement {
+	at := func(day, hour, minute int) string {
+		return time.Date(2023, 1, day, hour, minute, 0, 0, time.UTC).Format(time.RFC3339)
+	}
+
+	lunch := monzo.Transaction{ID: "tx_lunch", Amount: -450, Currency: "GBP", Category: "eating_out", Notes: "Lunch\nwith the team",
+		Created: at(3, 12, 30), Settled: at(4, 6, 0), Scheme: "mastercard"}
+	lunch.Merchant.Name = "Pret A Manger"
+
+	holiday := monzo.Transaction{ID: "tx_pot", Amount: -10000, Currency: "GBP", Category: "savings", Description: "pot_holiday",
+		Created: at(5, 9, 0), Settled: at(5, 9, 0), Scheme: "uk_retail_pot", Metadata: map[string]string{"pot_id": "pot_holiday"}}
+
+	salary := monzo.Transaction{ID: "tx_salary", Amount: 250000, Currency: "GBP", Category: "income", Description: "ACME SALARY",
+		Created: at(25, 8, 15), Settled: at(25, 8, 15), Scheme: "payport_faster_payments",
+		Counterparty: map[string]any{"name": "ACME Ltd", "sort_code": "040004", "account_number": "12345678"}}
+
+	energy := monzo.Transaction{ID: "tx_energy", Amount: -1599, Currency: "GBP", Category: "bills", Description: "ENERGY CO",
+		Created: at(28, 4, 0), Settled: at(28, 4, 0), Scheme: "bacs", Counterparty: map[string]any{"name": "Energy Co"}}
+
+	cash := monzo.Transaction{ID: "tx_atm", Amount: -2000, Currency: "GBP", Category: "cash", Created: at(29, 18, 45), Settled: at(30, 6, 0)}
+	cash.Merchant.Name, cash.Merchant.ATM = "Cash Machine", true
+
+	coffee := monzo.Transaction{ID: "tx_pending", Amount: -870, Currency: "GBP", LocalAmount: -1000, LocalCurrency: "EUR",
+		Category: "eating_out", Notes: `Coffee & "croissant"`, Created: at(31, 23, 59), AmountIsPending: true, Scheme: "mastercard"}
+	coffee.Merchant.Name = `Café "Bleu"`
+
+	s := &Statement{
+		Account: monzo.Account{ID: "acc_1", Description: "user_1", Type: monzo.AccountTypeUKRetail,
+			PaymentDetails: monzo.PaymentDetails{LocaleUK: monzo.PaymentDetailsLocaleUK{SortCode: "040004", AccountNumber: "87654321"}}},
+		From:           time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+		Transactions:   []monzo.Transaction{lunch, holiday, salary, energy, cash, coffee},
+		OpeningBalance: 100000,
+		Currency:       "GBP",
+		Pots:           map[string]string{"pot_holiday": "Holiday"},
+		Generated:      time.Date(2023, 2, 2, 9, 30, 0, 0, time.UTC),
+	}
+
+	s.ClosingBalance = s.OpeningBalance
+	for _, tx := range s.Transactions {
+		s.ClosingBalance += tx.Amount
+	}
+
+	return s
+}
+
+func TestExporters(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		settings map[string]any
+	}{
+		{"ofx", "ofx", nil},
+		{"qif", "qif", nil},
+		{"ledger", "ledger", nil},
+		{"ledger-names", "ledger", map[string]any{
+			configKeyLedgerAccounts:   map[string]any{"acc_1": "Assets:Bank:Monzo"},
+			configKeyLedgerPots:       map[string]any{"pot_holiday": "Assets:Savings:Holiday"},
+			configKeyLedgerCategories: map[string]any{"income": "Income:Salary"},
+		}},
+		{"beancount", "beancount", nil},
+		{"camt053", "camt053", nil},
+		{"csv", "csv", nil},
+		{"csv-columns", "csv", map[string]any{
+			"csv-columns":   []string{"posted", "id", "payee", "notes", "debit", "credit", "local_amount", "local_currency", "pending", "counterparty_name", "balance"},
+			"csv-delimiter": ";",
+		}},
+	}
+
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	for _, test := range tests {
+		for key, value := range test.settings {
+			viper.Set(key, value)
+		}
+
+		b := &bytes.Buffer{}
+		err := Exporters[test.format](b, exportStatement())
+
+		for key := range test.settings {
+			viper.Set(key, nil)
+		}
+
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+
+		golden := path.Join("testdata", "export", test.name+".golden")
+
+		if *update {
+			assert.NoError(t, os.MkdirAll(path.Dir(golden), 0o755), test.name)
+			assert.NoError(t, os.WriteFile(golden, b.Bytes(), 0o644), test.name)
+		}
+
+		expected, err := os.ReadFile(golden)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, string(expected), b.String(), test.name)
+		}
+	}
+}
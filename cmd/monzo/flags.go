@@ -1,40 +1,114 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/arylatt/go-monzo"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
 )
 
 var (
-	FlagSets = map[string]*pflag.FlagSet{}
+	ErrEndpointURLInvalid = errors.New("url invalid, must be an absolute http(s) URL")
+
+	ErrAPIURLInvalid = errors.New("url invalid, must be only a scheme and host (and port), as API paths are absolute")
+
+	// FlagSets is populated during package variable initialisation (rather than in init) so that the flag sets
+	// are available to every other file's init function, regardless of file ordering.
+	FlagSets = newFlagSets()
 )
 
-func init() {
-	FlagSets["pagination"] = pflag.NewFlagSet("pagination", pflag.ContinueOnError)
-	FlagSets["pagination"].StringP("before", "b", "", "Pagination - return results before this date/time")
-	FlagSets["pagination"].StringP("since", "s", "", "Pagination - return results since this date/time")
-	FlagSets["pagination"].IntP("limit", "l", 0, "Pagination - return at most this many results")
+func newFlagSets() map[string]*pflag.FlagSet {
+	sets := map[string]*pflag.FlagSet{}
+
+	sets["pagination"] = pflag.NewFlagSet("pagination", pflag.ContinueOnError)
+	sets["pagination"].StringP("before", "b", "", "Pagination - return results before this date/time")
+	sets["pagination"].StringP("since", "s", "", "Pagination - return results since this date/time")
+	sets["pagination"].IntP("limit", "l", 0, "Pagination - return at most this many results")
+
+	sets["login"] = pflag.NewFlagSet("login", pflag.ContinueOnError)
+	sets["login"].StringP("token", "t", "", "Authenticate with static access token")
+	sets["login"].StringP("client-id", "c", "", "Authenticate with Client ID")
+	sets["login"].StringP("client-secret", "s", "", "Authenticate with Client Secret")
+	sets["login"].Bool("no-browser", false, "Do not open a browser or start a callback server, paste the redirected URL instead")
+	sets["login"].String("redirect-host", DefaultRedirectHost, "Host of the OAuth2 client's registered redirect URL")
+	sets["login"].Int("redirect-port", DefaultRedirectPort, "Port of the OAuth2 client's registered redirect URL")
+	sets["login"].String("redirect-path", DefaultRedirectPath, "Path of the OAuth2 client's registered redirect URL")
+	sets["login"].Duration("approval-timeout", DefaultApprovalTimeout, "How long to wait for access to be approved in the Monzo app")
+	sets["login"].String("backfill", BackfillBackground, fmt.Sprintf("How the full transaction history is fetched after OAuth2 login [%s]", strings.Join(BackfillModes, ", ")))
+	sets["login"].Bool("no-backfill", false, "Skip fetching the full transaction history after OAuth2 login")
+	sets["login"].MarkDeprecated("no-backfill", "use --backfill=off instead")
+
+	sets["account"] = pflag.NewFlagSet("account", pflag.ContinueOnError)
+	sets["account"].StringP("account-id", "a", "", "Account to use: an ID, an alias, an account type (personal, joint) or the account description (alias --account)")
 
-	FlagSets["login"] = pflag.NewFlagSet("login", pflag.ContinueOnError)
-	FlagSets["login"].StringP("token", "t", "", "Authenticate with static access token")
-	FlagSets["login"].StringP("client-id", "c", "", "Authenticate with Client ID")
-	FlagSets["login"].StringP("client-secret", "s", "", "Authenticate with Client Secret")
+	sets["cache"] = pflag.NewFlagSet("cache", pflag.ContinueOnError)
+	sets["cache"].String("cache", CacheModePrefer, fmt.Sprintf("How the transactions cache is used [%s]", strings.Join(CacheModes, ", ")))
+	sets["cache"].Duration("cache-ttl", DefaultCacheTTL, "How long cached transactions are used before being fetched again")
+	sets["cache"].Bool("no-cache", false, "Bypass transactions cache and force call to API")
+	sets["cache"].MarkDeprecated("no-cache", "use --cache=off instead")
 
-	FlagSets["account"] = pflag.NewFlagSet("account", pflag.ContinueOnError)
-	FlagSets["account"].StringP("account-id", "a", "", "Account ID to list transactions for")
+	sets["period"] = pflag.NewFlagSet("period", pflag.ContinueOnError)
+	sets["period"].String("from", "", "Start of the period, as a date (YYYY-MM-DD) or RFC3339 date/time (default: the start of the current month)")
+	sets["period"].String("to", "", "End of the period, as a date (included in the period) or RFC3339 date/time (default: now)")
 
-	FlagSets["cache"] = pflag.NewFlagSet("cache", pflag.ContinueOnError)
-	FlagSets["cache"].Bool("no-cache", false, "Bypass transactions cache and force call to API")
+	sets["expand"] = pflag.NewFlagSet("expand", pflag.ContinueOnError)
+	sets["expand"].Bool("expand-merchants", false, "Fetch expanded Merchants data")
 
-	FlagSets["expand"] = pflag.NewFlagSet("expand", pflag.ContinueOnError)
-	FlagSets["expand"].Bool("expand-merchants", false, "Fetch expanded Merchants data")
+	sets["output"] = pflag.NewFlagSet("output", pflag.ContinueOnError)
+	sets["output"].StringP("output", "o", OutputJSON, fmt.Sprintf("Output format [%s]", strings.Join(OutputFormats, ", ")))
+	sets["output"].StringSlice("columns", nil, "Columns to include for table and csv output (default: per-resource defaults)")
+	sets["output"].String("template", "", "Go text/template to execute for template output")
+	sets["output"].String("timezone", "", "Time zone to show dates and times in, e.g. Europe/London (default: the system time zone)")
 
-	for _, fs := range FlagSets {
+	sets["profile"] = pflag.NewFlagSet("profile", pflag.ContinueOnError)
+	sets["profile"].StringP("profile", "p", "", fmt.Sprintf("Profile to use (default: the profile selected with profiles use, or %s)", DefaultProfile))
+
+	sets["api"] = pflag.NewFlagSet("api", pflag.ContinueOnError)
+	sets["api"].String("api-url", monzo.BaseURL, "Base URL of the Monzo API, e.g. to use a local stand-in")
+	sets["api"].String("auth-url", monzo.OAuth2Endpoint.AuthURL, "URL of the Monzo OAuth2 authorisation page")
+	sets["api"].String("token-url", monzo.OAuth2Endpoint.TokenURL, "URL of the Monzo OAuth2 token endpoint")
+
+	sets["encryption"] = pflag.NewFlagSet("encryption", pflag.ContinueOnError)
+	sets["encryption"].String("cache-passphrase-command", "", "Command that prints the cache encryption passphrase to stdout")
+
+	for _, fs := range sets {
 		viper.BindPFlags(fs)
 	}
+
+	return sets
+}
+
+// ValidateEndpoints checks that the API and OAuth2 URLs are absolute http(s) URLs, and that the API URL has no path,
+// query or fragment (which requests would discard).
+func ValidateEndpoints() error {
+	for _, key := range []string{"api-url", "auth-url", "token-url"} {
+		u, err := url.Parse(viper.GetString(key))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w --%s '%s'", ErrEndpointURLInvalid, key, viper.GetString(key))
+		}
+
+		if key == "api-url" && ((u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" || u.User != nil) {
+			return fmt.Errorf("%w --%s '%s'", ErrAPIURLInvalid, key, viper.GetString(key))
+		}
+	}
+
+	return nil
+}
+
+// APIBaseURL returns the configured base URL of the Monzo API.
+func APIBaseURL() *url.URL {
+	u, _ := url.Parse(viper.GetString("api-url"))
+	return u
+}
+
+// OAuth2Endpoint returns the configured OAuth2 endpoint.
+func OAuth2Endpoint() oauth2.Endpoint {
+	return monzo.NewOAuth2Endpoint(viper.GetString("auth-url"), viper.GetString("token-url"))
 }
 
 func BuildPagination() *monzo.Pagination {
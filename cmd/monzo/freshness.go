@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// CacheModePrefer serves transactions from the cache when it covers the requested range and is fresh, and fetches
+	// them from the API otherwise.
+	CacheModePrefer = "prefer"
+
+	// CacheModeOnly only serves transactions from the cache, and never calls the API.
+	CacheModeOnly = "only"
+
+	// CacheModeRefresh always fetches transactions from the API, and stores them in the cache.
+	CacheModeRefresh = "refresh"
+
+	// CacheModeOff always fetches transactions from the API, without reading or writing the cache.
+	CacheModeOff = "off"
+
+	// DefaultCacheTTL is how long after being fetched cached transactions are considered up to date.
+	DefaultCacheTTL = time.Hour
+)
+
+var (
+	CacheModes = []string{CacheModePrefer, CacheModeOnly, CacheModeRefresh, CacheModeOff}
+
+	ErrCacheModeInvalid = fmt.Errorf("cache mode invalid. valid modes [%s]", strings.Join(CacheModes, ", "))
+
+	ErrTransactionNotCached = errors.New("transaction not found in the cache")
+)
+
+// CacheMode returns the mode selected with --cache. --no-cache is an alias for --cache=off, and --offline forces
+// --cache=only.
+func CacheMode() (string, error) {
+	if Offline() {
+		return CacheModeOnly, nil
+	}
+
+	if viper.GetBool("no-cache") {
+		return CacheModeOff, nil
+	}
+
+	mode := viper.GetString("cache")
+
+	for _, m := range CacheModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+
+	return "", ErrCacheModeInvalid
+}
+
+// coveredUntil returns when the covered range was last fetched. Sync state saved before coverage was tracked is
+// treated as covering the full history up to the last sync.
+func (s *AccountSyncState) coveredUntil() time.Time {
+	if s.CoveredUntil.IsZero() {
+		return s.LastSynced
+	}
+
+	return s.CoveredUntil
+}
+
+// coversFrom reports whether the covered range starts at or before since. A zero since is the beginning of the
+// account's history.
+func (s *AccountSyncState) coversFrom(since time.Time) bool {
+	if s == nil || s.coveredUntil().IsZero() {
+		return false
+	}
+
+	return s.CoveredFrom.IsZero() || (!since.IsZero() && !since.Before(s.CoveredFrom))
+}
+
+// Covers reports whether every transaction created in [since, before) is cached, and (if the range extends past when
+// the cache was last fetched) the cache is newer than the TTL. Zero times are unbounded.
+func (s *AccountSyncState) Covers(since, before time.Time, ttl time.Duration) bool {
+	if !s.coversFrom(since) {
+		return false
+	}
+
+	if !before.IsZero() && !before.After(s.coveredUntil()) {
+		return true
+	}
+
+	return time.Since(s.coveredUntil()) < ttl
+}
+
+// Gap returns when transactions must be fetched from to bring a range starting at since up to date: the end of the
+// covered range less the window, so recent transactions that may have changed since are fetched again. It reports
+// false if the covered range does not reach back to since, so the whole range must be fetched.
+func (s *AccountSyncState) Gap(since time.Time, window time.Duration) (time.Time, bool) {
+	if !s.coversFrom(since) {
+		return time.Time{}, false
+	}
+
+	if from := s.coveredUntil().Add(-window); from.After(since) {
+		return from, true
+	}
+
+	return since, true
+}
+
+// AddCoverage records that every transaction created in [since, before) was fetched at the given time. Zero times are
+// unbounded.
+//
+// historyFrom is the earliest creation time the API would return transactions from when they were fetched, or zero if
+// the full history was available. A range reaching back past it is only recorded from it.
+//
+// Only a single contiguous range is tracked per account, so a range that does not overlap the existing one replaces it
+// if it is more recent.
+func (s *AccountSyncState) AddCoverage(since, before, fetched, historyFrom time.Time) {
+	if since.Before(historyFrom) {
+		since = historyFrom
+	}
+
+	end := before
+	if end.IsZero() || end.After(fetched) {
+		end = fetched
+	}
+
+	until := s.coveredUntil()
+
+	if until.IsZero() || since.After(until) || end.Before(s.CoveredFrom) {
+		if until.IsZero() || end.After(until) {
+			s.CoveredFrom, s.CoveredUntil = since, end
+		}
+
+		return
+	}
+
+	if since.IsZero() || since.Before(s.CoveredFrom) {
+		s.CoveredFrom = since
+	}
+
+	if end.After(until) {
+		s.CoveredUntil = end
+	} else {
+		s.CoveredUntil = until
+	}
+}
+
+// fetchesPage reports whether only the requested page of transactions is fetched, rather than the whole range: when a
+// limit is set, or since is a transaction ID.
+func fetchesPage(page *monzo.Pagination) bool {
+	return page != nil && (page.Limit != 0 || (page.Since != "" && page.SinceTime().IsZero()))
+}
+
+// historySince returns since, or the earliest creation time of the transactions the API can return if since is
+// before it. The user is warned that transactions may be missing as a result, unless the cache covers them.
+func historySince(cmd *cobra.Command, state *AccountSyncState, accountID string, since time.Time) time.Time {
+	historyFrom := _token.HistoryFrom(time.Now().UTC())
+
+	if !since.Before(historyFrom) {
+		return since
+	}
+
+	if !since.IsZero() && (!state.coversFrom(since) || state.coveredUntil().Before(historyFrom)) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: transactions for %s created before %s can no longer be fetched, so some may be missing\n",
+			accountID, formatDate(historyFrom))
+	}
+
+	return historyFrom
+}
+
+// fetchTransactions fetches an account's transactions created in the query's range from the API into the store, and
+// records the range in the account's sync state, so later queries within it can be served from the cache.
+//
+// If the page has a limit, or since is a transaction ID, only the requested page is fetched.
+func fetchTransactions(c *monzo.Client, store Store, accountID string, expandMerchants bool, page *monzo.Pagination, query StoreQuery) (err error) {
+	if fetchesPage(page) {
+		list, err := c.Transactions.List(accountID, expandMerchants, page)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = store.Upsert(accountID, list.Transactions...)
+
+		return err
+	}
+
+	fetched := time.Now().UTC()
+
+	paging := &monzo.Pagination{}
+	if page != nil {
+		paging.Before = page.Before
+	}
+
+	if !query.Since.IsZero() {
+		paging.Since = query.Since.UTC().Format(time.RFC3339)
+	}
+
+	err = c.Transactions.ListPages(accountID, expandMerchants, paging, func(list *monzo.TransactionList) error {
+		_, _, err := store.Upsert(accountID, list.Transactions...)
+		return err
+	})
+
+	if err != nil {
+		return
+	}
+
+	openCaches.SyncState().Account(accountID).AddCoverage(query.Since, query.Before, fetched, _token.HistoryFrom(fetched))
+
+	return
+}
+
+// ensureCached fetches an account's transactions in the page's range into the store, unless the cache mode allows them
+// to be served from the cache. When only the cache can be used, the user is told how current it is.
+//
+// In prefer mode, a stale cache covering the start of the range is brought up to date by fetching only the
+// transactions created since it was last fetched (less the sync window), and the rest of the range is served from the
+// cache.
+func ensureCached(cmd *cobra.Command, mode string, store Store, accountID string, expandMerchants bool, page *monzo.Pagination, query StoreQuery) (err error) {
+	state := openCaches.SyncState()[accountID]
+	ttl := viper.GetDuration("cache-ttl")
+
+	if mode == CacheModeOnly {
+		switch {
+		case Offline():
+			if state == nil {
+				state = &AccountSyncState{}
+			}
+
+			reportOffline(cmd, fmt.Sprintf("transactions for %s", accountID), state.coveredUntil())
+		case !state.Covers(query.Since, query.Before, ttl):
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: cached transactions for %s may be incomplete or out of date\n", accountID)
+		}
+
+		return
+	}
+
+	if !fetchesPage(page) {
+		query.Since = historySince(cmd, state, accountID, query.Since)
+	}
+
+	if mode == CacheModePrefer {
+		if state.Covers(query.Since, query.Before, ttl) {
+			return
+		}
+
+		if gap, ok := state.Gap(query.Since, viper.GetDuration("window")); ok {
+			query.Since = gap
+		}
+	}
+
+	return fetchTransactions(_client, store, accountID, expandMerchants, page, query)
+}
+
+// transactionsSince returns the account's transactions created since the time, in the order they were created, with
+// expanded merchants. They are loaded from the cache where the cache mode allows.
+func transactionsSince(cmd *cobra.Command, accountID string, since time.Time) ([]monzo.Transaction, error) {
+	mode, _ := CacheMode()
+
+	query := StoreQuery{Since: since}
+
+	if mode == CacheModeOff {
+		store := NewMemoryStore()
+		page := &monzo.Pagination{Since: historySince(cmd, nil, accountID, since).UTC().Format(time.RFC3339)}
+
+		err := _client.Transactions.ListPages(accountID, true, page, func(list *monzo.TransactionList) error {
+			_, _, err := store.Upsert(accountID, list.Transactions...)
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return store.Range(accountID, query)
+	}
+
+	store, err := openCaches.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ensureCached(cmd, mode, store, accountID, true, nil, query); err != nil {
+		return nil, err
+	}
+
+	return store.Range(accountID, query)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountSyncStateCovers(t *testing.T) {
+	now := time.Now().UTC()
+	day := time.Hour * 24
+
+	tests := []struct {
+		name          string
+		state         *AccountSyncState
+		since, before time.Time
+		expected      bool
+	}{
+		{"no state", nil, time.Time{}, time.Time{}, false},
+		{"never fetched", &AccountSyncState{}, time.Time{}, time.Time{}, false},
+		{"full history", &AccountSyncState{CoveredUntil: now}, time.Time{}, time.Time{}, true},
+		{"legacy sync state", &AccountSyncState{LastSynced: now}, now.Add(-day), time.Time{}, true},
+		{"within range", &AccountSyncState{CoveredFrom: now.Add(-day * 10), CoveredUntil: now}, now.Add(-day), time.Time{}, true},
+		{"before range", &AccountSyncState{CoveredFrom: now.Add(-day * 10), CoveredUntil: now}, now.Add(-day * 11), time.Time{}, false},
+		{"unbounded before range", &AccountSyncState{CoveredFrom: now.Add(-day * 10), CoveredUntil: now}, time.Time{}, time.Time{}, false},
+		{"stale", &AccountSyncState{CoveredUntil: now.Add(-day)}, time.Time{}, time.Time{}, false},
+		{"stale but ends before fetched", &AccountSyncState{CoveredUntil: now.Add(-day)}, time.Time{}, now.Add(-day * 2), true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.state.Covers(test.since, test.before, time.Hour), test.name)
+	}
+}
+
+func TestAccountSyncStateGap(t *testing.T) {
+	now := time.Now().UTC()
+	day := time.Hour * 24
+
+	tests := []struct {
+		name     string
+		state    *AccountSyncState
+		since    time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{"no state", nil, time.Time{}, time.Time{}, false},
+		{"full history", &AccountSyncState{CoveredUntil: now.Add(-day * 30)}, time.Time{}, now.Add(-day * 44), true},
+		{"within range", &AccountSyncState{CoveredFrom: now.Add(-day * 100), CoveredUntil: now.Add(-day * 30)}, now.Add(-day * 90), now.Add(-day * 44), true},
+		{"since within window", &AccountSyncState{CoveredUntil: now.Add(-day)}, now.Add(-day * 3), now.Add(-day * 3), true},
+		{"before range", &AccountSyncState{CoveredFrom: now.Add(-day * 10), CoveredUntil: now}, now.Add(-day * 11), time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		from, ok := test.state.Gap(test.since, DefaultSyncWindow)
+
+		assert.Equal(t, test.ok, ok, test.name)
+		assert.Equal(t, test.expected, from, test.name)
+	}
+}
+
+func TestAccountSyncStateAddCoverage(t *testing.T) {
+	at := func(day int) time.Time {
+		return time.Date(2023, 6, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name                        string
+		state                       AccountSyncState
+		since, before, historyFrom  time.Time
+		expectedFrom, expectedUntil time.Time
+	}{
+		{"first range", AccountSyncState{}, at(5), time.Time{}, time.Time{}, at(5), at(20)},
+		{"full history", AccountSyncState{}, time.Time{}, time.Time{}, time.Time{}, time.Time{}, at(20)},
+		{"full history outside the window", AccountSyncState{}, time.Time{}, time.Time{}, at(1), at(1), at(20)},
+		{"since before the window", AccountSyncState{CoveredFrom: at(3), CoveredUntil: at(10)}, at(2), time.Time{}, at(4), at(3), at(20)},
+		{"extends the end", AccountSyncState{CoveredFrom: at(1), CoveredUntil: at(10)}, at(8), time.Time{}, time.Time{}, at(1), at(20)},
+		{"extends the start", AccountSyncState{CoveredFrom: at(5), CoveredUntil: at(10)}, at(2), at(6), time.Time{}, at(2), at(10)},
+		{"newer range replaces", AccountSyncState{CoveredFrom: at(1), CoveredUntil: at(3)}, at(10), time.Time{}, time.Time{}, at(10), at(20)},
+		{"older range ignored", AccountSyncState{CoveredFrom: at(10), CoveredUntil: at(15)}, at(1), at(5), time.Time{}, at(10), at(15)},
+	}
+
+	for _, test := range tests {
+		test.state.AddCoverage(test.since, test.before, at(20), test.historyFrom)
+
+		assert.Equal(t, test.expectedFrom, test.state.CoveredFrom, test.name)
+		assert.Equal(t, test.expectedUntil, test.state.CoveredUntil, test.name)
+	}
+}
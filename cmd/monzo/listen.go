@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultListenAddress is the address the webhook receiver listens on, unless overridden with --listen-address.
+	DefaultListenAddress = "localhost:54093"
+
+	// listenShutdownTimeout is how long the receiver waits for requests in progress to complete when stopping.
+	listenShutdownTimeout = time.Second * 5
+
+	// listenForwardTimeout is how long forwarding a webhook to --forward-url can take.
+	listenForwardTimeout = time.Second * 10
+)
+
+var (
+	listenCmd = &cobra.Command{
+		Use:     "listen --public-url https://...",
+		Short:   "Receive webhooks for an account until interrupted, registering the webhook on start and deleting it on exit",
+		GroupID: "transactions",
+		PreRunE: listenPreRunE,
+		RunE:    listenRunE,
+		Args:    cobra.NoArgs,
+	}
+
+	ErrListenPublicURLRequired = errors.New("--public-url must be set to the public URL that forwards to the listen address")
+
+	ErrListenURLInvalid = errors.New("url invalid, must be an absolute http or https URL")
+)
+
+func init() {
+	FlagSets["listen"] = pflag.NewFlagSet("listen", pflag.ContinueOnError)
+	FlagSets["listen"].String("listen-address", DefaultListenAddress, "Host and port to receive webhooks on")
+	FlagSets["listen"].String("public-url", "", "Public URL to register the webhook with, which forwards to the listen address (e.g. a tunnel)")
+	FlagSets["listen"].String("forward-url", "", "URL to forward each webhook to, e.g. a local development server")
+	FlagSets["listen"].Bool("budget-alerts", false, "Post a feed item for each budget a transaction pushes over its alert threshold (see budget alert)")
+	viper.BindPFlags(FlagSets["listen"])
+
+	listenCmd.Flags().AddFlagSet(FlagSets["listen"])
+	listenCmd.Flags().AddFlagSet(FlagSets["budget"])
+	listenCmd.Flags().AddFlagSet(FlagSets["account"])
+	listenCmd.Flags().AddFlagSet(FlagSets["cache"])
+
+	listenCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	root.AddCommand(listenCmd)
+}
+
+// receivedWebhook is a webhook received by the listener, with the request body as it was sent.
+type receivedWebhook struct {
+	payload *monzo.WebhookPayload
+	body    []byte
+}
+
+func listenPreRunE(cmd *cobra.Command, args []string) (err error) {
+	if viper.GetString("public-url") == "" {
+		return ErrListenPublicURLRequired
+	}
+
+	for _, key := range []string{"public-url", "forward-url"} {
+		if err = validateListenURL(viper.GetString(key)); err != nil {
+			return
+		}
+	}
+
+	if viper.GetBool("budget-alerts") && viper.GetString("feed-image-url") == "" {
+		return ErrBudgetImageURLRequired
+	}
+
+	_, err = CacheMode()
+	return
+}
+
+func validateListenURL(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w '%s'", ErrListenURLInvalid, value)
+	}
+
+	return nil
+}
+
+func listenRunE(cmd *cobra.Command, args []string) (err error) {
+	accountID, err := ResolveAccount(cmd)
+	if err != nil {
+		return
+	}
+
+	publicURL := viper.GetString("public-url")
+
+	// Listen before registering, so that the webhook is not registered if the address is unavailable.
+	listener, err := net.Listen("tcp", viper.GetString("listen-address"))
+	if err != nil {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	received := make(chan receivedWebhook)
+
+	srv := &http.Server{Handler: monzo.WebhookPayloadHandler(func(rw http.ResponseWriter, r *http.Request, payload *monzo.WebhookPayload) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body := &bytes.Buffer{}
+		body.ReadFrom(r.Body)
+
+		rw.WriteHeader(http.StatusOK)
+
+		select {
+		case received <- receivedWebhook{payload: payload, body: body.Bytes()}:
+		case <-ctx.Done():
+		}
+	})}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(listener) }()
+
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), listenShutdownTimeout)
+		defer cancel()
+
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err = deleteStaleWebhooks(cmd, accountID, publicURL); err != nil {
+		return
+	}
+
+	webhook, err := _client.Webhooks.Register(accountID, publicURL)
+	if err != nil {
+		return
+	}
+
+	// The webhook is deleted however the listener stops, including after errors.
+	defer func() {
+		if deleteErr := webhook.Webhook.Delete(); deleteErr != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Error: failed to delete webhook %s - %s\n", webhook.Webhook.ID, deleteErr.Error())
+
+			if err == nil {
+				err = deleteErr
+			}
+
+			return
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), "Deleted webhook %s\n", webhook.Webhook.ID)
+	}()
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Registered webhook %s for %s at %s, receiving on %s. Press Ctrl-C to stop.\n", webhook.Webhook.ID, accountID, publicURL, listener.Addr())
+
+	stream := NewStream(cmd)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err = <-serveErr:
+			return
+		case hook := <-received:
+			if err = handleWebhook(cmd, stream, hook); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// deleteStaleWebhooks deletes the account's webhooks registered at the URL, left behind by listeners that did not stop
+// cleanly.
+func deleteStaleWebhooks(cmd *cobra.Command, accountID, webhookURL string) error {
+	list, err := _client.Webhooks.List(accountID)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range list.Webhooks {
+		if webhook.URL != webhookURL {
+			continue
+		}
+
+		if err = webhook.Delete(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), "Deleted stale webhook %s\n", webhook.ID)
+	}
+
+	return nil
+}
+
+// handleWebhook outputs a received webhook, forwards it, records its transaction in the transaction store (unless
+// caching is off), and posts budget alerts if enabled. Failures to forward it or post alerts are reported as warnings.
+func handleWebhook(cmd *cobra.Command, stream *Stream, webhook receivedWebhook) (err error) {
+	if err = stream.Write(webhook.payload); err != nil {
+		return
+	}
+
+	if forwardURL := viper.GetString("forward-url"); forwardURL != "" {
+		if err := forwardWebhook(forwardURL, webhook.body); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to forward webhook - %s\n", err.Error())
+		}
+	}
+
+	tx := webhook.payload.Data
+	if !strings.HasPrefix(webhook.payload.Type, "transaction.") || tx.ID == "" {
+		return
+	}
+
+	// The transaction is only stored if caching is on. Budget alerts load the transactions they are checked against
+	// in the same way either way, fetching them if the cache is off.
+	var previous *monzo.Transaction
+
+	if mode, _ := CacheMode(); mode != CacheModeOff {
+		if previous, err = storeWebhookTransaction(tx); err != nil {
+			return
+		}
+	}
+
+	if viper.GetBool("budget-alerts") {
+		alerts, err := postBudgetAlerts(cmd, tx, previous)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to post budget alerts - %s\n", err.Error())
+		}
+
+		for _, alert := range alerts {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Posted budget alert for %s (%s spent)\n", alert.Name, alert.PercentSpent())
+		}
+	}
+
+	return openCaches.Flush()
+}
+
+// forwardWebhook posts the webhook body to the URL, as Monzo sent it.
+func forwardWebhook(forwardURL string, body []byte) error {
+	client := &http.Client{Timeout: listenForwardTimeout}
+
+	resp, err := client.Post(forwardURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded %s", forwardURL, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	// LockFile is the file in each profile directory used to serialise access to the profile's cache between processes.
+	LockFile = ".lock"
+)
+
+// profileLockMu serialises locking within the process, as the advisory lock is held by the process rather than a goroutine.
+var profileLockMu sync.Mutex
+
+// LockProfile takes an exclusive advisory lock on the profile's cache files, blocking until any other process holding
+// the lock releases it. The lock must not be taken again by the same process before unlock is called.
+func LockProfile(profile string) (unlock func(), err error) {
+	profileLockMu.Lock()
+
+	f, err := os.OpenFile(path.Join(ProfileDir(profile), LockFile), os.O_CREATE|os.O_RDWR, CacheFilePerm)
+	if err != nil {
+		profileLockMu.Unlock()
+		return
+	}
+
+	if err = lockFile(f); err != nil {
+		f.Close()
+		profileLockMu.Unlock()
+		return
+	}
+
+	unlock = func() {
+		unlockFile(f)
+		f.Close()
+		profileLockMu.Unlock()
+	}
+
+	return
+}
+
+// WithProfileLock calls fn while holding the lock on the profile's cache files.
+func WithProfileLock(profile string, fn func() error) (err error) {
+	unlock, err := LockProfile(profile)
+	if err != nil {
+		return
+	}
+
+	defer unlock()
+
+	return fn()
+}
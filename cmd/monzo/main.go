@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/arylatt/go-monzo"
 	"github.com/spf13/cobra"
@@ -16,19 +19,20 @@ import (
 
 var (
 	_client *monzo.Client
+	_token  *Token
 
 	root = &cobra.Command{
-		Use:                "monzo",
-		Short:              "CLI for interacting with Monzo APIs",
-		PersistentPreRunE:  rootPersistentPreRunE,
-		PersistentPostRunE: rootPersistentPostRunE,
+		Use:               "monzo",
+		Short:             "CLI for interacting with Monzo APIs",
+		PersistentPreRunE: rootPersistentPreRunE,
 	}
 
 	genDocs = &cobra.Command{
-		Use:   "generate-docs [docs-dir]",
-		Short: "Generate Markdown docs for CLI",
-		RunE:  genDocsRunE,
-		Args:  cobra.MaximumNArgs(1),
+		Use:         "generate-docs [docs-dir]",
+		Short:       "Generate Markdown docs for CLI",
+		RunE:        genDocsRunE,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{annotationNoAuth: "true", annotationOffline: "true"},
 	}
 
 	version   = "dev"
@@ -40,16 +44,33 @@ func init() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
+	root.PersistentFlags().AddFlagSet(FlagSets["output"])
+	root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(OutputFormats, cobra.ShellCompDirectiveNoFileComp))
+	root.PersistentFlags().AddFlagSet(FlagSets["api"])
+
 	root.AddCommand(genDocs)
 }
 
 func main() {
-	if root.Execute() != nil {
+	err := root.Execute()
+
+	// Changes to the caches are written once, after the command has run (even if it failed part way through).
+	if flushErr := openCaches.Flush(); flushErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save cache - %s\n", flushErr.Error())
+		err = flushErr
+	}
+
+	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func rootPersistentPreRunE(cmd *cobra.Command, args []string) (err error) {
+	if completing(cmd) {
+		viper.Set("offline", true)
+		passphrasePrompt = false
+	}
+
 	userHome, err := os.UserHomeDir()
 	if err != nil {
 		return
@@ -57,39 +78,54 @@ func rootPersistentPreRunE(cmd *cobra.Command, args []string) (err error) {
 
 	viper.SetDefault("home-dir", path.Join(userHome, "/.monzo/"))
 
-	if err = os.MkdirAll(viper.GetString("home-dir"), os.ModeDir|0600); err != nil {
+	if err = EnsureCacheDir(viper.GetString("home-dir")); err != nil {
 		return
 	}
 
-	if cmd.Name() == "login" || cmd.Name() == "logout" || cmd == cmd.Root() {
+	if err = InitConfig(); err != nil {
 		return
 	}
 
-	token := &Token{}
-	err = LoadCache(CacheFileToken, token)
-	if err != nil {
-		return fmt.Errorf("not authenticated, try running monzo login - %w", err)
+	if err = ValidateOutput(); err != nil {
+		return
+	}
+
+	if err = ValidateEndpoints(); err != nil {
+		return
 	}
 
-	_client = BuildClient(cmd.Context(), token)
+	if err = InitProfile(); err != nil {
+		return
+	}
 
-	return
-}
+	if Offline() {
+		if !supportsOffline(cmd) {
+			return fmt.Errorf("%s: %w", cmd.CommandPath(), ErrOfflineUnsupported)
+		}
 
-func rootPersistentPostRunE(cmd *cobra.Command, args []string) (err error) {
-	if _client == nil {
 		return
 	}
 
-	token := &Token{}
-	LoadCache(CacheFileToken, token)
+	if !requiresAuth(cmd) {
+		return
+	}
 
-	token.Token, err = _client.Token()
-	if err != nil {
+	token, err := LoadToken(CurrentProfile())
+	if errors.Is(err, ErrCacheDecrypt) || errors.Is(err, ErrCachePassphraseMissing) {
 		return
 	}
 
-	return token.Save()
+	if err != nil {
+		return fmt.Errorf("not authenticated, try running monzo login - %w", err)
+	}
+
+	if token.Expired() {
+		return fmt.Errorf("%w (expired %s)", ErrTokenExpired, token.Expiry.Local().Format(time.RFC1123))
+	}
+
+	_client, _token = BuildClient(cmd.Context(), token), token
+
+	return
 }
 
 func genDocsRunE(cmd *cobra.Command, args []string) (err error) {
@@ -98,7 +134,7 @@ func genDocsRunE(cmd *cobra.Command, args []string) (err error) {
 		dirName = args[0]
 	}
 
-	err = os.MkdirAll(dirName, os.ModeDir)
+	err = os.MkdirAll(dirName, 0755)
 	if err != nil {
 		return
 	}
@@ -112,18 +148,67 @@ func BuildClient(ctx context.Context, token *Token) (c *monzo.Client) {
 	if token.RefreshToken == "" {
 		ts = oauth2.StaticTokenSource(token.Token)
 	} else {
-		config := oauth2.Config{
-			ClientID:     token.ClientID,
-			ClientSecret: token.ClientSecret,
-			Endpoint:     monzo.OAuth2Endpoint,
+		ts = &profileTokenSource{
+			ctx:   ctx,
+			token: token,
+			config: &oauth2.Config{
+				ClientID:     token.ClientID,
+				ClientSecret: token.ClientSecret,
+				Endpoint:     OAuth2Endpoint(),
+			},
 		}
-
-		ts = config.TokenSource(ctx, token.Token)
 	}
 
 	c = monzo.New(oauth2.NewClient(ctx, ts))
 
+	c.BaseURL = APIBaseURL()
+
 	c.UserAgent = fmt.Sprintf("%s, %s", userAgent, monzo.DefaultUserAgent)
 
 	return
 }
+
+// profileTokenSource refreshes the token while holding the profile lock, and saves the new token straight away.
+//
+// Refresh tokens can only be used once, so if another process refreshed the token while waiting for the lock, the token
+// it saved is used instead of refreshing again.
+type profileTokenSource struct {
+	ctx    context.Context
+	config *oauth2.Config
+	token  *Token
+
+	mu sync.Mutex
+}
+
+func (s *profileTokenSource) Token() (tok *oauth2.Token, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token.Token, nil
+	}
+
+	profile := s.token.Profile()
+
+	err = WithProfileLock(profile, func() (err error) {
+		latest := &Token{}
+		if err := LoadProfileCache(profile, CacheFileToken, latest); err == nil && latest.Valid() && latest.AccessToken != s.token.AccessToken {
+			s.token.Token = latest.Token
+			return nil
+		}
+
+		if s.token.Token, err = s.config.TokenSource(s.ctx, s.token.Token).Token(); err != nil {
+			return
+		}
+
+		if latest.Token == nil {
+			latest = s.token
+		} else {
+			latest.Token = s.token.Token
+		}
+
+		return SaveProfileCache(profile, CacheFileToken, latest)
+	})
+
+	return s.token.Token, err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	CacheFileAccounts = "accounts"
+	CacheFileBalances = "balances"
+	CacheFilePots     = "pots"
+
+	// annotationOffline marks commands (and their subcommands) that can run with --offline.
+	annotationOffline = "monzo_offline"
+)
+
+var (
+	ErrOfflineUnsupported = errors.New("command changes data through the Monzo API, so cannot be run with --offline")
+
+	ErrOfflineNotCached = errors.New("no cached data available offline, run the command without --offline first")
+)
+
+func init() {
+	FlagSets["offline"] = pflag.NewFlagSet("offline", pflag.ContinueOnError)
+	FlagSets["offline"].Bool("offline", false, "Serve read commands from the local cache without calling the Monzo API")
+	viper.BindPFlags(FlagSets["offline"])
+
+	root.PersistentFlags().AddFlagSet(FlagSets["offline"])
+}
+
+// Snapshot is a cached API response, with the time it was fetched.
+type Snapshot[T any] struct {
+	Fetched time.Time `json:"fetched"`
+	Data    T         `json:"data"`
+}
+
+// Offline reports whether --offline is set.
+func Offline() bool {
+	return viper.GetBool("offline")
+}
+
+// supportsOffline reports whether the command can run with --offline, based on the annotations of the command and its parents.
+func supportsOffline(cmd *cobra.Command) bool {
+	if completing(cmd) {
+		return true
+	}
+
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[annotationOffline] == "true" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportOffline tells the user how current the cached data being shown offline is.
+func reportOffline(cmd *cobra.Command, subject string, asOf time.Time) {
+	if asOf.IsZero() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Offline: no %s cached\n", subject)
+		return
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Offline: showing %s as of %s (%s ago)\n", subject,
+		asOf.Local().Format(time.RFC1123), time.Since(asOf).Round(time.Minute))
+}
+
+// saveAccountsSnapshot caches the user's accounts for use offline.
+func saveAccountsSnapshot(accounts *monzo.AccountsList) error {
+	return WithProfileLock(CurrentProfile(), func() error {
+		return SaveCache(CacheFileAccounts, &Snapshot[*monzo.AccountsList]{Fetched: time.Now().UTC(), Data: accounts})
+	})
+}
+
+// saveBalanceSnapshot caches the account's balance for use offline.
+func saveBalanceSnapshot(accountID string, balance *monzo.Balance) error {
+	return WithProfileLock(CurrentProfile(), func() error {
+		balances := map[string]*Snapshot[*monzo.Balance]{}
+		LoadCache(CacheFileBalances, &balances)
+
+		balances[accountID] = &Snapshot[*monzo.Balance]{Fetched: time.Now().UTC(), Data: balance}
+
+		return SaveCache(CacheFileBalances, balances)
+	})
+}
+
+// savePotsSnapshot caches the account's pots for use offline.
+func savePotsSnapshot(accountID string, pots *monzo.PotsList) error {
+	return WithProfileLock(CurrentProfile(), func() error {
+		snapshots := map[string]*Snapshot[*monzo.PotsList]{}
+		LoadCache(CacheFilePots, &snapshots)
+
+		snapshots[accountID] = &Snapshot[*monzo.PotsList]{Fetched: time.Now().UTC(), Data: pots}
+
+		return SaveCache(CacheFilePots, snapshots)
+	})
+}
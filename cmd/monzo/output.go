@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OutputJSON        = "json"
+	OutputJSONCompact = "json-compact"
+	OutputYAML        = "yaml"
+	OutputTable       = "table"
+	OutputCSV         = "csv"
+	OutputTemplate    = "template"
+)
+
+var (
+	OutputFormats = []string{OutputJSON, OutputJSONCompact, OutputYAML, OutputTable, OutputCSV, OutputTemplate}
+
+	ErrOutputFormatInvalid = fmt.Errorf("output format invalid. valid formats [%s]", strings.Join(OutputFormats, ", "))
+
+	ErrOutputTemplateMissing = errors.New("--template must be provided for template output")
+
+	ErrOutputColumnInvalid = errors.New("column invalid")
+
+	ErrAmountInvalid = errors.New("amount invalid, must be a decimal number such as 12.34")
+)
+
+// Column describes a single field of a resource when it is rendered as a table or CSV.
+type Column struct {
+	Name  string
+	Value func(row any) string
+}
+
+// Table describes how a resource is rendered by the table and CSV output formats.
+//
+// Columns lists every column that can be selected with --columns, and Defaults lists the columns used when none are selected.
+type Table struct {
+	Columns  []Column
+	Defaults []string
+	Rows     []any
+}
+
+// ValidateOutput checks that the configured output format (and any template it requires) is usable,
+// so that commands can fail before making any API calls.
+func ValidateOutput() error {
+	format := viper.GetString("output")
+
+	for _, f := range OutputFormats {
+		if format != f {
+			continue
+		}
+
+		if format == OutputTemplate && strings.TrimSpace(viper.GetString("template")) == "" {
+			return ErrOutputTemplateMissing
+		}
+
+		return nil
+	}
+
+	return ErrOutputFormatInvalid
+}
+
+// Output writes v to the command's output stream in the configured output format.
+func Output(cmd *cobra.Command, v any) (err error) {
+	w := cmd.OutOrStdout()
+
+	switch viper.GetString("output") {
+	case OutputJSON, "":
+		return outputJSON(w, v, true)
+	case OutputJSONCompact:
+		return outputJSON(w, v, false)
+	case OutputYAML:
+		return outputYAML(w, v)
+	case OutputTable:
+		return outputTable(w, v)
+	case OutputCSV:
+		return outputCSV(w, v)
+	case OutputTemplate:
+		return outputTemplate(w, v, viper.GetString("template"))
+	}
+
+	return ErrOutputFormatInvalid
+}
+
+func outputJSON(w io.Writer, v any, indent bool) (err error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+
+	return enc.Encode(v)
+}
+
+// outputYAML round-trips v through JSON so that the JSON field names are used, and the field ordering is preserved.
+func outputYAML(w io.Writer, v any) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	node := &yaml.Node{}
+	if err = yaml.Unmarshal(data, node); err != nil {
+		return
+	}
+
+	resetYAMLStyle(node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err = enc.Encode(node); err != nil {
+		return
+	}
+
+	return enc.Close()
+}
+
+// resetYAMLStyle clears the flow/quoted styles that the YAML parser records when reading JSON input.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+func outputTable(w io.Writer, v any) (err error) {
+	columns, rows, err := tableRows(v)
+	if err != nil {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func outputCSV(w io.Writer, v any) (err error) {
+	columns, rows, err := tableRows(v)
+	if err != nil {
+		return
+	}
+
+	cw := csv.NewWriter(w)
+
+	if err = cw.Write(columns); err != nil {
+		return
+	}
+
+	if err = cw.WriteAll(rows); err != nil {
+		return
+	}
+
+	return cw.Error()
+}
+
+func outputTemplate(w io.Writer, v any, text string) (err error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, v); err != nil {
+		return
+	}
+
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+
+	_, err = buf.WriteTo(w)
+	return
+}
+
+// Stream writes values to a command's output stream one at a time, as they arrive, in the configured output format.
+//
+// Table and CSV output have a single header row. As later rows are not known in advance, table columns are widened
+// as longer values are written, rather than aligned. YAML values are written as separate documents.
+type Stream struct {
+	cmd *cobra.Command
+
+	written int
+	widths  []int
+}
+
+// NewStream returns a stream writing to the command's output stream.
+func NewStream(cmd *cobra.Command) *Stream {
+	return &Stream{cmd: cmd}
+}
+
+// Write writes the value to the output stream.
+func (s *Stream) Write(v any) (err error) {
+	w := s.cmd.OutOrStdout()
+
+	switch format := viper.GetString("output"); format {
+	case OutputYAML:
+		if s.written != 0 {
+			fmt.Fprintln(w, "---")
+		}
+
+		err = outputYAML(w, v)
+	case OutputTable, OutputCSV:
+		err = s.writeRows(w, format, v)
+	default:
+		err = Output(s.cmd, v)
+	}
+
+	if err == nil {
+		s.written++
+	}
+
+	return
+}
+
+func (s *Stream) writeRows(w io.Writer, format string, v any) (err error) {
+	columns, rows, err := tableRows(v)
+	if err != nil {
+		return
+	}
+
+	if s.written == 0 {
+		header := columns
+
+		if format == OutputTable {
+			header = []string{}
+			for _, column := range columns {
+				header = append(header, strings.ToUpper(column))
+			}
+		}
+
+		rows = append([][]string{header}, rows...)
+	}
+
+	if format == OutputCSV {
+		cw := csv.NewWriter(w)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	for _, row := range rows {
+		for n, value := range row {
+			if n == len(s.widths) {
+				s.widths = append(s.widths, 0)
+			}
+
+			if len(value) > s.widths[n] {
+				s.widths[n] = len(value)
+			}
+
+			if n == len(row)-1 {
+				fmt.Fprintln(w, value)
+			} else {
+				fmt.Fprintf(w, "%-*s  ", s.widths[n], value)
+			}
+		}
+	}
+
+	return
+}
+
+var templateFuncs = template.FuncMap{
+	"amount": FormatAmount,
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// tableRows resolves the selected columns for v, and renders each row of v as strings.
+func tableRows(v any) (columns []string, rows [][]string, err error) {
+	return selectRows(TableFor(v), viper.GetStringSlice("columns"))
+}
+
+// selectRows renders each row of the table as strings, with the given columns (or the table's defaults if there are none).
+func selectRows(table *Table, names []string) (columns []string, rows [][]string, err error) {
+	if columns = names; len(columns) == 0 {
+		columns = table.Defaults
+	}
+
+	selected := []Column{}
+
+	for _, name := range columns {
+		found := false
+
+		for _, col := range table.Columns {
+			if col.Name == name {
+				selected = append(selected, col)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			valid := []string{}
+			for _, col := range table.Columns {
+				valid = append(valid, col.Name)
+			}
+
+			return nil, nil, fmt.Errorf("%w '%s'. valid columns [%s]", ErrOutputColumnInvalid, name, strings.Join(valid, ", "))
+		}
+	}
+
+	for _, row := range table.Rows {
+		values := []string{}
+
+		for _, col := range selected {
+			values = append(values, col.Value(row))
+		}
+
+		rows = append(rows, values)
+	}
+
+	return
+}
+
+// Tabler is implemented by the CLI's output types, to return their table layout.
+type Tabler interface {
+	Table() *Table
+}
+
+// TableFor returns the table layout for a Tabler, or for the known Monzo resource types (which are defined by the monzo
+// package, so cannot implement Tabler here).
+//
+// Unknown types are rendered as a single row, with a column per top-level JSON field.
+func TableFor(v any) *Table {
+	if tabler, ok := v.(Tabler); ok {
+		return tabler.Table()
+	}
+
+	switch v := v.(type) {
+	case *monzo.TransactionList:
+		return &Table{Columns: transactionColumns, Defaults: transactionDefaults, Rows: rowsOf(v.Transactions)}
+	case *monzo.TransactionSingle:
+		table := &Table{Columns: transactionColumns, Defaults: transactionDefaults}
+		if v != nil {
+			table.Rows = []any{v.Transaction}
+		}
+
+		return table
+	case *monzo.AccountsList:
+		return &Table{Columns: accountColumns, Defaults: accountDefaults, Rows: rowsOf(v.Accounts)}
+	case *monzo.Balance:
+		return &Table{Columns: balanceColumns, Defaults: balanceDefaults, Rows: []any{*v}}
+	case *monzo.WebhookPayload:
+		inner := TableFor(&monzo.TransactionSingle{Transaction: v.Data})
+		table := &Table{Columns: []Column{{"type", func(r any) string { return r.(monzo.WebhookPayload).Type }}}, Defaults: append([]string{"type"}, inner.Defaults...), Rows: []any{*v}}
+
+		for _, col := range inner.Columns {
+			col := col
+
+			table.Columns = append(table.Columns, Column{col.Name, func(r any) string { return col.Value(r.(monzo.WebhookPayload).Data) }})
+		}
+
+		return table
+	}
+
+	return genericTable(v)
+}
+
+func rowsOf[T any](items []T) (rows []any) {
+	for _, item := range items {
+		rows = append(rows, item)
+	}
+
+	return
+}
+
+var (
+	transactionDefaults = []string{"date", "description", "amount", "category"}
+
+	transactionColumns = []Column{
+		{"id", func(r any) string { return r.(monzo.Transaction).ID }},
+		{"date", func(r any) string { return formatDate(r.(monzo.Transaction).CreatedTime()) }},
+		{"created", func(r any) string { return r.(monzo.Transaction).Created }},
+		{"settled", func(r any) string { return r.(monzo.Transaction).Settled }},
+		{"account", func(r any) string { return r.(monzo.Transaction).AccountID }},
+		{"description", func(r any) string { return r.(monzo.Transaction).Description }},
+		{"merchant", func(r any) string { return r.(monzo.Transaction).Merchant.Name }},
+		{"amount", func(r any) string { tx := r.(monzo.Transaction); return FormatAmount(tx.Amount, tx.Currency) }},
+		{"currency", func(r any) string { return r.(monzo.Transaction).Currency }},
+		{"local_amount", func(r any) string {
+			tx := r.(monzo.Transaction)
+			return FormatAmount(tx.LocalAmount, tx.LocalCurrency)
+		}},
+		{"local_currency", func(r any) string { return r.(monzo.Transaction).LocalCurrency }},
+		{"category", func(r any) string { return r.(monzo.Transaction).Category }},
+		{"notes", func(r any) string { return r.(monzo.Transaction).Notes }},
+		{"pending", func(r any) string { return strconv.FormatBool(r.(monzo.Transaction).AmountIsPending) }},
+	}
+
+	accountDefaults = []string{"id", "description", "type", "created"}
+
+	accountColumns = []Column{
+		{"id", func(r any) string { return r.(monzo.Account).ID }},
+		{"description", func(r any) string { return r.(monzo.Account).Description }},
+		{"type", func(r any) string { return string(r.(monzo.Account).Type) }},
+		{"created", func(r any) string { return r.(monzo.Account).Created }},
+		{"closed", func(r any) string { return strconv.FormatBool(r.(monzo.Account).Closed) }},
+		{"sort_code", func(r any) string { return r.(monzo.Account).PaymentDetails.LocaleUK.SortCode }},
+		{"account_number", func(r any) string { return r.(monzo.Account).PaymentDetails.LocaleUK.AccountNumber }},
+		{"owners", func(r any) string {
+			names := []string{}
+			for _, owner := range r.(monzo.Account).Owners {
+				names = append(names, owner.PreferredName)
+			}
+
+			return strings.Join(names, ", ")
+		}},
+	}
+
+	balanceDefaults = []string{"balance", "total_balance", "spend_today"}
+
+	balanceColumns = []Column{
+		{"balance", func(r any) string { b := r.(monzo.Balance); return FormatAmount(b.Balance, b.Currency) }},
+		{"total_balance", func(r any) string { b := r.(monzo.Balance); return FormatAmount(b.TotalBalance, b.Currency) }},
+		{"balance_including_flexible_savings", func(r any) string {
+			b := r.(monzo.Balance)
+			return FormatAmount(b.BalanceIncludingFlexibleSavings, b.Currency)
+		}},
+		{"spend_today", func(r any) string { b := r.(monzo.Balance); return FormatAmount(b.SpendToday, b.Currency) }},
+		{"currency", func(r any) string { return r.(monzo.Balance).Currency }},
+	}
+)
+
+// genericTable renders any JSON object as a single row, with columns sorted by field name.
+func genericTable(v any) *Table {
+	fields := map[string]any{}
+
+	data, _ := json.Marshal(v)
+	json.Unmarshal(data, &fields)
+
+	table := &Table{Rows: []any{fields}}
+
+	for name := range fields {
+		name := name
+
+		table.Columns = append(table.Columns, Column{name, func(r any) string {
+			switch val := r.(map[string]any)[name].(type) {
+			case string:
+				return val
+			case nil:
+				return ""
+			default:
+				data, _ := json.Marshal(val)
+				return string(data)
+			}
+		}})
+	}
+
+	sort.Slice(table.Columns, func(i, j int) bool {
+		return table.Columns[i].Name < table.Columns[j].Name
+	})
+
+	for _, col := range table.Columns {
+		table.Defaults = append(table.Defaults, col.Name)
+	}
+
+	return table
+}
+
+// FormatAmount converts an amount in minor units (e.g. pennies) into a decimal string for the currency (e.g. -5.10).
+func FormatAmount(amount int64, currency string) string {
+	exp := monzo.CurrencyExponent(currency)
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+
+	div := int64(1)
+	for i := 0; i < exp; i++ {
+		div *= 10
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/div, exp, amount%div)
+}
+
+// ParseAmount converts a decimal string for the currency (e.g. -5.10) into an amount in minor units (e.g. -510).
+func ParseAmount(value, currency string) (int64, error) {
+	exp := monzo.CurrencyExponent(currency)
+
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(value), ".")
+
+	if len(fraction) > exp || strings.HasPrefix(fraction, "-") || strings.HasPrefix(fraction, "+") {
+		return 0, fmt.Errorf("%w '%s'", ErrAmountInvalid, value)
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exp-len(fraction)), 10, 64)
+	if err != nil || whole == "" || whole == "-" || whole == "+" {
+		return 0, fmt.Errorf("%w '%s'", ErrAmountInvalid, value)
+	}
+
+	return amount, nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Local().Format("2006-01-02")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        any
+		columns  []string
+		expected [][]string
+	}{
+		{
+			name:     "tabler",
+			v:        &ProfilesList{Profiles: []ProfileInfo{{Name: "default", Current: true}, {Name: "work", AccountID: "acc_1"}}},
+			expected: [][]string{{"default", "true", "false", ""}, {"work", "false", "false", "acc_1"}},
+		},
+		{
+			name:     "monzo resource",
+			v:        &monzo.Balance{Balance: 510, TotalBalance: -1234, Currency: "GBP"},
+			expected: [][]string{{"5.10", "-12.34", "0.00"}},
+		},
+		{
+			name:     "tabler wrapping a monzo resource",
+			v:        &TransactionWatchEvent{TransactionEvent: monzo.TransactionEvent{Type: monzo.TransactionUpdated, Transaction: monzo.Transaction{ID: "tx_1"}, Changes: []string{"notes"}}},
+			columns:  []string{"event", "changes", "id"},
+			expected: [][]string{{"updated", "notes", "tx_1"}},
+		},
+		{
+			name:     "generic",
+			v:        map[string]any{"b": 1, "a": "x"},
+			expected: [][]string{{"x", "1"}},
+		},
+	}
+
+	for _, test := range tests {
+		_, rows, err := selectRows(TableFor(test.v), test.columns)
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, rows, test.name)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// dateFormat is the format of dates accepted by --from and --to.
+	dateFormat = "2006-01-02"
+
+	PeriodDay    = "day"
+	PeriodWeek   = "week"
+	PeriodMonth  = "month"
+	PeriodCustom = "custom"
+)
+
+var (
+	PeriodUnits = []string{PeriodDay, PeriodWeek, PeriodMonth, PeriodCustom}
+
+	ErrDateInvalid = errors.New("date invalid, must be YYYY-MM-DD or an RFC3339 date/time")
+
+	ErrPeriodInvalid = errors.New("--from must be before --to")
+
+	ErrPeriodUnitInvalid = fmt.Errorf("period invalid. valid periods [%s]", strings.Join(PeriodUnits, ", "))
+
+	ErrPeriodToUnsupported = fmt.Errorf("--to can only be used with --period %s", PeriodCustom)
+)
+
+// Period is a span of time, [From, To).
+type Period struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// unit is the calendar unit the period spans, or custom.
+	unit string
+}
+
+// UnitPeriod returns the calendar day, week (starting on Monday) or month containing the time, in the local time zone.
+func UnitPeriod(unit string, t time.Time) Period {
+	t = t.In(time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+
+	switch unit {
+	case PeriodDay:
+		return Period{From: day, To: day.AddDate(0, 0, 1), unit: unit}
+	case PeriodWeek:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return Period{From: start, To: start.AddDate(0, 0, 7), unit: unit}
+	}
+
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	return Period{From: start, To: start.AddDate(0, 1, 0), unit: PeriodMonth}
+}
+
+// Previous returns the period immediately before this one: the previous calendar day, week or month, or a custom
+// period of the same length.
+func (p Period) Previous() Period {
+	switch p.unit {
+	case PeriodDay:
+		return Period{From: p.From.AddDate(0, 0, -1), To: p.From, unit: p.unit}
+	case PeriodWeek:
+		return Period{From: p.From.AddDate(0, 0, -7), To: p.From, unit: p.unit}
+	case PeriodMonth:
+		return Period{From: p.From.AddDate(0, -1, 0), To: p.From, unit: p.unit}
+	}
+
+	return Period{From: p.From.Add(-p.To.Sub(p.From)), To: p.From, unit: p.unit}
+}
+
+// Contains reports whether the time is in the period.
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.From) && t.Before(p.To)
+}
+
+// parseDate parses a date (in the local time zone) or an RFC3339 date/time. If end is set, a date is taken to mean the
+// end of that day.
+func parseDate(value string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(dateFormat, value, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return t, fmt.Errorf("%w '%s'", ErrDateInvalid, value)
+	}
+
+	return t, nil
+}
+
+// SelectedPeriod returns the period selected with --from and --to, which defaults to the current month so far.
+func SelectedPeriod() (p Period, err error) {
+	now := time.Now()
+
+	p = Period{From: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local), To: now, unit: PeriodCustom}
+
+	if value := viper.GetString("from"); value != "" {
+		if p.From, err = parseDate(value, false); err != nil {
+			return
+		}
+	}
+
+	if value := viper.GetString("to"); value != "" {
+		if p.To, err = parseDate(value, true); err != nil {
+			return
+		}
+	}
+
+	if !p.From.Before(p.To) {
+		err = ErrPeriodInvalid
+	}
+
+	return
+}
+
+// SelectedUnitPeriod returns the period selected with --period: the day, week or month containing --from (default: now),
+// or the custom period selected with --from and --to.
+func SelectedUnitPeriod() (p Period, err error) {
+	unit := viper.GetString("period")
+
+	switch unit {
+	case PeriodCustom:
+		return SelectedPeriod()
+	case PeriodDay, PeriodWeek, PeriodMonth:
+	default:
+		return p, fmt.Errorf("%w '%s'", ErrPeriodUnitInvalid, unit)
+	}
+
+	if viper.GetString("to") != "" {
+		return p, ErrPeriodToUnsupported
+	}
+
+	at := time.Now()
+
+	if value := viper.GetString("from"); value != "" {
+		if at, err = parseDate(value, false); err != nil {
+			return
+		}
+	}
+
+	return UnitPeriod(unit, at), nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultProfile is the profile used when no other profile is selected.
+	DefaultProfile = "default"
+
+	// ProfilesDir is the directory under home-dir that holds a directory per profile.
+	ProfilesDir = "profiles"
+
+	// CurrentProfileFile is the file under home-dir that records the profile selected with profiles use.
+	CurrentProfileFile = "profile"
+
+	// legacySettingsFile is the file in each profile's directory that held its settings before the config file did.
+	legacySettingsFile = "settings.json"
+
+	// annotationNoAuth marks commands (and their subcommands) that can run without a token cache.
+	annotationNoAuth = "monzo_no_auth"
+)
+
+var (
+	profiles = &cobra.Command{
+		Use:         "profiles",
+		Short:       "Manage named profiles, each with their own token, caches and settings",
+		GroupID:     "auth",
+		Annotations: map[string]string{annotationNoAuth: "true", annotationOffline: "true"},
+	}
+
+	profilesList = &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		RunE:  profilesListRunE,
+		Args:  cobra.NoArgs,
+	}
+
+	profilesUse = &cobra.Command{
+		Use:   "use profile-name",
+		Short: "Select the profile used when --profile is not set",
+		RunE:  profilesUseRunE,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	profilesDelete = &cobra.Command{
+		Use:   "delete profile-name",
+		Short: "Revoke the profile's token and delete all of its cached data",
+		RunE:  profilesDeleteRunE,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+	ErrProfileNameInvalid = errors.New("profile name invalid. names must be alphanumeric, and may contain '_', '.' and '-'")
+
+	ErrProfileNotFound = errors.New("profile not found")
+)
+
+func init() {
+	root.PersistentFlags().AddFlagSet(FlagSets["profile"])
+	root.RegisterFlagCompletionFunc("profile", completeProfiles)
+
+	profilesUse.Flags().String("account-id", "", "Set the default account ID for the profile")
+	profilesUse.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	profilesUse.ValidArgsFunction = completeFirstArg(completeProfiles)
+	profilesDelete.ValidArgsFunction = completeFirstArg(completeProfiles)
+
+	profiles.AddCommand(profilesList)
+	profiles.AddCommand(profilesUse)
+	profiles.AddCommand(profilesDelete)
+
+	root.AddCommand(profiles)
+}
+
+// ProfileInfo describes a profile for the profiles list command.
+type ProfileInfo struct {
+	Name          string `json:"name"`
+	Current       bool   `json:"current"`
+	Authenticated bool   `json:"authenticated"`
+	AccountID     string `json:"account_id,omitempty"`
+}
+
+var (
+	profileDefaults = []string{"name", "current", "authenticated", "account"}
+
+	profileColumns = []Column{
+		{"name", func(r any) string { return r.(ProfileInfo).Name }},
+		{"current", func(r any) string { return strconv.FormatBool(r.(ProfileInfo).Current) }},
+		{"authenticated", func(r any) string { return strconv.FormatBool(r.(ProfileInfo).Authenticated) }},
+		{"account", func(r any) string { return r.(ProfileInfo).AccountID }},
+	}
+)
+
+// ProfilesList is the output of the profiles list command.
+type ProfilesList struct {
+	Profiles []ProfileInfo `json:"profiles"`
+}
+
+// Table returns the table layout of the list, with a row per profile.
+func (l *ProfilesList) Table() *Table {
+	return &Table{Columns: profileColumns, Defaults: profileDefaults, Rows: rowsOf(l.Profiles)}
+}
+
+// CurrentProfile returns the selected profile: --profile (or MONZO_PROFILE), then the profile set in the config file
+// (with profiles use), then the profile selected before the config file existed, then the default profile.
+func CurrentProfile() string {
+	if profile := viper.GetString("profile"); profile != "" {
+		return profile
+	}
+
+	data, err := os.ReadFile(path.Join(viper.GetString("home-dir"), CurrentProfileFile))
+	if err == nil && profileNameRegexp.Match(data) {
+		return string(data)
+	}
+
+	return DefaultProfile
+}
+
+// ProfileDir returns the directory holding the cache files for the profile.
+func ProfileDir(profile string) string {
+	return path.Join(viper.GetString("home-dir"), ProfilesDir, profile)
+}
+
+// InitProfile validates the current profile, and creates its directory.
+//
+// Cache files from before profiles existed are moved into the default profile.
+func InitProfile() (err error) {
+	profile := CurrentProfile()
+	if !profileNameRegexp.MatchString(profile) {
+		return ErrProfileNameInvalid
+	}
+
+	if err = migrateLegacyCache(); err != nil {
+		return
+	}
+
+	return EnsureCacheDir(ProfileDir(profile))
+}
+
+// migrateLegacyCache moves cache files stored directly in home-dir into the default profile.
+func migrateLegacyCache() (err error) {
+	defaultDir := ProfileDir(DefaultProfile)
+
+	for _, fileName := range []string{CacheFileToken, CacheFileTransactions, CacheFileSync} {
+		legacyPath := path.Join(viper.GetString("home-dir"), fmt.Sprintf("%s.json", fileName))
+
+		if _, err := os.Stat(legacyPath); err != nil {
+			continue
+		}
+
+		if err = EnsureCacheDir(defaultDir); err != nil {
+			return
+		}
+
+		if err = os.Rename(legacyPath, path.Join(defaultDir, fmt.Sprintf("%s.json", fileName))); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// migrateProfileSettings moves the settings in each profile's settings file into the profile's section of the config
+// file, and deletes the settings files. Values already set in the profile's section are kept.
+func migrateProfileSettings(config *Config) (err error) {
+	entries, err := os.ReadDir(path.Join(viper.GetString("home-dir"), ProfilesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return
+	}
+
+	migrated := []string{}
+
+	for _, entry := range entries {
+		settingsPath := path.Join(ProfileDir(entry.Name()), legacySettingsFile)
+
+		data, err := os.ReadFile(settingsPath)
+		if !entry.IsDir() || err != nil {
+			continue
+		}
+
+		settings := map[string]string{}
+		if err = json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("failed to migrate %s to the config file - %w", settingsPath, err)
+		}
+
+		keys := []string{}
+		for key := range settings {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if config.Get(configKeyProfiles, entry.Name(), key) != nil {
+				continue
+			}
+
+			if err = config.Set(settings[key], configKeyProfiles, entry.Name(), key); err != nil {
+				return err
+			}
+		}
+
+		migrated = append(migrated, settingsPath)
+	}
+
+	if len(migrated) == 0 {
+		return
+	}
+
+	if err = config.Save(); err != nil {
+		return
+	}
+
+	for _, settingsPath := range migrated {
+		if err = os.Remove(settingsPath); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// requiresAuth reports whether the command needs a token cache, based on the annotations of the command and its parents.
+func requiresAuth(cmd *cobra.Command) bool {
+	if cmd == cmd.Root() {
+		return false
+	}
+
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[annotationNoAuth] == "true" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func profilesListRunE(cmd *cobra.Command, args []string) (err error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	current := CurrentProfile()
+	list := &ProfilesList{Profiles: []ProfileInfo{}}
+
+	entries, err := os.ReadDir(path.Join(viper.GetString("home-dir"), ProfilesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		info := ProfileInfo{Name: entry.Name(), Current: entry.Name() == current}
+
+		_, err := os.Stat(path.Join(ProfileDir(entry.Name()), fmt.Sprintf("%s.json", CacheFileToken)))
+		info.Authenticated = err == nil
+
+		if node := config.Get(configKeyProfiles, entry.Name(), "account-id"); node != nil {
+			info.AccountID = node.Value
+		}
+
+		list.Profiles = append(list.Profiles, info)
+	}
+
+	sort.Slice(list.Profiles, func(i, j int) bool {
+		return list.Profiles[i].Name < list.Profiles[j].Name
+	})
+
+	return Output(cmd, list)
+}
+
+func profilesUseRunE(cmd *cobra.Command, args []string) (err error) {
+	profile := args[0]
+	if !profileNameRegexp.MatchString(profile) {
+		return ErrProfileNameInvalid
+	}
+
+	if err = EnsureCacheDir(ProfileDir(profile)); err != nil {
+		return
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if accountID, _ := cmd.Flags().GetString("account-id"); accountID != "" {
+		if err = config.Set(accountID, configKeyProfiles, profile, "account-id"); err != nil {
+			return
+		}
+	}
+
+	if err = config.Set(profile, "profile"); err != nil {
+		return
+	}
+
+	if err = config.Save(); err != nil {
+		return
+	}
+
+	os.Remove(path.Join(viper.GetString("home-dir"), CurrentProfileFile))
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Using profile %s\n", profile)
+	return
+}
+
+func profilesDeleteRunE(cmd *cobra.Command, args []string) (err error) {
+	profile := args[0]
+	if !profileNameRegexp.MatchString(profile) {
+		return ErrProfileNameInvalid
+	}
+
+	if _, err = os.Stat(ProfileDir(profile)); err != nil {
+		return fmt.Errorf("%w '%s'", ErrProfileNotFound, profile)
+	}
+
+	if token, err := LoadToken(profile); err == nil && !Offline() {
+		BuildClient(cmd.Context(), token).LogOut()
+	}
+
+	if err = os.RemoveAll(ProfileDir(profile)); err != nil {
+		return
+	}
+
+	currentFile := path.Join(viper.GetString("home-dir"), CurrentProfileFile)
+	if data, err := os.ReadFile(currentFile); err == nil && string(data) == profile {
+		os.Remove(currentFile)
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+
+	deleted := config.Unset(configKeyProfiles, profile)
+
+	if node := config.Get("profile"); node != nil && node.Value == profile {
+		deleted = config.Unset("profile")
+	}
+
+	if deleted {
+		if err = config.Save(); err != nil {
+			return
+		}
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Deleted profile %s\n", profile)
+	return
+}
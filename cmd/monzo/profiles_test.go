@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateProfileSettings(t *testing.T) {
+	home := t.TempDir()
+
+	viper.Set("home-dir", home)
+	defer viper.Set("home-dir", nil)
+
+	assert.NoError(t, os.WriteFile(path.Join(home, ConfigFile), []byte("profiles:\n  work:\n    output: json\n"), CacheFilePerm))
+
+	for profile, settings := range map[string]string{
+		"work":    `{"account-id":"acc_work","output":"table"}`,
+		"default": `{"encrypt-cache":"true"}`,
+	} {
+		assert.NoError(t, EnsureCacheDir(ProfileDir(profile)))
+		assert.NoError(t, os.WriteFile(path.Join(ProfileDir(profile), legacySettingsFile), []byte(settings), CacheFilePerm))
+	}
+
+	config, err := LoadConfig()
+	assert.NoError(t, err)
+	assert.NoError(t, migrateProfileSettings(config))
+
+	// Values already in the config file are kept.
+	config, err = LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"account-id": "acc_work", "output": "json"}, config.Values(configKeyProfiles, "work"))
+	assert.Equal(t, map[string]any{"encrypt-cache": true}, config.Values(configKeyProfiles, "default"))
+
+	for _, profile := range []string{"work", "default"} {
+		assert.NoFileExists(t, path.Join(ProfileDir(profile), legacySettingsFile))
+	}
+
+	// Once migrated, the config file is not rewritten.
+	info, err := os.Stat(path.Join(home, ConfigFile))
+	assert.NoError(t, err)
+
+	assert.NoError(t, migrateProfileSettings(config))
+
+	after, err := os.Stat(path.Join(home, ConfigFile))
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+}
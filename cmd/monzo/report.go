@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	SpendingByCategory     = "category"
+	SpendingByMerchant     = "merchant"
+	SpendingByCounterparty = "counterparty"
+)
+
+var (
+	reportCmd = &cobra.Command{
+		Use:     "report",
+		Short:   "Summarise an account's transactions",
+		GroupID: "reports",
+	}
+
+	reportSpendingCmd = &cobra.Command{
+		Use:     "spending [--by category|merchant|counterparty] [--period day|week|month|custom]",
+		Short:   "Report spending by category, merchant or counterparty, compared with the previous period",
+		PreRunE: reportSpendingPreRunE,
+		RunE:    reportSpendingRunE,
+		Args:    cobra.NoArgs,
+
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	SpendingGroupings = []string{SpendingByCategory, SpendingByMerchant, SpendingByCounterparty}
+
+	ErrSpendingGroupingInvalid = fmt.Errorf("grouping invalid. valid groupings [%s]", strings.Join(SpendingGroupings, ", "))
+)
+
+func init() {
+	FlagSets["report"] = pflag.NewFlagSet("report", pflag.ContinueOnError)
+	FlagSets["report"].String("by", SpendingByCategory, fmt.Sprintf("What to group spending by [%s]", strings.Join(SpendingGroupings, ", ")))
+	FlagSets["report"].String("period", PeriodMonth, fmt.Sprintf("Period to report on [%s]. Day, week and month periods contain --from (default: now)", strings.Join(PeriodUnits, ", ")))
+	FlagSets["report"].Bool("include-excluded", false, "Include transactions that have been excluded from spending in the Monzo app")
+	viper.BindPFlags(FlagSets["report"])
+
+	reportSpendingCmd.Flags().AddFlagSet(FlagSets["report"])
+	reportSpendingCmd.Flags().AddFlagSet(FlagSets["period"])
+	reportSpendingCmd.Flags().AddFlagSet(FlagSets["account"])
+	reportSpendingCmd.Flags().AddFlagSet(FlagSets["cache"])
+
+	reportSpendingCmd.RegisterFlagCompletionFunc("by", cobra.FixedCompletions(SpendingGroupings, cobra.ShellCompDirectiveNoFileComp))
+	reportSpendingCmd.RegisterFlagCompletionFunc("period", cobra.FixedCompletions(PeriodUnits, cobra.ShellCompDirectiveNoFileComp))
+	reportSpendingCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	root.AddGroup(&cobra.Group{ID: "reports", Title: "Reports"})
+	root.AddCommand(reportCmd)
+
+	reportCmd.AddCommand(reportSpendingCmd)
+}
+
+// SpendingReport is an account's spending over a period, grouped by category, merchant or counterparty, alongside the
+// spending in the previous period. Amounts are in minor units, with money spent positive and refunds negative.
+type SpendingReport struct {
+	AccountID      string `json:"account_id"`
+	By             string `json:"by"`
+	Currency       string `json:"currency"`
+	Period         Period `json:"period"`
+	PreviousPeriod Period `json:"previous_period"`
+	Total          int64  `json:"total"`
+	PreviousTotal  int64  `json:"previous_total"`
+
+	// Transactions and PreviousTransactions count the transactions in each period. A transaction split between
+	// categories is counted once here, but in each of its categories' groups.
+	Transactions         int `json:"transactions"`
+	PreviousTransactions int `json:"previous_transactions"`
+
+	Groups []SpendingGroup `json:"groups"`
+
+	index map[string]int
+}
+
+// Table returns the table layout of the report, with a row per group followed by a total row.
+func (r *SpendingReport) Table() *Table {
+	total := SpendingGroup{
+		Name:                 "Total",
+		Currency:             r.Currency,
+		Spent:                r.Total,
+		Transactions:         r.Transactions,
+		PreviousSpent:        r.PreviousTotal,
+		PreviousTransactions: r.PreviousTransactions,
+	}
+
+	return &Table{Columns: spendingColumns, Defaults: spendingDefaults, Rows: append(rowsOf(r.Groups), total)}
+}
+
+// SpendingGroup is the spending on a single category, merchant or counterparty.
+type SpendingGroup struct {
+	Key                  string `json:"key"`
+	Name                 string `json:"name"`
+	Currency             string `json:"currency"`
+	Spent                int64  `json:"spent"`
+	Transactions         int    `json:"transactions"`
+	PreviousSpent        int64  `json:"previous_spent"`
+	PreviousTransactions int    `json:"previous_transactions"`
+}
+
+var (
+	spendingDefaults = []string{"name", "spent", "transactions", "previous_spent", "change", "change_percent"}
+
+	spendingColumns = []Column{
+		{"key", func(r any) string { return r.(SpendingGroup).Key }},
+		{"name", func(r any) string { return r.(SpendingGroup).Name }},
+		{"spent", func(r any) string { g := r.(SpendingGroup); return FormatAmount(g.Spent, g.Currency) }},
+		{"transactions", func(r any) string { return strconv.Itoa(r.(SpendingGroup).Transactions) }},
+		{"previous_spent", func(r any) string { g := r.(SpendingGroup); return FormatAmount(g.PreviousSpent, g.Currency) }},
+		{"previous_transactions", func(r any) string { return strconv.Itoa(r.(SpendingGroup).PreviousTransactions) }},
+		{"change", func(r any) string { g := r.(SpendingGroup); return FormatAmount(g.Spent-g.PreviousSpent, g.Currency) }},
+		{"change_percent", func(r any) string {
+			g := r.(SpendingGroup)
+			if g.PreviousSpent == 0 {
+				return ""
+			}
+
+			return fmt.Sprintf("%+.1f%%", float64(g.Spent-g.PreviousSpent)*100/float64(g.PreviousSpent))
+		}},
+		{"currency", func(r any) string { return r.(SpendingGroup).Currency }},
+	}
+)
+
+// spendingShare is the part of a transaction's amount attributed to a group.
+type spendingShare struct {
+	key    string
+	name   string
+	amount int64
+}
+
+// CountsAsSpending reports whether the transaction is spending: it moved money, is not a top-up or pot transfer, and
+// (unless includeExcluded is set) has not been excluded from spending in the Monzo app.
+func CountsAsSpending(tx monzo.Transaction, includeExcluded bool) bool {
+	switch {
+	case tx.Declined(), tx.IsLoad, tx.PotID() != "":
+		return false
+	case tx.IncludeInSpending:
+		return true
+	}
+
+	// Transactions that can be excluded from the breakdown but are not included have been excluded by the user.
+	return includeExcluded && tx.CanBeExcludedFromBreakdown
+}
+
+// NewSpendingReport returns an empty report of spending over the period, grouped by category, merchant or counterparty.
+func NewSpendingReport(accountID, by string, period Period) *SpendingReport {
+	return &SpendingReport{
+		AccountID:      accountID,
+		By:             by,
+		Period:         period,
+		PreviousPeriod: period.Previous(),
+		Groups:         []SpendingGroup{},
+		index:          map[string]int{},
+	}
+}
+
+// Add adds the transaction to the report, if it is spending in the period or the previous period.
+func (r *SpendingReport) Add(tx monzo.Transaction) {
+	created := tx.CreatedTime()
+
+	current := r.Period.Contains(created)
+	if !current && !r.PreviousPeriod.Contains(created) {
+		return
+	}
+
+	if r.Currency == "" {
+		r.Currency = tx.Currency
+	}
+
+	if current {
+		r.Transactions++
+	} else {
+		r.PreviousTransactions++
+	}
+
+	for _, share := range spendingShares(r.By, tx) {
+		n, ok := r.index[share.key]
+		if !ok {
+			n = len(r.Groups)
+			r.index[share.key] = n
+			r.Groups = append(r.Groups, SpendingGroup{Key: share.key, Name: share.name, Currency: tx.Currency})
+		}
+
+		group := &r.Groups[n]
+
+		if current {
+			group.Spent -= share.amount
+			group.Transactions++
+			r.Total -= share.amount
+		} else {
+			group.PreviousSpent -= share.amount
+			group.PreviousTransactions++
+			r.PreviousTotal -= share.amount
+		}
+	}
+}
+
+// Sort orders the groups by the amount spent in the period, largest first.
+func (r *SpendingReport) Sort() {
+	sort.SliceStable(r.Groups, func(i, j int) bool {
+		if r.Groups[i].Spent != r.Groups[j].Spent {
+			return r.Groups[i].Spent > r.Groups[j].Spent
+		}
+
+		return r.Groups[i].PreviousSpent > r.Groups[j].PreviousSpent
+	})
+
+	for n, group := range r.Groups {
+		r.index[group.Key] = n
+	}
+}
+
+// spendingShares splits the transaction's amount between the groups it belongs to. Only category grouping splits
+// transactions, using the transaction's split categories when it has them.
+func spendingShares(by string, tx monzo.Transaction) []spendingShare {
+	switch by {
+	case SpendingByCategory:
+		if len(tx.Categories) == 0 {
+			return []spendingShare{categoryShare(tx.Category, tx.Amount)}
+		}
+
+		shares := []spendingShare{}
+		for category, amount := range tx.Categories {
+			shares = append(shares, categoryShare(category, amount))
+		}
+
+		sort.Slice(shares, func(i, j int) bool { return shares[i].key < shares[j].key })
+
+		return shares
+	case SpendingByMerchant:
+		if key := firstNonEmpty(tx.Merchant.GroupID, tx.Merchant.ID); key != "" {
+			return []spendingShare{{key: key, name: firstNonEmpty(tx.Merchant.Name, key), amount: tx.Amount}}
+		}
+	}
+
+	counterparty := tx.CounterpartyDetails()
+
+	name := firstNonEmpty(counterparty.Name, counterparty.PreferredName)
+	if counterparty.AccountNumber != "" {
+		return []spendingShare{{key: counterparty.SortCode + counterparty.AccountNumber, name: firstNonEmpty(name, counterparty.AccountNumber), amount: tx.Amount}}
+	}
+
+	if key := firstNonEmpty(counterparty.AccountID, counterparty.UserID); key != "" {
+		return []spendingShare{{key: key, name: firstNonEmpty(name, key), amount: tx.Amount}}
+	}
+
+	if by == SpendingByCounterparty && tx.Merchant.Name != "" {
+		return []spendingShare{{key: firstNonEmpty(tx.Merchant.GroupID, tx.Merchant.ID), name: tx.Merchant.Name, amount: tx.Amount}}
+	}
+
+	description := singleLine(tx.Description)
+	return []spendingShare{{key: "description:" + strings.ToLower(description), name: description, amount: tx.Amount}}
+}
+
+func categoryShare(category string, amount int64) spendingShare {
+	if category == "" {
+		category = "general"
+	}
+
+	return spendingShare{key: category, name: categoryName(category), amount: amount}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func reportSpendingPreRunE(cmd *cobra.Command, args []string) (err error) {
+	switch viper.GetString("by") {
+	case SpendingByCategory, SpendingByMerchant, SpendingByCounterparty:
+	default:
+		return fmt.Errorf("%w '%s'", ErrSpendingGroupingInvalid, viper.GetString("by"))
+	}
+
+	if _, err = CacheMode(); err != nil {
+		return
+	}
+
+	_, err = SelectedUnitPeriod()
+	return
+}
+
+func reportSpendingRunE(cmd *cobra.Command, args []string) (err error) {
+	accountID, err := ResolveAccount(cmd)
+	if err != nil {
+		return
+	}
+
+	period, err := SelectedUnitPeriod()
+	if err != nil {
+		return
+	}
+
+	report := NewSpendingReport(accountID, viper.GetString("by"), period)
+
+	txs, err := transactionsSince(cmd, accountID, report.PreviousPeriod.From)
+	if err != nil {
+		return
+	}
+
+	includeExcluded := viper.GetBool("include-excluded")
+
+	for _, tx := range txs {
+		if CountsAsSpending(tx, includeExcluded) {
+			report.Add(tx)
+		}
+	}
+
+	report.Sort()
+
+	return Output(cmd, report)
+}
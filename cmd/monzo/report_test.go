@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountsAsSpending(t *testing.T) {
+	tests := []struct {
+		name            string
+		tx              monzo.Transaction
+		includeExcluded bool
+		expected        bool
+	}{
+		{"spending", monzo.Transaction{Amount: -1250, IncludeInSpending: true}, false, true},
+		{"refund", monzo.Transaction{Amount: 500, IncludeInSpending: true}, false, true},
+		{"declined", monzo.Transaction{Amount: -1250, IncludeInSpending: true, DeclineReason: "INSUFFICIENT_FUNDS"}, false, false},
+		{"top-up", monzo.Transaction{Amount: 10000, IsLoad: true, IncludeInSpending: true}, false, false},
+		{"pot transfer", monzo.Transaction{Amount: -5000, Metadata: map[string]string{"pot_id": "pot_1"}, IncludeInSpending: true}, false, false},
+		{"pot transfer by description", monzo.Transaction{Amount: 5000, Description: "pot_1", IncludeInSpending: true}, true, false},
+		{"excluded", monzo.Transaction{Amount: -1250, CanBeExcludedFromBreakdown: true}, false, false},
+		{"excluded included", monzo.Transaction{Amount: -1250, CanBeExcludedFromBreakdown: true}, true, true},
+		{"not spending", monzo.Transaction{Amount: 200000}, true, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, CountsAsSpending(test.tx, test.includeExcluded), test.name)
+	}
+}
+
+func TestSpendingShares(t *testing.T) {
+	split := monzo.Transaction{Amount: -4000, Category: "groceries", Categories: map[string]int64{"groceries": -3000, "eating_out": -1000}}
+	split.Merchant = monzo.Merchant{ID: "merch_1", GroupID: "grp_1", Name: "Tesco"}
+
+	merchant := monzo.Transaction{Amount: -250, Category: "eating_out", Description: "PRET A MANGER"}
+	merchant.Merchant = monzo.Merchant{ID: "merch_2", Name: "Pret"}
+
+	transfer := monzo.Transaction{Amount: -2000, Category: "transfers", Description: "Rent", Counterparty: map[string]interface{}{
+		"account_number": "12345678", "sort_code": "040004", "name": "Jane Doe",
+	}}
+
+	monzoUser := monzo.Transaction{Amount: -1500, Description: "Dinner", Counterparty: map[string]interface{}{
+		"user_id": "user_1", "preferred_name": "Joe",
+	}}
+
+	unknown := monzo.Transaction{Amount: -100, Description: "CARD  PAYMENT\nREF 1"}
+
+	tests := []struct {
+		name     string
+		by       string
+		tx       monzo.Transaction
+		expected []spendingShare
+	}{
+		{"category", SpendingByCategory, merchant, []spendingShare{{"eating_out", "Eating Out", -250}}},
+		{"uncategorised", SpendingByCategory, unknown, []spendingShare{{"general", "General", -100}}},
+		{"split categories", SpendingByCategory, split, []spendingShare{{"eating_out", "Eating Out", -1000}, {"groceries", "Groceries", -3000}}},
+		{"merchant group", SpendingByMerchant, split, []spendingShare{{"grp_1", "Tesco", -4000}}},
+		{"merchant", SpendingByMerchant, merchant, []spendingShare{{"merch_2", "Pret", -250}}},
+		{"merchant falls back to counterparty", SpendingByMerchant, transfer, []spendingShare{{"04000412345678", "Jane Doe", -2000}}},
+		{"merchant falls back to description", SpendingByMerchant, unknown, []spendingShare{{"description:card payment ref 1", "CARD PAYMENT REF 1", -100}}},
+		{"counterparty account", SpendingByCounterparty, transfer, []spendingShare{{"04000412345678", "Jane Doe", -2000}}},
+		{"counterparty user", SpendingByCounterparty, monzoUser, []spendingShare{{"user_1", "Joe", -1500}}},
+		{"counterparty falls back to merchant", SpendingByCounterparty, split, []spendingShare{{"grp_1", "Tesco", -4000}}},
+		{"counterparty falls back to description", SpendingByCounterparty, unknown, []spendingShare{{"description:card payment ref 1", "CARD PAYMENT REF 1", -100}}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, spendingShares(test.by, test.tx), test.name)
+	}
+}
+
+func TestSpendingReport(t *testing.T) {
+	report := NewSpendingReport("acc_1", SpendingByCategory, UnitPeriod(PeriodMonth, localTime(2023, 3, 16, 12)))
+
+	split := spending("tx_split", -4000, "groceries", localTime(2023, 3, 10, 12))
+	split.Categories = map[string]int64{"groceries": -3000, "eating_out": -1000}
+
+	for _, tx := range []monzo.Transaction{
+		spending("tx_1", -1200, "transport", localTime(2023, 3, 1, 0)),
+		split,
+		spending("tx_2", 500, "groceries", localTime(2023, 3, 20, 12)),
+		spending("tx_3", -8000, "transport", localTime(2023, 2, 28, 23)),
+		spending("tx_4", -2000, "eating_out", localTime(2023, 2, 1, 0)),
+		spending("tx_5", -9999, "groceries", localTime(2023, 1, 31, 23)),
+		spending("tx_6", -9999, "groceries", localTime(2023, 4, 1, 0)),
+	} {
+		tx.Currency = "GBP"
+		report.Add(tx)
+	}
+
+	report.Sort()
+
+	assert.Equal(t, localTime(2023, 2, 1, 0), report.PreviousPeriod.From)
+	assert.Equal(t, localTime(2023, 3, 1, 0), report.PreviousPeriod.To)
+	assert.Equal(t, "GBP", report.Currency)
+
+	// The split transaction is counted once in the totals, but in both of its categories.
+	assert.Equal(t, int64(4700), report.Total)
+	assert.Equal(t, 3, report.Transactions)
+	assert.Equal(t, int64(10000), report.PreviousTotal)
+	assert.Equal(t, 2, report.PreviousTransactions)
+
+	assert.Equal(t, []SpendingGroup{
+		{Key: "groceries", Name: "Groceries", Currency: "GBP", Spent: 2500, Transactions: 2},
+		{Key: "transport", Name: "Transport", Currency: "GBP", Spent: 1200, Transactions: 1, PreviousSpent: 8000, PreviousTransactions: 1},
+		{Key: "eating_out", Name: "Eating Out", Currency: "GBP", Spent: 1000, Transactions: 1, PreviousSpent: 2000, PreviousTransactions: 1},
+	}, report.Groups)
+
+	// Transactions added after sorting are added to the group in its new position.
+	report.Add(spending("tx_7", -3000, "eating_out", localTime(2023, 3, 31, 23)))
+
+	assert.Len(t, report.Groups, 3)
+	assert.Equal(t, int64(4000), report.Groups[2].Spent)
+}
+
+func TestSpendingReportSortTie(t *testing.T) {
+	report := NewSpendingReport("acc_1", SpendingByCategory, UnitPeriod(PeriodDay, localTime(2023, 3, 16, 12)))
+
+	report.Add(spending("tx_1", -1000, "groceries", localTime(2023, 3, 16, 9)))
+	report.Add(spending("tx_2", -1000, "transport", localTime(2023, 3, 16, 10)))
+	report.Add(spending("tx_3", -500, "transport", localTime(2023, 3, 15, 10)))
+
+	report.Sort()
+
+	// Groups that spent the same in the period are ordered by what they spent in the previous period.
+	assert.Equal(t, "transport", report.Groups[0].Key)
+	assert.Equal(t, "groceries", report.Groups[1].Key)
+}
+
+func TestUnitPeriod(t *testing.T) {
+	tests := []struct {
+		name                     string
+		unit                     string
+		at                       time.Time
+		from, to                 time.Time
+		previousFrom, previousTo time.Time
+	}{
+		{"day", PeriodDay, localTime(2023, 3, 16, 12), localTime(2023, 3, 16, 0), localTime(2023, 3, 17, 0), localTime(2023, 3, 15, 0), localTime(2023, 3, 16, 0)},
+		{"day at midnight", PeriodDay, localTime(2023, 3, 16, 0), localTime(2023, 3, 16, 0), localTime(2023, 3, 17, 0), localTime(2023, 3, 15, 0), localTime(2023, 3, 16, 0)},
+		{"week", PeriodWeek, localTime(2023, 3, 16, 12), localTime(2023, 3, 13, 0), localTime(2023, 3, 20, 0), localTime(2023, 3, 6, 0), localTime(2023, 3, 13, 0)},
+		{"week on Sunday", PeriodWeek, localTime(2023, 3, 19, 23), localTime(2023, 3, 13, 0), localTime(2023, 3, 20, 0), localTime(2023, 3, 6, 0), localTime(2023, 3, 13, 0)},
+		{"week on Monday", PeriodWeek, localTime(2023, 3, 13, 0), localTime(2023, 3, 13, 0), localTime(2023, 3, 20, 0), localTime(2023, 3, 6, 0), localTime(2023, 3, 13, 0)},
+		{"week across a year", PeriodWeek, localTime(2024, 1, 2, 12), localTime(2024, 1, 1, 0), localTime(2024, 1, 8, 0), localTime(2023, 12, 25, 0), localTime(2024, 1, 1, 0)},
+		{"month", PeriodMonth, localTime(2023, 3, 31, 23), localTime(2023, 3, 1, 0), localTime(2023, 4, 1, 0), localTime(2023, 2, 1, 0), localTime(2023, 3, 1, 0)},
+		{"month across a year", PeriodMonth, localTime(2024, 1, 15, 12), localTime(2024, 1, 1, 0), localTime(2024, 2, 1, 0), localTime(2023, 12, 1, 0), localTime(2024, 1, 1, 0)},
+	}
+
+	for _, test := range tests {
+		period := UnitPeriod(test.unit, test.at)
+
+		assert.Equal(t, test.from, period.From, test.name)
+		assert.Equal(t, test.to, period.To, test.name)
+		assert.True(t, period.Contains(test.at), test.name)
+		assert.False(t, period.Contains(test.to), test.name)
+
+		previous := period.Previous()
+
+		assert.Equal(t, test.previousFrom, previous.From, test.name)
+		assert.Equal(t, test.previousTo, previous.To, test.name)
+	}
+}
+
+func TestPeriodPreviousCustom(t *testing.T) {
+	period := Period{From: localTime(2023, 1, 10, 0), To: localTime(2023, 1, 15, 12)}
+
+	previous := period.Previous()
+
+	// A custom period is preceded by a period of the same length, rather than a calendar unit.
+	assert.Equal(t, localTime(2023, 1, 4, 12), previous.From)
+	assert.Equal(t, period.From, previous.To)
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+const (
+	// TransactionLogFile is the append-only log holding each profile's transaction store.
+	TransactionLogFile = "transactions.jsonl"
+
+	// TransactionLogVersion is the current schema version of the transaction store. Version 1 is the transactions.json
+	// cache file used before the transaction log.
+	TransactionLogVersion = 2
+
+	// transactionLogCompactMin is the minimum number of superseded records in the log before it is compacted.
+	transactionLogCompactMin = 1000
+)
+
+var (
+	ErrTransactionLogVersion = errors.New("transaction store was written by a newer version of monzo, please upgrade")
+
+	// storeMigrations upgrade a profile's transaction store to the next schema version, keyed by the version they
+	// upgrade from. They are run in order, while holding the profile lock.
+	storeMigrations = map[int]func(profile string) error{
+		1: migrateTransactionsJSON,
+	}
+)
+
+// Store is a local store of transactions, indexed by ID and by the time each account's transactions were created.
+type Store interface {
+	// Get returns the transaction with the ID, or nil if it is not stored.
+	Get(transactionID string) (*monzo.Transaction, error)
+
+	// Range returns the account's transactions matching the query, ordered by the time they were created.
+	Range(accountID string, query StoreQuery) ([]monzo.Transaction, error)
+
+	// Upsert adds or replaces the account's transactions, and returns the number that were newly created or changed.
+	Upsert(accountID string, transactions ...monzo.Transaction) (created, updated int, err error)
+
+	// Accounts returns the IDs of the accounts with stored transactions.
+	Accounts() ([]string, error)
+
+	// Flush persists the changes made by Upsert. It is called while holding the profile lock.
+	Flush() error
+}
+
+// StoreQuery selects a range of an account's transactions, by the time they were created.
+type StoreQuery struct {
+	// Since and Before bound the created time of the transactions (inclusive and exclusive respectively). Zero times
+	// are unbounded.
+	Since  time.Time
+	Before time.Time
+
+	// Limit is the maximum number of transactions to return, or 0 for no limit.
+	Limit int
+
+	// Reverse returns the newest transactions first, so Limit selects the newest transactions in the range.
+	Reverse bool
+}
+
+// storedTransaction is an indexed transaction, with its created time parsed for ordering.
+type storedTransaction struct {
+	accountID string
+	created   time.Time
+	tx        monzo.Transaction
+}
+
+func (a *storedTransaction) less(b *storedTransaction) bool {
+	if !a.created.Equal(b.created) {
+		return a.created.Before(b.created)
+	}
+
+	return a.tx.ID < b.tx.ID
+}
+
+// storeIndex indexes transactions by ID, and keeps each account's transactions ordered by created time (then ID).
+type storeIndex struct {
+	byID      map[string]*storedTransaction
+	byAccount map[string][]*storedTransaction
+}
+
+func newStoreIndex() storeIndex {
+	return storeIndex{
+		byID:      map[string]*storedTransaction{},
+		byAccount: map[string][]*storedTransaction{},
+	}
+}
+
+func (i *storeIndex) get(transactionID string) *monzo.Transaction {
+	stored, ok := i.byID[transactionID]
+	if !ok {
+		return nil
+	}
+
+	tx := stored.tx
+
+	return &tx
+}
+
+func (i *storeIndex) rangeOf(accountID string, query StoreQuery) []monzo.Transaction {
+	txns := i.byAccount[accountID]
+
+	start, end := 0, len(txns)
+
+	if !query.Since.IsZero() {
+		start = sort.Search(len(txns), func(n int) bool { return !txns[n].created.Before(query.Since) })
+	}
+
+	if !query.Before.IsZero() {
+		end = sort.Search(len(txns), func(n int) bool { return !txns[n].created.Before(query.Before) })
+	}
+
+	if end < start {
+		end = start
+	}
+
+	matched := txns[start:end]
+
+	count := len(matched)
+	if query.Limit > 0 && query.Limit < count {
+		count = query.Limit
+	}
+
+	result := make([]monzo.Transaction, 0, count)
+
+	for n := 0; n < count; n++ {
+		if query.Reverse {
+			result = append(result, matched[len(matched)-1-n].tx)
+		} else {
+			result = append(result, matched[n].tx)
+		}
+	}
+
+	return result
+}
+
+func (i *storeIndex) accounts() []string {
+	accountIDs := []string{}
+
+	for accountID := range i.byAccount {
+		accountIDs = append(accountIDs, accountID)
+	}
+
+	sort.Strings(accountIDs)
+
+	return accountIDs
+}
+
+// upsert adds or replaces the transaction, and reports whether it was newly created or changed.
+func (i *storeIndex) upsert(accountID string, transaction monzo.Transaction) (created, updated bool) {
+	stored := &storedTransaction{accountID: accountID, created: transaction.CreatedTime(), tx: transaction}
+
+	existing, ok := i.byID[transaction.ID]
+	if ok {
+		updated = !transactionsEqual(existing.tx, transaction)
+
+		if existing.accountID == accountID && existing.created.Equal(stored.created) {
+			existing.tx = transaction
+			return
+		}
+
+		i.remove(existing)
+	} else {
+		created = true
+	}
+
+	i.byID[transaction.ID] = stored
+
+	// Transactions are mostly added in created order, so this is usually an append.
+	txns := i.byAccount[accountID]
+	n := sort.Search(len(txns), func(n int) bool { return stored.less(txns[n]) })
+
+	txns = append(txns, nil)
+	copy(txns[n+1:], txns[n:])
+	txns[n] = stored
+
+	i.byAccount[accountID] = txns
+
+	return
+}
+
+func (i *storeIndex) remove(stored *storedTransaction) {
+	txns := i.byAccount[stored.accountID]
+
+	for n := sort.Search(len(txns), func(n int) bool { return !txns[n].less(stored) }); n < len(txns); n++ {
+		if txns[n] == stored {
+			i.byAccount[stored.accountID] = append(txns[:n], txns[n+1:]...)
+			break
+		}
+	}
+
+	delete(i.byID, stored.tx.ID)
+}
+
+// MemoryStore is a Store that only holds transactions in memory.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	index storeIndex
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{index: newStoreIndex()}
+}
+
+func (s *MemoryStore) Get(transactionID string) (*monzo.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.get(transactionID), nil
+}
+
+func (s *MemoryStore) Range(accountID string, query StoreQuery) ([]monzo.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.rangeOf(accountID, query), nil
+}
+
+func (s *MemoryStore) Upsert(accountID string, transactions ...monzo.Transaction) (created, updated int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tx := range transactions {
+		c, u := s.index.upsert(accountID, tx)
+
+		if c {
+			created++
+		}
+
+		if u {
+			updated++
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStore) Accounts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.accounts(), nil
+}
+
+func (s *MemoryStore) Flush() error {
+	return nil
+}
+
+// logHeader is the first line of the transaction log.
+type logHeader struct {
+	Version int `json:"monzo_transaction_log"`
+
+	// Generation changes whenever the log is rewritten, so processes that have already read part of the log know to
+	// read it again from the start.
+	Generation string `json:"generation"`
+}
+
+// logRecord is a line of the transaction log, which may be encrypted. Later records for a transaction replace earlier ones.
+type logRecord struct {
+	AccountID   string            `json:"account_id"`
+	Transaction monzo.Transaction `json:"transaction"`
+}
+
+// LogStore is a Store backed by an append-only log file in the profile directory.
+//
+// The log is read into an in-memory index when opened, and changed transactions are appended to it when flushed, along
+// with any records appended by other processes in the meantime. Once superseded records make up most of the log, it is
+// compacted.
+type LogStore struct {
+	profile string
+
+	mu      sync.RWMutex
+	index   storeIndex
+	pending []logRecord
+
+	generation string
+	offset     int64
+	records    int
+}
+
+// OpenLogStore migrates the profile's transaction store to the current schema version if required, and reads it.
+// It must be called while holding the profile lock.
+func OpenLogStore(profile string) (s *LogStore, err error) {
+	if err = migrateStore(profile); err != nil {
+		return
+	}
+
+	s = &LogStore{profile: profile, index: newStoreIndex()}
+
+	f, err := os.Open(transactionLogPath(profile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if err = s.readLog(f); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (s *LogStore) Get(transactionID string) (*monzo.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.get(transactionID), nil
+}
+
+func (s *LogStore) Range(accountID string, query StoreQuery) ([]monzo.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.rangeOf(accountID, query), nil
+}
+
+func (s *LogStore) Upsert(accountID string, transactions ...monzo.Transaction) (created, updated int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tx := range transactions {
+		c, u := s.index.upsert(accountID, tx)
+
+		if c {
+			created++
+		}
+
+		if u {
+			updated++
+		}
+
+		if c || u {
+			s.pending = append(s.pending, logRecord{AccountID: accountID, Transaction: tx})
+		}
+	}
+
+	return
+}
+
+func (s *LogStore) Accounts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index.accounts(), nil
+}
+
+// Flush appends the changed transactions to the log, compacting it if required.
+func (s *LogStore) Flush() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return
+	}
+
+	f, err := s.catchUp()
+	if err != nil {
+		return
+	}
+
+	if err = s.appendPending(f); err != nil {
+		f.Close()
+		return
+	}
+
+	// The log is closed before compacting, as it cannot be replaced while open on Windows.
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	if superseded := s.records - len(s.index.byID); superseded > transactionLogCompactMin && superseded > len(s.index.byID) {
+		return s.compact(CacheEncryptionEnabled())
+	}
+
+	return
+}
+
+// appendPending appends the pending changes to the log, writing the header first if the log is empty.
+func (s *LogStore) appendPending(f *os.File) (err error) {
+	data := []byte{}
+
+	if s.generation == "" {
+		if data, s.generation, err = encodeLogHeader(); err != nil {
+			return
+		}
+	}
+
+	for _, record := range s.pending {
+		line, err := encodeLogRecord(s.profile, record, CacheEncryptionEnabled())
+		if err != nil {
+			return err
+		}
+
+		data = append(data, line...)
+	}
+
+	// Discard anything after the last complete record (left by an interrupted write) before appending.
+	if err = f.Truncate(s.offset); err != nil {
+		return
+	}
+
+	if _, err = f.WriteAt(data, s.offset); err != nil {
+		return
+	}
+
+	if err = f.Sync(); err != nil {
+		return
+	}
+
+	s.offset += int64(len(data))
+	s.records += len(s.pending)
+	s.pending = nil
+
+	return
+}
+
+// Compact rewrites the log with a single record per transaction, encrypting the records if encrypt is true. It must be
+// called while holding the profile lock.
+func (s *LogStore) Compact(encrypt bool) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.catchUp()
+	if err != nil {
+		return
+	}
+
+	f.Close()
+
+	return s.compact(encrypt)
+}
+
+func (s *LogStore) compact(encrypt bool) (err error) {
+	data, generation, err := encodeLogHeader()
+	if err != nil {
+		return
+	}
+
+	for _, accountID := range s.index.accounts() {
+		for _, stored := range s.index.byAccount[accountID] {
+			line, err := encodeLogRecord(s.profile, logRecord{AccountID: accountID, Transaction: stored.tx}, encrypt)
+			if err != nil {
+				return err
+			}
+
+			data = append(data, line...)
+		}
+	}
+
+	if err = writeCacheFile(transactionLogPath(s.profile), data); err != nil {
+		return
+	}
+
+	s.generation, s.offset, s.records = generation, int64(len(data)), len(s.index.byID)
+	s.pending = nil
+
+	return
+}
+
+// catchUp opens the log and reads any records written by other processes since it was last read, then applies the
+// pending changes again so they take precedence.
+func (s *LogStore) catchUp() (f *os.File, err error) {
+	f, err = os.OpenFile(transactionLogPath(s.profile), os.O_RDWR|os.O_CREATE, CacheFilePerm)
+	if err != nil {
+		return
+	}
+
+	if err = s.readLog(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	for _, record := range s.pending {
+		s.index.upsert(record.AccountID, record.Transaction)
+	}
+
+	return
+}
+
+// readLog applies the records in the log after the current offset. If the log has been rewritten since it was last read,
+// the index is rebuilt from the start of the log. An incomplete final line is ignored.
+func (s *LogStore) readLog(f *os.File) (err error) {
+	r := bufio.NewReader(f)
+
+	line, err := readLogLine(r)
+	if errors.Is(err, io.EOF) {
+		s.generation, s.offset, s.records = "", 0, 0
+		return nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	header := &logHeader{}
+	if err = json.Unmarshal(line, header); err != nil {
+		return fmt.Errorf("%s: invalid header - %w", TransactionLogFile, err)
+	}
+
+	if header.Version != TransactionLogVersion {
+		return fmt.Errorf("%w (version %d)", ErrTransactionLogVersion, header.Version)
+	}
+
+	if header.Generation != s.generation {
+		s.index = newStoreIndex()
+		s.generation, s.offset, s.records = header.Generation, int64(len(line)), 0
+	} else {
+		if _, err = f.Seek(s.offset, io.SeekStart); err != nil {
+			return
+		}
+
+		r.Reset(f)
+	}
+
+	for {
+		line, err = readLogLine(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return
+		}
+
+		record, err := decodeLogRecord(s.profile, line)
+		if err != nil {
+			return fmt.Errorf("%s: %w", TransactionLogFile, err)
+		}
+
+		s.index.upsert(record.AccountID, record.Transaction)
+
+		s.offset += int64(len(line))
+		s.records++
+	}
+}
+
+// readLogLine reads a complete line from the log, returning io.EOF if there are no more complete lines.
+func readLogLine(r *bufio.Reader) (line []byte, err error) {
+	line, err = r.ReadBytes('\n')
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+
+	return
+}
+
+func encodeLogHeader() (line []byte, generation string, err error) {
+	id := make([]byte, 8)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+
+	generation = hex.EncodeToString(id)
+
+	line, err = json.Marshal(&logHeader{Version: TransactionLogVersion, Generation: generation})
+
+	return append(line, '\n'), generation, err
+}
+
+func encodeLogRecord(profile string, record logRecord, encrypt bool) (line []byte, err error) {
+	if line, err = json.Marshal(record); err != nil {
+		return
+	}
+
+	if encrypt {
+		if line, err = EncryptCache(profile, TransactionLogFile, line); err != nil {
+			return
+		}
+	}
+
+	return append(line, '\n'), nil
+}
+
+func decodeLogRecord(profile string, line []byte) (record logRecord, err error) {
+	line = bytes.TrimRight(line, "\r\n")
+
+	// Encrypted records are encoded with the version field first, so there is no need to decode every record twice.
+	if bytes.HasPrefix(line, []byte(`{"monzo_encrypted_cache"`)) {
+		if line, err = DecryptCache(profile, TransactionLogFile, line); err != nil {
+			return
+		}
+	}
+
+	err = json.Unmarshal(line, &record)
+
+	return
+}
+
+func transactionLogPath(profile string) string {
+	return path.Join(ProfileDir(profile), TransactionLogFile)
+}
+
+// storeVersion returns the schema version of the profile's transaction store.
+func storeVersion(profile string) (version int, err error) {
+	f, err := os.Open(transactionLogPath(profile))
+	if err == nil {
+		defer f.Close()
+
+		line, err := readLogLine(bufio.NewReader(f))
+		if errors.Is(err, io.EOF) {
+			return TransactionLogVersion, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		header := &logHeader{}
+		if err = json.Unmarshal(line, header); err != nil {
+			return 0, fmt.Errorf("%s: invalid header - %w", TransactionLogFile, err)
+		}
+
+		return header.Version, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	if _, err := os.Stat(cachePath(profile, CacheFileTransactions)); err == nil {
+		return 1, nil
+	}
+
+	return TransactionLogVersion, nil
+}
+
+// migrateStore runs the migrations required to bring the profile's transaction store up to the current schema version.
+func migrateStore(profile string) (err error) {
+	version, err := storeVersion(profile)
+	if err != nil {
+		return
+	}
+
+	for ; version < TransactionLogVersion; version++ {
+		migration, ok := storeMigrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from transaction store version %d", version)
+		}
+
+		if err = migration(profile); err != nil {
+			return fmt.Errorf("failed to migrate transaction store from version %d - %w", version, err)
+		}
+	}
+
+	return
+}
+
+// migrateTransactionsJSON imports the transactions.json cache (version 1) into the transaction log, then removes it.
+func migrateTransactionsJSON(profile string) (err error) {
+	legacy := map[string]*monzo.TransactionList{}
+	if err = LoadProfileCache(profile, CacheFileTransactions, &legacy); err != nil {
+		return
+	}
+
+	s := &LogStore{profile: profile, index: newStoreIndex()}
+
+	for accountID, txns := range legacy {
+		if txns == nil {
+			continue
+		}
+
+		for _, tx := range txns.Transactions {
+			s.index.upsert(accountID, tx)
+		}
+	}
+
+	if err = s.compact(CacheEncryptionEnabled()); err != nil {
+		return
+	}
+
+	return os.Remove(cachePath(profile, CacheFileTransactions))
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// storeProfile points the home directory at a temporary directory, and returns a profile with a directory in it.
+func storeProfile(t *testing.T) string {
+	viper.Set("home-dir", t.TempDir())
+	t.Cleanup(func() { viper.Set("home-dir", nil) })
+
+	assert.NoError(t, EnsureCacheDir(ProfileDir("test")))
+
+	return "test"
+}
+
+// transaction returns a transaction of the amount, created at the time.
+func transaction(id string, created time.Time, amount int64) monzo.Transaction {
+	return monzo.Transaction{ID: id, Amount: amount, Currency: "GBP", Created: created.Format(time.RFC3339)}
+}
+
+// ids returns the IDs of the transactions.
+func ids(txs []monzo.Transaction) []string {
+	result := []string{}
+
+	for _, tx := range txs {
+		result = append(result, tx.ID)
+	}
+
+	return result
+}
+
+// logLines returns the lines of the profile's transaction log.
+func logLines(t *testing.T, profile string) [][]byte {
+	data, err := os.ReadFile(transactionLogPath(profile))
+	assert.NoError(t, err)
+
+	return bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+func TestStoreRange(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"log": func(t *testing.T) Store {
+			store, err := OpenLogStore(storeProfile(t))
+			assert.NoError(t, err)
+
+			return store
+		},
+	}
+
+	tests := []struct {
+		name      string
+		accountID string
+		query     StoreQuery
+		expected  []string
+	}{
+		{"all", "acc_1", StoreQuery{}, []string{"tx_1", "tx_2a", "tx_2b", "tx_3", "tx_5"}},
+		{"since", "acc_1", StoreQuery{Since: date(2023, 1, 2)}, []string{"tx_2a", "tx_2b", "tx_3", "tx_5"}},
+		{"before", "acc_1", StoreQuery{Before: date(2023, 1, 3)}, []string{"tx_1", "tx_2a", "tx_2b"}},
+		{"since and before", "acc_1", StoreQuery{Since: date(2023, 1, 2), Before: date(2023, 1, 5)}, []string{"tx_2a", "tx_2b", "tx_3"}},
+		{"before since", "acc_1", StoreQuery{Since: date(2023, 1, 5), Before: date(2023, 1, 2)}, []string{}},
+		{"limit", "acc_1", StoreQuery{Limit: 2}, []string{"tx_1", "tx_2a"}},
+		{"reverse", "acc_1", StoreQuery{Reverse: true}, []string{"tx_5", "tx_3", "tx_2b", "tx_2a", "tx_1"}},
+		{"reverse limit", "acc_1", StoreQuery{Since: date(2023, 1, 2), Limit: 2, Reverse: true}, []string{"tx_5", "tx_3"}},
+		{"limit over range", "acc_1", StoreQuery{Before: date(2023, 1, 2), Limit: 5}, []string{"tx_1"}},
+		{"other account", "acc_2", StoreQuery{}, []string{"tx_4"}},
+		{"unknown account", "acc_3", StoreQuery{}, []string{}},
+	}
+
+	for name, open := range stores {
+		store := open(t)
+
+		// Added out of order, with transactions moved between accounts and to a later time by updates.
+		_, _, err := store.Upsert("acc_1",
+			transaction("tx_3", date(2023, 1, 3), 300),
+			transaction("tx_2b", date(2023, 1, 2), 200),
+			transaction("tx_1", date(2023, 1, 1), 100),
+			transaction("tx_4", date(2023, 1, 4), 400),
+			transaction("tx_5", date(2023, 1, 1), 500),
+			transaction("tx_2a", date(2023, 1, 2), 200),
+		)
+		assert.NoError(t, err, name)
+
+		_, _, err = store.Upsert("acc_2", transaction("tx_4", date(2023, 1, 4), 400))
+		assert.NoError(t, err, name)
+
+		_, _, err = store.Upsert("acc_1", transaction("tx_5", date(2023, 1, 5), 500))
+		assert.NoError(t, err, name)
+
+		for _, test := range tests {
+			txs, err := store.Range(test.accountID, test.query)
+
+			assert.NoError(t, err, name, test.name)
+			assert.Equal(t, test.expected, ids(txs), name, test.name)
+		}
+
+		accountIDs, err := store.Accounts()
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"acc_1", "acc_2"}, accountIDs, name)
+	}
+}
+
+func TestStoreUpsert(t *testing.T) {
+	stores := map[string]Store{"memory": NewMemoryStore()}
+
+	logStore, err := OpenLogStore(storeProfile(t))
+	assert.NoError(t, err)
+
+	stores["log"] = logStore
+
+	tests := []struct {
+		name             string
+		transactions     []monzo.Transaction
+		created, updated int
+	}{
+		{"new", []monzo.Transaction{transaction("tx_1", date(2023, 1, 1), 100), transaction("tx_2", date(2023, 1, 2), 200)}, 2, 0},
+		{"unchanged", []monzo.Transaction{transaction("tx_1", date(2023, 1, 1), 100)}, 0, 0},
+		{"changed", []monzo.Transaction{transaction("tx_1", date(2023, 1, 1), 150), transaction("tx_3", date(2023, 1, 3), 300)}, 1, 1},
+	}
+
+	for name, store := range stores {
+		for _, test := range tests {
+			created, updated, err := store.Upsert("acc_1", test.transactions...)
+
+			assert.NoError(t, err, name, test.name)
+			assert.Equal(t, test.created, created, name, test.name)
+			assert.Equal(t, test.updated, updated, name, test.name)
+		}
+
+		tx, err := store.Get("tx_1")
+		if assert.NoError(t, err, name) && assert.NotNil(t, tx, name) {
+			assert.Equal(t, int64(150), tx.Amount, name)
+		}
+
+		tx, err = store.Get("tx_4")
+		assert.NoError(t, err, name)
+		assert.Nil(t, tx, name)
+	}
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	profile := storeProfile(t)
+
+	store, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	store.Upsert("acc_1", transaction("tx_1", date(2023, 1, 1), 100), transaction("tx_2", date(2023, 1, 2), 200))
+	assert.NoError(t, store.Flush())
+
+	store.Upsert("acc_1", transaction("tx_1", date(2023, 1, 1), 150))
+	store.Upsert("acc_2", transaction("tx_3", date(2023, 1, 3), 300))
+	assert.NoError(t, store.Flush())
+
+	// Unchanged transactions are not appended again.
+	store.Upsert("acc_1", transaction("tx_2", date(2023, 1, 2), 200))
+	assert.NoError(t, store.Flush())
+
+	assert.Len(t, logLines(t, profile), 5)
+
+	reopened, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	for _, accountID := range []string{"acc_1", "acc_2"} {
+		expected, _ := store.Range(accountID, StoreQuery{})
+		actual, err := reopened.Range(accountID, StoreQuery{})
+
+		assert.NoError(t, err, accountID)
+		assert.Equal(t, expected, actual, accountID)
+	}
+
+	tx, _ := reopened.Get("tx_1")
+	if assert.NotNil(t, tx) {
+		assert.Equal(t, int64(150), tx.Amount)
+	}
+}
+
+func TestLogStoreTornRecord(t *testing.T) {
+	profile := storeProfile(t)
+
+	store, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	store.Upsert("acc_1", transaction("tx_1", date(2023, 1, 1), 100))
+	assert.NoError(t, store.Flush())
+
+	// A write interrupted part way through a record.
+	f, err := os.OpenFile(transactionLogPath(profile), os.O_APPEND|os.O_WRONLY, CacheFilePerm)
+	assert.NoError(t, err)
+
+	_, err = f.WriteString(`{"account_id":"acc_1","transaction":{"id":"tx_2","amou`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	reopened, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	txs, _ := reopened.Range("acc_1", StoreQuery{})
+	assert.Equal(t, []string{"tx_1"}, ids(txs))
+
+	// The torn record is discarded before the next record is appended.
+	reopened.Upsert("acc_1", transaction("tx_3", date(2023, 1, 3), 300))
+	assert.NoError(t, reopened.Flush())
+
+	lines := logLines(t, profile)
+	if assert.Len(t, lines, 3) {
+		for _, line := range lines[1:] {
+			assert.True(t, json.Valid(line), string(line))
+		}
+	}
+
+	reopened, err = OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	txs, _ = reopened.Range("acc_1", StoreQuery{})
+	assert.Equal(t, []string{"tx_1", "tx_3"}, ids(txs))
+}
+
+func TestLogStoreCatchUp(t *testing.T) {
+	profile := storeProfile(t)
+
+	a, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	b, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	a.Upsert("acc_1", transaction("tx_1", date(2023, 1, 1), 100))
+	assert.NoError(t, a.Flush())
+
+	// b reads the record appended by a, but its own change to the transaction takes precedence.
+	b.Upsert("acc_1", transaction("tx_1", date(2023, 1, 1), 150), transaction("tx_2", date(2023, 1, 2), 200))
+	assert.NoError(t, b.Flush())
+
+	a.Upsert("acc_1", transaction("tx_3", date(2023, 1, 3), 300))
+	assert.NoError(t, a.Flush())
+
+	txs, _ := a.Range("acc_1", StoreQuery{})
+	if assert.Equal(t, []string{"tx_1", "tx_2", "tx_3"}, ids(txs)) {
+		assert.Equal(t, int64(150), txs[0].Amount)
+	}
+
+	// Compacting rewrites the log with a new generation, so b reads it again from the start.
+	var header logHeader
+	assert.NoError(t, json.Unmarshal(logLines(t, profile)[0], &header))
+
+	assert.NoError(t, a.Compact(false))
+
+	var compacted logHeader
+	assert.NoError(t, json.Unmarshal(logLines(t, profile)[0], &compacted))
+	assert.Equal(t, TransactionLogVersion, compacted.Version)
+	assert.NotEqual(t, header.Generation, compacted.Generation)
+	assert.Len(t, logLines(t, profile), 4)
+
+	b.Upsert("acc_2", transaction("tx_4", date(2023, 1, 4), 400))
+	assert.NoError(t, b.Flush())
+
+	txs, _ = b.Range("acc_1", StoreQuery{})
+	assert.Equal(t, []string{"tx_1", "tx_2", "tx_3"}, ids(txs))
+
+	reopened, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	accountIDs, _ := reopened.Accounts()
+	assert.Equal(t, []string{"acc_1", "acc_2"}, accountIDs)
+	assert.Len(t, logLines(t, profile), 5)
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		live       int
+		superseded int
+		compacted  bool
+	}{
+		{"below minimum", 1, transactionLogCompactMin, false},
+		{"above minimum", 1, transactionLogCompactMin + 1, true},
+		{"mostly live", transactionLogCompactMin + 500, transactionLogCompactMin + 1, false},
+		{"mostly superseded", transactionLogCompactMin, transactionLogCompactMin + 1, true},
+	}
+
+	for _, test := range tests {
+		profile := storeProfile(t)
+
+		store, err := OpenLogStore(profile)
+		assert.NoError(t, err, test.name)
+
+		for n := 0; n < test.live; n++ {
+			store.Upsert("acc_1", transaction(fmt.Sprintf("tx_%d", n), date(2023, 1, 1), 100))
+		}
+
+		for n := 1; n <= test.superseded; n++ {
+			store.Upsert("acc_1", transaction("tx_0", date(2023, 1, 1), int64(100+n)))
+		}
+
+		assert.NoError(t, store.Flush(), test.name)
+
+		lines := 1 + test.live
+		if !test.compacted {
+			lines += test.superseded
+		}
+
+		assert.Len(t, logLines(t, profile), lines, test.name)
+
+		tx, _ := store.Get("tx_0")
+		if assert.NotNil(t, tx, test.name) {
+			assert.Equal(t, int64(100+test.superseded), tx.Amount, test.name)
+		}
+	}
+}
+
+func TestLogStoreVersion(t *testing.T) {
+	profile := storeProfile(t)
+
+	assert.NoError(t, os.WriteFile(transactionLogPath(profile), []byte(`{"monzo_transaction_log":3,"generation":"0"}`+"\n"), CacheFilePerm))
+
+	_, err := OpenLogStore(profile)
+	assert.ErrorIs(t, err, ErrTransactionLogVersion)
+}
+
+func TestMigrateTransactionsJSON(t *testing.T) {
+	profile := storeProfile(t)
+
+	legacy := map[string]*monzo.TransactionList{
+		"acc_1": {Transactions: []monzo.Transaction{transaction("tx_2", date(2023, 1, 2), 200), transaction("tx_1", date(2023, 1, 1), 100)}},
+		"acc_2": {Transactions: []monzo.Transaction{transaction("tx_3", date(2023, 1, 3), 300)}},
+	}
+
+	assert.NoError(t, SaveProfileCache(profile, CacheFileTransactions, legacy))
+
+	version, err := storeVersion(profile)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	store, err := OpenLogStore(profile)
+	assert.NoError(t, err)
+
+	txs, _ := store.Range("acc_1", StoreQuery{})
+	assert.Equal(t, []string{"tx_1", "tx_2"}, ids(txs))
+
+	txs, _ = store.Range("acc_2", StoreQuery{})
+	assert.Equal(t, []string{"tx_3"}, ids(txs))
+
+	assert.NoFileExists(t, cachePath(profile, CacheFileTransactions))
+
+	version, err = storeVersion(profile)
+	assert.NoError(t, err)
+	assert.Equal(t, TransactionLogVersion, version)
+	assert.Len(t, logLines(t, profile), 4)
+}
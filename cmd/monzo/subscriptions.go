@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	SubscriptionActive    = "active"
+	SubscriptionMissed    = "missed"
+	SubscriptionCancelled = "cancelled"
+
+	CadenceWeekly      = "weekly"
+	CadenceFortnightly = "fortnightly"
+	CadenceMonthly     = "monthly"
+	CadenceQuarterly   = "quarterly"
+	CadenceYearly      = "yearly"
+)
+
+var (
+	subscriptionsCmd = &cobra.Command{
+		Use:     "subscriptions",
+		Short:   "Detect recurring payments and subscriptions from an account's transaction history",
+		GroupID: "reports",
+		PreRunE: subscriptionsPreRunE,
+		RunE:    subscriptionsRunE,
+		Args:    cobra.NoArgs,
+
+		Annotations: map[string]string{annotationOffline: "true"},
+	}
+
+	ErrSubscriptionMonthsInvalid = errors.New("--months must be at least 1")
+
+	ErrSubscriptionMinChargesInvalid = errors.New("--min-charges must be at least 2")
+
+	ErrSubscriptionToleranceInvalid = errors.New("--amount-tolerance must be between 0 and 100")
+)
+
+// cadence is an interval at which a recurring payment can be charged.
+type cadence struct {
+	name string
+
+	// min and max are the range of days between charges accepted as this cadence.
+	min, max float64
+
+	// months and days step from one charge to the next.
+	months, days int
+
+	// grace is how many days late a charge can be before it is considered missed.
+	grace int
+
+	perYear float64
+}
+
+// cadences are the supported cadences, shortest first. Their ranges do not overlap.
+var cadences = []cadence{
+	{name: CadenceWeekly, min: 6, max: 8, days: 7, grace: 2, perYear: 52},
+	{name: CadenceFortnightly, min: 13, max: 15, days: 14, grace: 3, perYear: 26},
+	{name: CadenceMonthly, min: 26, max: 34, months: 1, grace: 5, perYear: 12},
+	{name: CadenceQuarterly, min: 85, max: 98, months: 3, grace: 10, perYear: 4},
+	{name: CadenceYearly, min: 355, max: 376, months: 12, grace: 14, perYear: 1},
+}
+
+// next returns the date of the charge following one at t.
+func (c cadence) next(t time.Time) time.Time {
+	return t.AddDate(0, c.months, c.days)
+}
+
+func init() {
+	FlagSets["subscriptions"] = pflag.NewFlagSet("subscriptions", pflag.ContinueOnError)
+	FlagSets["subscriptions"].Int("months", 13, "Months of transaction history to analyse")
+	FlagSets["subscriptions"].Int("min-charges", 3, "Number of charges needed to detect a subscription (yearly subscriptions need 2)")
+	FlagSets["subscriptions"].Int("amount-tolerance", 20, "Percentage by which a charge can differ from the previous one and still be the same subscription")
+	viper.BindPFlags(FlagSets["subscriptions"])
+
+	subscriptionsCmd.Flags().AddFlagSet(FlagSets["subscriptions"])
+	subscriptionsCmd.Flags().AddFlagSet(FlagSets["account"])
+	subscriptionsCmd.Flags().AddFlagSet(FlagSets["cache"])
+
+	subscriptionsCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	root.AddCommand(subscriptionsCmd)
+}
+
+// SubscriptionReport lists the recurring payments detected in an account's transactions since a time. Amounts are in
+// minor units, with money spent positive.
+type SubscriptionReport struct {
+	AccountID string    `json:"account_id"`
+	Currency  string    `json:"currency"`
+	Since     time.Time `json:"since"`
+
+	// MonthlyTotal and AnnualTotal are the cost of the subscriptions that have not been cancelled.
+	MonthlyTotal int64 `json:"monthly_total"`
+	AnnualTotal  int64 `json:"annual_total"`
+
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// Table returns the table layout of the report, with a row per subscription followed by a total row.
+func (r *SubscriptionReport) Table() *Table {
+	total := Subscription{Name: "Total", Currency: r.Currency, MonthlyCost: r.MonthlyTotal, AnnualCost: r.AnnualTotal}
+
+	return &Table{Columns: subscriptionColumns, Defaults: subscriptionDefaults, Rows: append(rowsOf(r.Subscriptions), total)}
+}
+
+// Subscription is a payment to the same merchant or counterparty, of a similar amount, at a regular interval.
+type Subscription struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Cadence  string `json:"cadence"`
+
+	Charges        int       `json:"charges"`
+	TransactionIDs []string  `json:"transaction_ids"`
+	First          time.Time `json:"first"`
+	Last           time.Time `json:"last"`
+
+	// Amount is the latest charge, and PreviousAmount the one before it.
+	Amount         int64 `json:"amount"`
+	PreviousAmount int64 `json:"previous_amount"`
+	PriceIncrease  bool  `json:"price_increase"`
+
+	// Next and NextAmount are the expected date and amount of the charge following the latest one.
+	Next       time.Time `json:"next"`
+	NextAmount int64     `json:"next_amount"`
+
+	MonthlyCost int64 `json:"monthly_cost"`
+	AnnualCost  int64 `json:"annual_cost"`
+
+	// Status is active, missed (the next charge is overdue) or cancelled (the charge after it is overdue too).
+	Status string `json:"status"`
+}
+
+var (
+	subscriptionDefaults = []string{"name", "cadence", "amount", "price_change", "last", "next", "monthly", "annual", "status"}
+
+	subscriptionColumns = []Column{
+		{"key", func(r any) string { return r.(Subscription).Key }},
+		{"name", func(r any) string { return r.(Subscription).Name }},
+		{"cadence", func(r any) string { return r.(Subscription).Cadence }},
+		{"charges", func(r any) string { return subscriptionCount(r.(Subscription)) }},
+		{"first", func(r any) string { return formatDate(r.(Subscription).First) }},
+		{"last", func(r any) string { return formatDate(r.(Subscription).Last) }},
+		{"amount", func(r any) string { s := r.(Subscription); return subscriptionAmount(s, s.Amount) }},
+		{"previous_amount", func(r any) string { s := r.(Subscription); return subscriptionAmount(s, s.PreviousAmount) }},
+		{"price_change", func(r any) string {
+			s := r.(Subscription)
+			if s.Amount == s.PreviousAmount {
+				return ""
+			}
+
+			change := FormatAmount(s.Amount-s.PreviousAmount, s.Currency)
+			if s.PriceIncrease {
+				change = "+" + change
+			}
+
+			return change
+		}},
+		{"next", func(r any) string { return formatDate(r.(Subscription).Next) }},
+		{"next_amount", func(r any) string { s := r.(Subscription); return subscriptionAmount(s, s.NextAmount) }},
+		{"monthly", func(r any) string { s := r.(Subscription); return FormatAmount(s.MonthlyCost, s.Currency) }},
+		{"annual", func(r any) string { s := r.(Subscription); return FormatAmount(s.AnnualCost, s.Currency) }},
+		{"currency", func(r any) string { return r.(Subscription).Currency }},
+		{"status", func(r any) string { return r.(Subscription).Status }},
+	}
+)
+
+// subscriptionAmount formats an amount charged by the subscription, leaving it empty on the total row.
+func subscriptionAmount(s Subscription, amount int64) string {
+	if s.Charges == 0 {
+		return ""
+	}
+
+	return FormatAmount(amount, s.Currency)
+}
+
+func subscriptionCount(s Subscription) string {
+	if s.Charges == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(s.Charges)
+}
+
+// subscriptionCharge is a payment to a merchant or counterparty that may be part of a subscription.
+type subscriptionCharge struct {
+	id      string
+	created time.Time
+	amount  int64
+}
+
+// subscriptionCandidate is a series of charges of similar amounts to the same merchant or counterparty.
+type subscriptionCandidate struct {
+	key, name, currency string
+	charges             []subscriptionCharge
+}
+
+// DetectSubscriptions finds the recurring payments in the transactions, which must be in the order they were created.
+// Charges to the same merchant or counterparty are part of the same subscription while each is within tolerance (a
+// fraction, e.g. 0.2) of the one before it, and a subscription needs minCharges charges at a regular cadence. Statuses
+// are relative to now.
+func DetectSubscriptions(txs []monzo.Transaction, minCharges int, tolerance float64, now time.Time) []Subscription {
+	candidates := []*subscriptionCandidate{}
+	byPayee := map[string][]*subscriptionCandidate{}
+
+	for _, tx := range txs {
+		if tx.Amount >= 0 || tx.Declined() || tx.IsLoad || tx.PotID() != "" {
+			continue
+		}
+
+		payee := spendingShares(SpendingByCounterparty, tx)[0]
+		charge := subscriptionCharge{id: tx.ID, created: tx.CreatedTime(), amount: -tx.Amount}
+
+		var candidate *subscriptionCandidate
+		for _, c := range byPayee[payee.key] {
+			last := c.charges[len(c.charges)-1].amount
+			if c.currency == tx.Currency && math.Abs(float64(charge.amount-last)) <= tolerance*float64(last) {
+				candidate = c
+				break
+			}
+		}
+
+		if candidate == nil {
+			candidate = &subscriptionCandidate{key: payee.key, name: payee.name, currency: tx.Currency}
+			candidates = append(candidates, candidate)
+			byPayee[payee.key] = append(byPayee[payee.key], candidate)
+		}
+
+		candidate.charges = append(candidate.charges, charge)
+	}
+
+	subscriptions := []Subscription{}
+	for _, candidate := range candidates {
+		if s, ok := candidate.subscription(minCharges, now); ok {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		if ci, cj := subscriptions[i].Status == SubscriptionCancelled, subscriptions[j].Status == SubscriptionCancelled; ci != cj {
+			return cj
+		}
+
+		return subscriptions[i].MonthlyCost > subscriptions[j].MonthlyCost
+	})
+
+	return subscriptions
+}
+
+// subscription returns the candidate as a subscription, if its charges are at a regular cadence.
+func (c *subscriptionCandidate) subscription(minCharges int, now time.Time) (s Subscription, ok bool) {
+	if len(c.charges) < 2 {
+		return
+	}
+
+	intervals := make([]float64, len(c.charges)-1)
+	for n := range intervals {
+		intervals[n] = c.charges[n+1].created.Sub(c.charges[n].created).Hours() / 24
+	}
+
+	sorted := append([]float64{}, intervals...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var cad *cadence
+	for n := range cadences {
+		if median >= cadences[n].min && median <= cadences[n].max {
+			cad = &cadences[n]
+		}
+	}
+
+	if cad == nil {
+		return
+	}
+
+	// A year of history only covers two charges of a yearly subscription.
+	required := minCharges
+	if cad.name == CadenceYearly {
+		required = 2
+	}
+
+	if len(c.charges) < required {
+		return
+	}
+
+	// Allow the odd irregular interval (e.g. a charge delayed past a weekend), as long as most are regular.
+	regular := 0
+	for _, interval := range intervals {
+		if interval >= cad.min && interval <= cad.max {
+			regular++
+		}
+	}
+
+	if regular*4 < len(intervals)*3 {
+		return
+	}
+
+	first, last := c.charges[0], c.charges[len(c.charges)-1]
+	previous := c.charges[len(c.charges)-2]
+
+	s = Subscription{
+		Key:            c.key,
+		Name:           c.name,
+		Currency:       c.currency,
+		Cadence:        cad.name,
+		Charges:        len(c.charges),
+		First:          first.created,
+		Last:           last.created,
+		Amount:         last.amount,
+		PreviousAmount: previous.amount,
+		PriceIncrease:  last.amount > previous.amount,
+		Next:           cad.next(last.created),
+		NextAmount:     last.amount,
+		MonthlyCost:    int64(math.Round(float64(last.amount) * cad.perYear / 12)),
+		AnnualCost:     int64(math.Round(float64(last.amount) * cad.perYear)),
+		Status:         SubscriptionActive,
+	}
+
+	for _, charge := range c.charges {
+		s.TransactionIDs = append(s.TransactionIDs, charge.id)
+	}
+
+	switch {
+	case now.After(cad.next(s.Next).AddDate(0, 0, cad.grace)):
+		s.Status = SubscriptionCancelled
+	case now.After(s.Next.AddDate(0, 0, cad.grace)):
+		s.Status = SubscriptionMissed
+	}
+
+	return s, true
+}
+
+// NewSubscriptionReport returns the subscriptions detected in the account's transactions, with their total cost.
+func NewSubscriptionReport(accountID string, since time.Time, subscriptions []Subscription) *SubscriptionReport {
+	report := &SubscriptionReport{AccountID: accountID, Since: since, Subscriptions: subscriptions}
+
+	for _, s := range subscriptions {
+		if report.Currency == "" {
+			report.Currency = s.Currency
+		}
+
+		if s.Status != SubscriptionCancelled {
+			report.MonthlyTotal += s.MonthlyCost
+			report.AnnualTotal += s.AnnualCost
+		}
+	}
+
+	return report
+}
+
+func subscriptionsPreRunE(cmd *cobra.Command, args []string) (err error) {
+	switch {
+	case viper.GetInt("months") < 1:
+		return ErrSubscriptionMonthsInvalid
+	case viper.GetInt("min-charges") < 2:
+		return ErrSubscriptionMinChargesInvalid
+	case viper.GetInt("amount-tolerance") < 0, viper.GetInt("amount-tolerance") > 100:
+		return ErrSubscriptionToleranceInvalid
+	}
+
+	_, err = CacheMode()
+	return
+}
+
+func subscriptionsRunE(cmd *cobra.Command, args []string) (err error) {
+	accountID, err := ResolveAccount(cmd)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	since := now.AddDate(0, -viper.GetInt("months"), 0)
+
+	txs, err := transactionsSince(cmd, accountID, since)
+	if err != nil {
+		return
+	}
+
+	subscriptions := DetectSubscriptions(txs, viper.GetInt("min-charges"), float64(viper.GetInt("amount-tolerance"))/100, now)
+
+	return Output(cmd, NewSubscriptionReport(accountID, since, subscriptions))
+}
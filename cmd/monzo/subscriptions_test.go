@@ -0,0 +1,219 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+// date returns midnight UTC on the date.
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// charges returns a payment of each amount to the merchant, at each of the times.
+func charges(merchant string, amounts []int64, times ...time.Time) (txs []monzo.Transaction) {
+	for n, created := range times {
+		tx := monzo.Transaction{
+			ID:       merchant + "_" + created.Format("20060102"),
+			Amount:   -amounts[n%len(amounts)],
+			Currency: "GBP",
+			Created:  created.Format(time.RFC3339),
+		}
+
+		tx.Merchant.ID = "merch_" + strings.ToLower(merchant)
+		tx.Merchant.Name = merchant
+
+		txs = append(txs, tx)
+	}
+
+	return
+}
+
+// every returns n times, each the months and days after the one before.
+func every(from time.Time, months, days, n int) (times []time.Time) {
+	for i := 0; i < n; i++ {
+		times = append(times, from.AddDate(0, months*i, days*i))
+	}
+
+	return
+}
+
+// inOrder returns the transactions in the order they were created.
+func inOrder(series ...[]monzo.Transaction) (txs []monzo.Transaction) {
+	for _, s := range series {
+		txs = append(txs, s...)
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].CreatedTime().Before(txs[j].CreatedTime()) })
+
+	return
+}
+
+func TestDetectSubscriptions(t *testing.T) {
+	type expected struct {
+		name, cadence, status string
+		charges               int
+		amount, previous      int64
+		next                  time.Time
+	}
+
+	declined := charges("Gym", []int64{3000}, date(2023, 6, 20))
+	declined[0].DeclineReason = "INSUFFICIENT_FUNDS"
+
+	tests := []struct {
+		name     string
+		txs      []monzo.Transaction
+		now      time.Time
+		expected []expected
+	}{
+		{
+			name:     "monthly",
+			txs:      charges("Netflix", []int64{1099}, every(date(2023, 1, 15), 1, 0, 6)...),
+			now:      date(2023, 7, 1),
+			expected: []expected{{"Netflix", CadenceMonthly, SubscriptionActive, 6, 1099, 1099, date(2023, 7, 15)}},
+		},
+		{
+			name:     "weekly",
+			txs:      charges("Milk", []int64{450}, every(date(2023, 6, 1), 0, 7, 4)...),
+			now:      date(2023, 6, 24),
+			expected: []expected{{"Milk", CadenceWeekly, SubscriptionActive, 4, 450, 450, date(2023, 6, 29)}},
+		},
+		{
+			name:     "fortnightly",
+			txs:      charges("Cleaner", []int64{4000}, every(date(2023, 5, 1), 0, 14, 4)...),
+			now:      date(2023, 6, 13),
+			expected: []expected{{"Cleaner", CadenceFortnightly, SubscriptionActive, 4, 4000, 4000, date(2023, 6, 26)}},
+		},
+		{
+			name:     "quarterly",
+			txs:      charges("Water", []int64{9000}, every(date(2022, 10, 1), 3, 0, 4)...),
+			now:      date(2023, 8, 1),
+			expected: []expected{{"Water", CadenceQuarterly, SubscriptionActive, 4, 9000, 9000, date(2023, 10, 1)}},
+		},
+		{
+			name:     "yearly needs two charges",
+			txs:      charges("Domain", []int64{1200}, date(2022, 3, 1), date(2023, 3, 1)),
+			now:      date(2023, 6, 1),
+			expected: []expected{{"Domain", CadenceYearly, SubscriptionActive, 2, 1200, 1200, date(2024, 3, 1)}},
+		},
+		{
+			name: "single yearly charge",
+			txs:  charges("Domain", []int64{1200}, date(2023, 3, 1)),
+			now:  date(2023, 6, 1),
+		},
+		{
+			name: "too few monthly charges",
+			txs:  charges("Netflix", []int64{1099}, date(2023, 5, 15), date(2023, 6, 15)),
+			now:  date(2023, 7, 1),
+		},
+		{
+			name: "irregular intervals",
+			txs:  charges("Takeaway", []int64{2000}, date(2023, 1, 1), date(2023, 1, 11), date(2023, 3, 2), date(2023, 3, 22), date(2023, 5, 30)),
+			now:  date(2023, 6, 1),
+		},
+		{
+			name:     "one skipped charge",
+			txs:      charges("Netflix", []int64{1099}, date(2023, 1, 15), date(2023, 2, 15), date(2023, 3, 15), date(2023, 5, 15), date(2023, 6, 15), date(2023, 7, 15)),
+			now:      date(2023, 7, 20),
+			expected: []expected{{"Netflix", CadenceMonthly, SubscriptionActive, 6, 1099, 1099, date(2023, 8, 15)}},
+		},
+		{
+			name: "mostly irregular intervals",
+			txs:  charges("Netflix", []int64{1099}, date(2023, 1, 15), date(2023, 2, 15), date(2023, 3, 27), date(2023, 4, 5), date(2023, 5, 15)),
+			now:  date(2023, 6, 1),
+		},
+		{
+			name: "amounts outside tolerance",
+			txs: inOrder(
+				charges("Apple", []int64{299}, every(date(2023, 1, 3), 1, 0, 5)...),
+				charges("Apple", []int64{1099}, every(date(2023, 1, 20), 1, 0, 5)...),
+			),
+			now: date(2023, 6, 1),
+			expected: []expected{
+				{"Apple", CadenceMonthly, SubscriptionActive, 5, 1099, 1099, date(2023, 6, 20)},
+				{"Apple", CadenceMonthly, SubscriptionActive, 5, 299, 299, date(2023, 6, 3)},
+			},
+		},
+		{
+			name:     "price increase",
+			txs:      charges("Spotify", []int64{999, 999, 999, 1099}, every(date(2023, 2, 5), 1, 0, 4)...),
+			now:      date(2023, 6, 1),
+			expected: []expected{{"Spotify", CadenceMonthly, SubscriptionActive, 4, 1099, 999, date(2023, 6, 5)}},
+		},
+		{
+			name:     "missed",
+			txs:      charges("Netflix", []int64{1099}, every(date(2023, 1, 15), 1, 0, 6)...),
+			now:      date(2023, 7, 21),
+			expected: []expected{{"Netflix", CadenceMonthly, SubscriptionMissed, 6, 1099, 1099, date(2023, 7, 15)}},
+		},
+		{
+			name:     "within grace",
+			txs:      charges("Netflix", []int64{1099}, every(date(2023, 1, 15), 1, 0, 6)...),
+			now:      date(2023, 7, 20),
+			expected: []expected{{"Netflix", CadenceMonthly, SubscriptionActive, 6, 1099, 1099, date(2023, 7, 15)}},
+		},
+		{
+			name: "cancelled",
+			txs: inOrder(
+				charges("Netflix", []int64{1099}, every(date(2023, 1, 15), 1, 0, 6)...),
+				charges("Disney", []int64{799}, every(date(2023, 3, 1), 1, 0, 6)...),
+			),
+			now: date(2023, 8, 21),
+			expected: []expected{
+				{"Disney", CadenceMonthly, SubscriptionActive, 6, 799, 799, date(2023, 9, 1)},
+				{"Netflix", CadenceMonthly, SubscriptionCancelled, 6, 1099, 1099, date(2023, 7, 15)},
+			},
+		},
+		{
+			name: "not payments",
+			txs: inOrder(
+				charges("Refund", []int64{-500}, every(date(2023, 1, 15), 1, 0, 4)...),
+				declined,
+			),
+			now: date(2023, 6, 1),
+		},
+	}
+
+	for _, test := range tests {
+		subscriptions := DetectSubscriptions(test.txs, 3, 0.2, test.now)
+
+		actual := []expected{}
+		for _, s := range subscriptions {
+			actual = append(actual, expected{s.Name, s.Cadence, s.Status, s.Charges, s.Amount, s.PreviousAmount, s.Next})
+
+			assert.Equal(t, s.Amount > s.PreviousAmount, s.PriceIncrease, test.name)
+			assert.Equal(t, s.Amount, s.NextAmount, test.name)
+			assert.Len(t, s.TransactionIDs, s.Charges, test.name)
+		}
+
+		if test.expected == nil {
+			test.expected = []expected{}
+		}
+
+		assert.Equal(t, test.expected, actual, test.name)
+	}
+}
+
+func TestNewSubscriptionReport(t *testing.T) {
+	txs := inOrder(
+		charges("Netflix", []int64{1099}, every(date(2023, 1, 15), 1, 0, 6)...),
+		charges("Milk", []int64{450}, every(date(2023, 6, 1), 0, 7, 4)...),
+		charges("Gym", []int64{3000}, every(date(2023, 1, 1), 1, 0, 3)...),
+	)
+
+	report := NewSubscriptionReport("acc_1", date(2022, 6, 1), DetectSubscriptions(txs, 3, 0.2, date(2023, 6, 24)))
+
+	if assert.Len(t, report.Subscriptions, 3) {
+		assert.Equal(t, SubscriptionCancelled, report.Subscriptions[2].Status)
+	}
+
+	// The cancelled gym membership is not included.
+	assert.Equal(t, "GBP", report.Currency)
+	assert.Equal(t, int64(1099+1950), report.MonthlyTotal)
+	assert.Equal(t, int64(1099*12+450*52), report.AnnualTotal)
+}
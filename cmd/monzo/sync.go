@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	syncCmd = &cobra.Command{
+		Use:     "sync",
+		Short:   "Incrementally sync the full transaction history of every account into the cache",
+		GroupID: "transactions",
+		RunE:    syncRunE,
+		Args:    cobra.NoArgs,
+	}
+
+	syncStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the progress of the background sync started by login, and how far back the cache goes",
+		RunE:  syncStatusRunE,
+		Args:  cobra.NoArgs,
+
+		Annotations: map[string]string{annotationNoAuth: "true", annotationOffline: "true"},
+	}
+
+	ErrSyncFailed = errors.New("sync failed for one or more accounts")
+)
+
+const (
+	// DefaultSyncWindow is how far back from now transactions are re-fetched on every sync, to pick up
+	// changes to recent transactions (e.g. pending transactions settling).
+	DefaultSyncWindow = time.Hour * 24 * 14
+
+	// SyncFlushPages is how many pages of transactions are synced between writes of the cache.
+	SyncFlushPages = 10
+)
+
+func init() {
+	FlagSets["sync"] = pflag.NewFlagSet("sync", pflag.ContinueOnError)
+	FlagSets["sync"].Duration("window", DefaultSyncWindow, "Re-fetch transactions created within this window, to pick up updates")
+	viper.BindPFlags(FlagSets["sync"])
+
+	syncCmd.Flags().AddFlagSet(FlagSets["sync"])
+	syncCmd.Flags().AddFlagSet(FlagSets["expand"])
+	syncCmd.Flags().StringP("account-id", "a", "", "Only sync this account (default: all accounts)")
+	syncCmd.Flags().Bool("progress", false, "Print progress to stderr as each page of transactions is stored")
+	syncCmd.RegisterFlagCompletionFunc("account-id", completeAccounts)
+
+	syncCmd.AddCommand(syncStatusCmd)
+
+	root.AddCommand(syncCmd)
+}
+
+// AccountSyncState records the progress of syncing a single account's transactions into the cache.
+type AccountSyncState struct {
+	LatestTransactionID        string    `json:"latest_transaction_id"`
+	LatestTransactionCreated   time.Time `json:"latest_transaction_created"`
+	EarliestTransactionCreated time.Time `json:"earliest_transaction_created"`
+	LastSynced                 time.Time `json:"last_synced"`
+	LastCreated                int       `json:"last_created"`
+	LastUpdated                int       `json:"last_updated"`
+
+	// CoveredFrom and CoveredUntil bound the range of created times in which every transaction is cached, as of
+	// CoveredUntil. A zero CoveredFrom means the range starts from the beginning of the account's history.
+	CoveredFrom  time.Time `json:"covered_from,omitempty"`
+	CoveredUntil time.Time `json:"covered_until,omitempty"`
+}
+
+// SyncState is the cached sync metadata for each account, keyed by account ID.
+type SyncState map[string]*AccountSyncState
+
+// Account returns the sync state for the account, creating it if it does not exist yet.
+func (s SyncState) Account(accountID string) *AccountSyncState {
+	if s[accountID] == nil {
+		s[accountID] = &AccountSyncState{}
+	}
+
+	return s[accountID]
+}
+
+// snapshot returns the encoded state of each account, used to find the accounts changed since.
+func (s SyncState) snapshot() map[string][]byte {
+	saved := map[string][]byte{}
+
+	for accountID, state := range s {
+		saved[accountID], _ = json.Marshal(state)
+	}
+
+	return saved
+}
+
+// changedSince reports whether the state of any account differs from the snapshot.
+func (s SyncState) changedSince(saved map[string][]byte) bool {
+	for accountID, state := range s {
+		if data, _ := json.Marshal(state); !bytes.Equal(data, saved[accountID]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// merge copies the state of each account that has changed since the snapshot.
+func (s SyncState) merge(from SyncState, saved map[string][]byte) {
+	for accountID, state := range from {
+		if data, _ := json.Marshal(state); !bytes.Equal(data, saved[accountID]) {
+			merged := *state
+			s[accountID] = &merged
+		}
+	}
+}
+
+// SyncResult reports the outcome of syncing a single account.
+type SyncResult struct {
+	AccountID           string `json:"account_id"`
+	Created             int    `json:"created"`
+	Updated             int    `json:"updated"`
+	LatestTransactionID string `json:"latest_transaction_id"`
+	Error               string `json:"error,omitempty"`
+}
+
+var (
+	syncDefaults = []string{"account", "created", "updated", "error"}
+
+	syncColumns = []Column{
+		{"account", func(r any) string { return r.(SyncResult).AccountID }},
+		{"created", func(r any) string { return strconv.Itoa(r.(SyncResult).Created) }},
+		{"updated", func(r any) string { return strconv.Itoa(r.(SyncResult).Updated) }},
+		{"latest_transaction_id", func(r any) string { return r.(SyncResult).LatestTransactionID }},
+		{"error", func(r any) string { return r.(SyncResult).Error }},
+	}
+)
+
+// SyncReport is the output of the sync command.
+type SyncReport struct {
+	Accounts []SyncResult `json:"accounts"`
+}
+
+// Table returns the table layout of the report, with a row per account.
+func (r *SyncReport) Table() *Table {
+	return &Table{Columns: syncColumns, Defaults: syncDefaults, Rows: rowsOf(r.Accounts)}
+}
+
+// Syncer pages transactions from the API into the transaction store, recording its progress in the sync state.
+//
+// SyncAccount is safe to call concurrently for different accounts.
+type Syncer struct {
+	Client          *monzo.Client
+	Store           Store
+	State           SyncState
+	Window          time.Duration
+	ExpandMerchants bool
+
+	// Token is used to find how far back transactions can be fetched. Without it, only the recent history is assumed
+	// to be available.
+	Token *Token
+
+	// Progress, if set, is called after each page of transactions has been stored.
+	Progress func(accountID string, page *monzo.TransactionList)
+
+	// Flush, if set, is called to save the transactions and sync state every SyncFlushPages pages.
+	Flush func() error
+
+	mu    sync.Mutex
+	pages int
+}
+
+// SyncAccount fetches any transactions newer than the account's checkpoint, plus any within the trailing window,
+// and stores them in the cache. Transactions created before the history the token can fetch are skipped.
+//
+// The transactions and checkpoint are flushed every SyncFlushPages pages, so an interrupted sync resumes close to where
+// it stopped. The range of transactions fetched is recorded in the account's coverage as each page is stored.
+func (s *Syncer) SyncAccount(accountID string) (result SyncResult, err error) {
+	s.mu.Lock()
+	state := s.State.Account(accountID)
+	s.mu.Unlock()
+
+	result.AccountID = accountID
+	started := time.Now().UTC()
+	historyFrom := s.Token.HistoryFrom(started)
+
+	// since is the creation time the fetched range starts from, zero for the beginning of the history.
+	paging, since := &monzo.Pagination{}, time.Time{}
+
+	if state.LatestTransactionID != "" {
+		windowStart := started.Add(-s.Window)
+
+		if state.LatestTransactionCreated.Before(windowStart) {
+			paging.Since, since = state.LatestTransactionID, state.LatestTransactionCreated
+		} else {
+			paging.Since, since = windowStart.Format(time.RFC3339), windowStart
+		}
+	}
+
+	if since.Before(historyFrom) {
+		paging.Since = historyFrom.Format(time.RFC3339)
+	}
+
+	// Every transaction up to the checkpoint was fetched by previous syncs, so the range continues on from the range
+	// they covered.
+	if until := state.coveredUntil(); !until.IsZero() && until.Before(since) {
+		since = until
+	}
+
+	err = s.Client.Transactions.ListPages(accountID, s.ExpandMerchants, paging, func(page *monzo.TransactionList) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		created, updated, err := s.Store.Upsert(accountID, page.Transactions...)
+		if err != nil {
+			return err
+		}
+
+		result.Created += created
+		result.Updated += updated
+
+		for _, tx := range page.Transactions {
+			createdTime := tx.CreatedTime()
+
+			if !createdTime.Before(state.LatestTransactionCreated) {
+				state.LatestTransactionID, state.LatestTransactionCreated = tx.ID, createdTime
+			}
+
+			if state.EarliestTransactionCreated.IsZero() || createdTime.Before(state.EarliestTransactionCreated) {
+				state.EarliestTransactionCreated = createdTime
+			}
+		}
+
+		state.AddCoverage(since, state.LatestTransactionCreated, started, historyFrom)
+
+		if s.Progress != nil {
+			s.Progress(accountID, page)
+		}
+
+		if s.pages++; s.Flush != nil && s.pages%SyncFlushPages == 0 {
+			return s.Flush()
+		}
+
+		return nil
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result.LatestTransactionID = state.LatestTransactionID
+
+	if err != nil {
+		return
+	}
+
+	state.LastSynced = time.Now().UTC()
+	state.LastCreated, state.LastUpdated = result.Created, result.Updated
+
+	state.AddCoverage(since, time.Time{}, started, historyFrom)
+
+	return
+}
+
+// SyncAccounts syncs each of the accounts concurrently, so the full history of every account can be fetched inside the
+// full history window. The report lists the result of each account in the order given, and ErrSyncFailed is returned if
+// any of them failed.
+func (s *Syncer) SyncAccounts(accountIDs []string) (report *SyncReport, err error) {
+	report = &SyncReport{Accounts: make([]SyncResult, len(accountIDs))}
+	done := make(chan int, len(accountIDs))
+
+	for i, accountID := range accountIDs {
+		go func(i int, accountID string) {
+			result, err := s.SyncAccount(accountID)
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			report.Accounts[i] = result
+			done <- i
+		}(i, accountID)
+	}
+
+	for range accountIDs {
+		<-done
+	}
+
+	failed := []string{}
+
+	for _, result := range report.Accounts {
+		if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.AccountID, result.Error))
+		}
+	}
+
+	if len(failed) != 0 {
+		err = fmt.Errorf("%w [%s]", ErrSyncFailed, strings.Join(failed, ", "))
+	}
+
+	return
+}
+
+// NewSyncer creates a Syncer using the command's transaction store and sync state, and the flag/env configuration.
+func NewSyncer(c *monzo.Client, token *Token) (syncer *Syncer, err error) {
+	store, err := openCaches.Store()
+	if err != nil {
+		return
+	}
+
+	return &Syncer{
+		Client:          c,
+		Store:           store,
+		State:           openCaches.SyncState(),
+		Flush:           openCaches.Flush,
+		Window:          viper.GetDuration("window"),
+		ExpandMerchants: viper.GetBool("expand-merchants"),
+		Token:           token,
+	}, nil
+}
+
+func syncRunE(cmd *cobra.Command, args []string) (err error) {
+	accountIDs := []string{}
+
+	if ref, _ := cmd.Flags().GetString("account-id"); ref != "" {
+		accountID, err := ResolveAccountRef(ref)
+		if err != nil {
+			return err
+		}
+
+		accountIDs = append(accountIDs, accountID)
+	} else {
+		accounts, err := _client.Accounts.List()
+		if err != nil {
+			return err
+		}
+
+		for _, acc := range accounts.Accounts {
+			accountIDs = append(accountIDs, acc.ID)
+		}
+
+		if err = saveAccountsSnapshot(accounts); err != nil {
+			return err
+		}
+	}
+
+	syncer, err := NewSyncer(_client, _token)
+	if err != nil {
+		return
+	}
+
+	progress, _ := cmd.Flags().GetBool("progress")
+
+	if progress {
+		counts := map[string]int{}
+
+		syncer.Progress = func(accountID string, page *monzo.TransactionList) {
+			counts[accountID] += len(page.Transactions)
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "Syncing %s: %d transactions, back to %s\n", accountID, counts[accountID],
+				formatDate(syncer.State.Account(accountID).EarliestTransactionCreated))
+		}
+	}
+
+	report, syncErr := syncer.SyncAccounts(accountIDs)
+
+	if progress {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Sync finished at %s\n", time.Now().Local().Format(time.RFC1123))
+	}
+
+	if err = Output(cmd, report); err != nil {
+		return
+	}
+
+	return syncErr
+}
+
+// syncStatusRunE prints the log of the last background sync, and how far back each account's cached history goes.
+func syncStatusRunE(cmd *cobra.Command, args []string) (err error) {
+	w := cmd.OutOrStdout()
+
+	log, err := os.ReadFile(path.Join(ProfileDir(CurrentProfile()), SyncLogFile))
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintln(w, "No background sync has been started for this profile.")
+	case err != nil:
+		return
+	default:
+		w.Write(log)
+
+		if !bytes.Contains(log, []byte("Sync finished at")) {
+			fmt.Fprintln(w, "\nThe sync has not finished. If it is no longer running, run monzo sync to resume it.")
+		}
+	}
+
+	state := openCaches.SyncState()
+	accounts := []monzo.Account{}
+
+	snapshot := &Snapshot[*monzo.AccountsList]{}
+	if LoadCache(CacheFileAccounts, snapshot) == nil && snapshot.Data != nil {
+		accounts = snapshot.Data.Accounts
+	} else {
+		for accountID := range state {
+			accounts = append(accounts, monzo.Account{ID: accountID})
+		}
+
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	}
+
+	fmt.Fprintf(w, "\nCached history:\n%s", DescribeCacheCoverage(state, accounts))
+
+	return nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransactionsAPI serves transactions from the /transactions endpoint in the order they were created, paged like
+// the Monzo API, and records the since parameter of each request. Requests after the first failAfter fail.
+type fakeTransactionsAPI struct {
+	transactions []monzo.Transaction
+	failAfter    int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeTransactionsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	f.requests = append(f.requests, query.Get("since"))
+
+	if f.failAfter != 0 && len(f.requests) > f.failAfter {
+		http.Error(w, `{"code": "internal_error", "message": "unavailable"}`, http.StatusInternalServerError)
+		return
+	}
+
+	since, limit := query.Get("since"), monzo.MaxPageLimit
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil {
+		limit = l
+	}
+
+	sinceTime, err := time.Parse(time.RFC3339, since)
+	found := err == nil || since == ""
+
+	list := monzo.TransactionList{Transactions: []monzo.Transaction{}}
+
+	for _, tx := range f.transactions {
+		switch {
+		case len(list.Transactions) == limit:
+		case !found:
+			found = tx.ID == since
+		case sinceTime.IsZero() || !tx.CreatedTime().Before(sinceTime):
+			list.Transactions = append(list.Transactions, tx)
+		}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// fakeClient returns a client for the fake API, which is stopped when the test completes.
+func fakeClient(t *testing.T, api *fakeTransactionsAPI) *monzo.Client {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	c := monzo.New(server.Client())
+	c.BaseURL, _ = url.Parse(server.URL)
+
+	return c
+}
+
+// history returns n transactions, one an hour up to the time.
+func history(n int, until time.Time) (txs []monzo.Transaction) {
+	for i := 0; i < n; i++ {
+		txs = append(txs, transaction(fmt.Sprintf("tx_%04d", i), until.Add(-time.Hour*time.Duration(n-1-i)), -100))
+	}
+
+	return
+}
+
+func TestSyncerSyncAccount(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	day := time.Hour * 24
+	txs := history(150, now.Add(-day))
+
+	tests := []struct {
+		name            string
+		state           *AccountSyncState
+		cached          int
+		expectedSince   string
+		sinceWindow     bool
+		expectedCreated int
+		expectedUpdated int
+	}{
+		{"first sync", nil, 0, "", false, 150, 0},
+		{"checkpoint before the window", &AccountSyncState{LatestTransactionID: "tx_0100", LatestTransactionCreated: txs[100].CreatedTime()},
+			101, "tx_0100", false, 49, 0},
+		{"checkpoint within the window", &AccountSyncState{LatestTransactionID: "tx_0149", LatestTransactionCreated: txs[149].CreatedTime()},
+			150, "", true, 0, 0},
+	}
+
+	for _, test := range tests {
+		api := &fakeTransactionsAPI{transactions: txs}
+		store := NewMemoryStore()
+
+		state := SyncState{}
+		if test.state != nil {
+			state["acc_1"] = test.state
+		}
+
+		// Transactions already cached are only counted as updated if they have changed.
+		_, _, err := store.Upsert("acc_1", txs[:test.cached]...)
+		assert.NoError(t, err, test.name)
+
+		syncer := &Syncer{Client: fakeClient(t, api), Store: store, State: state, Window: time.Hour * 36, ExpandMerchants: true,
+			Token: &Token{AuthorisedAt: now}}
+
+		result, err := syncer.SyncAccount("acc_1")
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+
+		if test.sinceWindow {
+			since, err := time.Parse(time.RFC3339, api.requests[0])
+			assert.NoError(t, err, test.name)
+			assert.WithinDuration(t, now.Add(-syncer.Window), since, time.Minute, test.name)
+		} else {
+			assert.Equal(t, test.expectedSince, api.requests[0], test.name)
+		}
+
+		assert.Equal(t, test.expectedCreated, result.Created, test.name)
+		assert.Equal(t, test.expectedUpdated, result.Updated, test.name)
+		assert.Equal(t, "tx_0149", result.LatestTransactionID, test.name)
+
+		stored, err := store.Range("acc_1", StoreQuery{})
+		assert.NoError(t, err, test.name)
+		assert.Len(t, stored, 150, test.name)
+
+		// Re-fetching the trailing window never moves the checkpoint back.
+		assert.Equal(t, "tx_0149", state["acc_1"].LatestTransactionID, test.name)
+		assert.Equal(t, txs[149].CreatedTime(), state["acc_1"].LatestTransactionCreated, test.name)
+		assert.Equal(t, result.Created, state["acc_1"].LastCreated, test.name)
+		assert.False(t, state["acc_1"].LastSynced.IsZero(), test.name)
+
+		if test.state == nil {
+			assert.Equal(t, txs[0].CreatedTime(), state["acc_1"].EarliestTransactionCreated, test.name)
+		}
+	}
+}
+
+func TestSyncerEarliestTransaction(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	txs := history(3, now)
+
+	state := SyncState{"acc_1": {EarliestTransactionCreated: now.Add(-time.Hour * 24 * 30)}}
+	syncer := &Syncer{Client: fakeClient(t, &fakeTransactionsAPI{transactions: txs}), Store: NewMemoryStore(), State: state, ExpandMerchants: true}
+
+	_, err := syncer.SyncAccount("acc_1")
+	assert.NoError(t, err)
+
+	// A later sync does not move the earliest transaction forward.
+	assert.Equal(t, now.Add(-time.Hour*24*30), state["acc_1"].EarliestTransactionCreated)
+}
+
+func TestSyncerFlushResume(t *testing.T) {
+	viper.Set("profile", storeProfile(t))
+	defer viper.Set("profile", nil)
+
+	txs := history(SyncFlushPages*monzo.MaxPageLimit+250, time.Now().UTC().Truncate(time.Second))
+
+	// The sync fails part way through, after its first flush.
+	api := &fakeTransactionsAPI{transactions: txs, failAfter: SyncFlushPages + 2}
+	caches := &Caches{}
+
+	store, err := caches.Store()
+	assert.NoError(t, err)
+
+	flushes := 0
+	syncer := &Syncer{Client: fakeClient(t, api), Store: store, State: caches.SyncState(), Window: DefaultSyncWindow, ExpandMerchants: true,
+		Flush: func() error { flushes++; return caches.Flush() }}
+
+	_, err = syncer.SyncAccount("acc_1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, flushes)
+
+	// A new process resumes from the checkpoint saved by the flush, not where the failed sync got to.
+	lastFlushed := txs[SyncFlushPages*monzo.MaxPageLimit-1]
+
+	api = &fakeTransactionsAPI{transactions: txs}
+	caches = &Caches{}
+
+	store, err = caches.Store()
+	assert.NoError(t, err)
+
+	state := caches.SyncState()
+	assert.Equal(t, lastFlushed.ID, state["acc_1"].LatestTransactionID)
+
+	syncer = &Syncer{Client: fakeClient(t, api), Store: store, State: state, Window: time.Hour, ExpandMerchants: true}
+
+	result, err := syncer.SyncAccount("acc_1")
+	assert.NoError(t, err)
+	assert.Equal(t, lastFlushed.ID, api.requests[0])
+	assert.Equal(t, 250, result.Created)
+	assert.Equal(t, txs[len(txs)-1].ID, result.LatestTransactionID)
+
+	stored, err := store.Range("acc_1", StoreQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, stored, len(txs))
+}
+
+func TestSyncerCoverage(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	day := time.Hour * 24
+	ago := func(days int) time.Time { return now.Add(-day * time.Duration(days)) }
+
+	// One transaction a day for the last 200 days, so tx_N was created 200-N days ago.
+	txs := []monzo.Transaction{}
+	for i := 0; i < 200; i++ {
+		txs = append(txs, transaction(fmt.Sprintf("tx_%03d", i), ago(200-i), -100))
+	}
+
+	tests := []struct {
+		name          string
+		token         *Token
+		state         AccountSyncState
+		failAfter     int
+		expectedSince string
+		expectedFrom  time.Time
+		expectedUntil time.Time
+	}{
+		{
+			name:         "first sync in the full history window",
+			token:        &Token{AuthorisedAt: now},
+			expectedFrom: time.Time{}, expectedUntil: now,
+		},
+		{
+			name:          "first sync after the full history window",
+			expectedSince: ago(90).Format(time.RFC3339),
+			expectedFrom:  ago(90), expectedUntil: now,
+		},
+		{
+			name:          "interrupted first sync",
+			token:         &Token{AuthorisedAt: now},
+			failAfter:     1,
+			expectedFrom:  time.Time{},
+			expectedUntil: ago(101),
+		},
+		{
+			name:          "resumed sync",
+			state:         AccountSyncState{LatestTransactionID: "tx_170", LatestTransactionCreated: ago(30), CoveredFrom: ago(150), CoveredUntil: ago(30)},
+			expectedSince: "tx_170",
+			expectedFrom:  ago(150), expectedUntil: now,
+		},
+		{
+			name:          "resumed interrupted first sync",
+			state:         AccountSyncState{LatestTransactionID: "tx_170", LatestTransactionCreated: ago(30), CoveredUntil: ago(30)},
+			expectedSince: "tx_170",
+			expectedFrom:  time.Time{}, expectedUntil: now,
+		},
+		{
+			name:          "resumed sync with a checkpoint after the covered range",
+			state:         AccountSyncState{LatestTransactionID: "tx_170", LatestTransactionCreated: ago(30), CoveredFrom: ago(150), CoveredUntil: ago(31)},
+			expectedSince: "tx_170",
+			expectedFrom:  ago(150), expectedUntil: now,
+		},
+		{
+			name:          "resumed sync beyond the fetchable history",
+			state:         AccountSyncState{LatestTransactionID: "tx_080", LatestTransactionCreated: ago(120), CoveredFrom: ago(150), CoveredUntil: ago(120)},
+			expectedSince: ago(90).Format(time.RFC3339),
+			expectedFrom:  ago(90), expectedUntil: now,
+		},
+	}
+
+	for _, test := range tests {
+		api := &fakeTransactionsAPI{transactions: txs, failAfter: test.failAfter}
+		state := SyncState{"acc_1": &test.state}
+
+		syncer := &Syncer{Client: fakeClient(t, api), Store: NewMemoryStore(), State: state, Window: DefaultSyncWindow,
+			ExpandMerchants: true, Token: test.token}
+
+		_, err := syncer.SyncAccount("acc_1")
+		assert.Equal(t, test.failAfter != 0, err != nil, test.name)
+
+		// The fetchable history is found from when the sync started, so allow for the time taken to start it.
+		if since, err := time.Parse(time.RFC3339, test.expectedSince); err == nil {
+			requested, err := time.Parse(time.RFC3339, api.requests[0])
+			assert.NoError(t, err, test.name)
+			assert.WithinDuration(t, since, requested, time.Minute, test.name)
+		} else {
+			assert.Equal(t, test.expectedSince, api.requests[0], test.name)
+		}
+
+		assert.WithinDuration(t, test.expectedFrom, test.state.CoveredFrom, time.Minute, test.name)
+		assert.Equal(t, test.expectedFrom.IsZero(), test.state.CoveredFrom.IsZero(), test.name)
+		assert.WithinDuration(t, test.expectedUntil, test.state.CoveredUntil, time.Minute, test.name)
+	}
+}
+
+func TestSyncStateMerge(t *testing.T) {
+	synced := func(id string) *AccountSyncState {
+		return &AccountSyncState{LatestTransactionID: id}
+	}
+
+	tests := []struct {
+		name     string
+		loaded   SyncState
+		changed  SyncState
+		latest   SyncState
+		expected SyncState
+	}{
+		{
+			name:     "unchanged",
+			loaded:   SyncState{"acc_1": synced("tx_1")},
+			changed:  SyncState{"acc_1": synced("tx_1")},
+			latest:   SyncState{"acc_1": synced("tx_2")},
+			expected: SyncState{"acc_1": synced("tx_2")},
+		},
+		{
+			name:     "changed",
+			loaded:   SyncState{"acc_1": synced("tx_1")},
+			changed:  SyncState{"acc_1": synced("tx_3")},
+			latest:   SyncState{"acc_1": synced("tx_2")},
+			expected: SyncState{"acc_1": synced("tx_3")},
+		},
+		{
+			name:     "changed by both",
+			loaded:   SyncState{"acc_1": synced("tx_1"), "acc_2": synced("tx_1")},
+			changed:  SyncState{"acc_1": synced("tx_3"), "acc_2": synced("tx_1")},
+			latest:   SyncState{"acc_1": synced("tx_1"), "acc_2": synced("tx_2")},
+			expected: SyncState{"acc_1": synced("tx_3"), "acc_2": synced("tx_2")},
+		},
+		{
+			name:     "new account",
+			loaded:   SyncState{},
+			changed:  SyncState{"acc_1": synced("tx_1")},
+			latest:   SyncState{"acc_2": synced("tx_1")},
+			expected: SyncState{"acc_1": synced("tx_1"), "acc_2": synced("tx_1")},
+		},
+	}
+
+	for _, test := range tests {
+		saved := test.loaded.snapshot()
+
+		assert.Equal(t, test.name == "unchanged", !test.changed.changedSince(saved), test.name)
+
+		test.latest.merge(test.changed, saved)
+		assert.Equal(t, test.expected, test.latest, test.name)
+	}
+}
+
+func TestSyncerSyncAccounts(t *testing.T) {
+	txs := history(3, time.Now().UTC().Truncate(time.Second))
+
+	tests := []struct {
+		name      string
+		failAfter int
+		failed    int
+	}{
+		{"all synced", 0, 0},
+		{"one failed", 1, 1},
+	}
+
+	for _, test := range tests {
+		api := &fakeTransactionsAPI{transactions: txs, failAfter: test.failAfter}
+		syncer := &Syncer{Client: fakeClient(t, api), Store: NewMemoryStore(), State: SyncState{}, ExpandMerchants: true}
+
+		report, err := syncer.SyncAccounts([]string{"acc_1", "acc_2"})
+		assert.Equal(t, test.failed != 0, errors.Is(err, ErrSyncFailed), test.name)
+
+		failed := 0
+
+		for i, result := range report.Accounts {
+			assert.Equal(t, []string{"acc_1", "acc_2"}[i], result.AccountID, test.name)
+
+			if result.Error != "" {
+				failed++
+				continue
+			}
+
+			assert.Equal(t, txs[2].ID, result.LatestTransactionID, test.name)
+		}
+
+		assert.Equal(t, test.failed, failed, test.name)
+	}
+}
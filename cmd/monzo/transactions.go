@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/arylatt/go-monzo"
+	"github.com/arylatt/go-monzo/filter"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -24,6 +28,8 @@ var (
 		PreRunE: transactionsGetPreRunE,
 		RunE:    transactionsGetRunE,
 		Args:    cobra.MaximumNArgs(1),
+
+		Annotations: map[string]string{annotationOffline: "true"},
 	}
 
 	transactionAnnotate = &cobra.Command{
@@ -40,160 +46,340 @@ var (
 )
 
 func init() {
+	FlagSets["query"] = pflag.NewFlagSet("query", pflag.ContinueOnError)
+	FlagSets["query"].StringP("where", "w", "", "Only return transactions matching the filter expression, e.g. \"amount < -20 and not pending\"")
+	FlagSets["query"].String("sort", "", "Fields to sort transactions by, prefixed with - for descending order, e.g. -amount,created")
+	FlagSets["query"].StringSlice("fields", nil, "Transaction fields to output, in every output format (e.g. id,created,amount,metadata.key)")
+	viper.BindPFlags(FlagSets["query"])
+
 	transactions.PersistentFlags().AddFlagSet(FlagSets["cache"])
 	transactions.PersistentFlags().AddFlagSet(FlagSets["expand"])
 	transactions.PersistentFlags().AddFlagSet(FlagSets["pagination"])
+	transactions.RegisterFlagCompletionFunc("cache", cobra.FixedCompletions(CacheModes, cobra.ShellCompDirectiveNoFileComp))
 
 	root.AddGroup(&cobra.Group{ID: "transactions", Title: "Transactions"})
 	root.AddCommand(transactions)
 
 	transactionsGet.Flags().AddFlagSet(FlagSets["account"])
+	transactionsGet.Flags().AddFlagSet(FlagSets["query"])
+	transactionsGet.RegisterFlagCompletionFunc("account-id", completeAccounts)
+	transactionsGet.ValidArgsFunction = completeFirstArg(completeTransactions)
+	transactionsGet.RegisterFlagCompletionFunc("fields", completeTransactionFields(false))
+	transactionsGet.RegisterFlagCompletionFunc("sort", completeTransactionFields(true))
+
+	transactionAnnotate.ValidArgsFunction = completeFirstArg(completeTransactions)
 
 	transactions.AddCommand(transactionsGet)
 
 	transactions.AddCommand(transactionAnnotate)
 }
 
-type Transactions map[string]*monzo.TransactionList
+// transactionsEqual compares the API data of two transactions, ignoring any unexported state.
+func transactionsEqual(a, b monzo.Transaction) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
 
-func (t Transactions) Save() error {
-	return SaveCache(CacheFileTransactions, t)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
 }
 
-func (t Transactions) Find(accountID, transactionID string) *monzo.TransactionSingle {
-	for acc, txns := range t {
-		if acc != accountID && accountID != "" {
-			continue
-		}
+func transactionsGetPreRunE(cmd *cobra.Command, args []string) error {
+	if _, err := CacheMode(); err != nil {
+		return err
+	}
 
-		for _, tx := range txns.Transactions {
-			if tx.ID == transactionID {
-				return &monzo.TransactionSingle{Transaction: tx}
-			}
+	if _, err := filter.ParseQuery(viper.GetString("where"), viper.GetString("sort")); err != nil {
+		return err
+	}
+
+	return validateFields()
+}
+
+// validateFields checks that each of the fields selected with --fields is a transaction field.
+func validateFields() error {
+	for _, name := range viper.GetStringSlice("fields") {
+		if _, err := filter.FieldKind(name); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (t Transactions) FindMulti(accountID string, page *monzo.Pagination) (filteredTxns *monzo.TransactionList) {
-	filteredTxns = &monzo.TransactionList{Transactions: []monzo.Transaction{}}
+// TransactionFields is a list of transactions output with only the fields selected with --fields.
+type TransactionFields struct {
+	Fields       []string
+	Transactions []monzo.Transaction
+}
 
-	for acc, txns := range t {
-		if acc != accountID {
-			continue
-		}
+// Table returns the table layout of the transactions, with a column per selected field.
+func (f *TransactionFields) Table() *Table {
+	table := &Table{Defaults: f.Fields, Rows: rowsOf(f.Transactions)}
 
-		if page == nil {
-			filteredTxns.Transactions = txns.Transactions
-			return
-		}
+	for _, name := range f.Fields {
+		name := name
 
-		for _, tx := range txns.Transactions {
-			if page.Limit != 0 && len(filteredTxns.Transactions) == page.Limit {
-				return
-			}
+		table.Columns = append(table.Columns, Column{name, func(r any) string {
+			text, _ := filter.Text(r.(monzo.Transaction), name)
+			return text
+		}})
+	}
 
-			if tx.CreatedTime().After(page.SinceTime()) && tx.CreatedTime().Before(page.BeforeTime()) {
-				filteredTxns.Transactions = append(filteredTxns.Transactions, tx)
-			}
+	return table
+}
+
+// MarshalJSON outputs the selected fields of each transaction, in the order they were selected. Amounts are in minor
+// units, as they are in the API.
+func (f *TransactionFields) MarshalJSON() ([]byte, error) {
+	rows := []json.RawMessage{}
+
+	for _, tx := range f.Transactions {
+		row, err := marshalFields(tx, f.Fields)
+		if err != nil {
+			return nil, err
 		}
 
-		return
+		rows = append(rows, row)
 	}
 
-	return
+	return json.Marshal(map[string]any{"transactions": rows})
 }
 
-func (t Transactions) Upsert(accountID string, transaction monzo.Transaction) {
-	defer t.Save()
+// marshalFields outputs the selected fields of the transaction as a JSON object, in the order they were selected.
+func marshalFields(tx monzo.Transaction, fields []string) (json.RawMessage, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
 
-	if t[accountID] == nil {
-		t[accountID] = &monzo.TransactionList{
-			Transactions: []monzo.Transaction{
-				transaction,
-			},
+	for n, name := range fields {
+		value, err := filter.Value(tx, name)
+		if err != nil {
+			return nil, err
 		}
 
-		return
-	}
+		if t, ok := value.(time.Time); ok && t.IsZero() {
+			value = nil
+		}
 
-	for i, tx := range t[accountID].Transactions {
-		if tx.ID == transaction.ID {
-			t[accountID].Transactions[i] = transaction
-			return
+		key, _ := json.Marshal(name)
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
 		}
+
+		if n != 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
 	}
 
-	t[accountID].Transactions = append(t[accountID].Transactions, transaction)
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
 }
 
-func (t Transactions) UpsertMulti(accountID string, transactions monzo.TransactionList) {
-	for _, tx := range transactions.Transactions {
-		t.Upsert(accountID, tx)
+// outputTransactions outputs a transaction list or single transaction, with only the fields selected with --fields if
+// any are.
+func outputTransactions(cmd *cobra.Command, v any) error {
+	fields := viper.GetStringSlice("fields")
+	if len(fields) == 0 {
+		return Output(cmd, v)
+	}
+
+	selected := &TransactionFields{Fields: fields}
+
+	switch v := v.(type) {
+	case *monzo.TransactionList:
+		selected.Transactions = v.Transactions
+	case *monzo.TransactionSingle:
+		selected.Transactions = []monzo.Transaction{v.Transaction}
 	}
+
+	return Output(cmd, selected)
 }
 
-func transactionsGetPreRunE(cmd *cobra.Command, args []string) error {
-	if len(args) == 0 && viper.GetString("account-id") == "" {
-		return errors.New("--account-id flag is required to list transactions")
+// transactionsQuery returns the query selected with --where and --sort, or nil if neither is set. The query takes
+// over the page's limit, so that it applies to the matching transactions rather than the transactions fetched.
+func transactionsQuery(page *monzo.Pagination) (*filter.Query, *monzo.Pagination, error) {
+	if viper.GetString("where") == "" && viper.GetString("sort") == "" {
+		return nil, page, nil
 	}
 
-	return nil
+	q, err := filter.ParseQuery(viper.GetString("where"), viper.GetString("sort"))
+	if err != nil || page == nil {
+		return q, page, err
+	}
+
+	q.Limit = page.Limit
+
+	unlimited := *page
+	unlimited.Limit = 0
+
+	return q, &unlimited, nil
+}
+
+// completeTransactionFields completes a comma-separated list of transaction fields. If descending is set, fields
+// prefixed with - are completed too, for --sort.
+func completeTransactionFields(descending bool) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		prefix := toComplete[:strings.LastIndex(toComplete, ",")+1]
+
+		completions := []string{}
+		for _, name := range filter.Fields() {
+			completions = append(completions, prefix+name)
+
+			if descending {
+				completions = append(completions, prefix+"-"+name)
+			}
+		}
+
+		return filterCompletions(completions, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
 }
 
 func transactionsGetRunE(cmd *cobra.Command, args []string) (err error) {
-	transactions := Transactions{}
-	LoadCache(CacheFileTransactions, &transactions)
+	mode, _ := CacheMode()
+	accountID, expandMerchants := "", viper.GetBool("expand-merchants")
+
+	if len(args) != 0 {
+		if ref := viper.GetString("account-id"); ref != "" {
+			if accountID, err = ResolveAccountRef(ref); err != nil {
+				return
+			}
+		}
+
+		return transactionsGetSingle(cmd, mode, accountID, args[0], expandMerchants)
+	}
 
-	accountID, expandMerchants, noCache := viper.GetString("account-id"), viper.GetBool("expand-merchants"), viper.GetBool("no-cache")
-	page := BuildPagination()
+	if accountID, err = ResolveAccount(cmd); err != nil {
+		return
+	}
+
+	filters, page, err := transactionsQuery(BuildPagination())
+	if err != nil {
+		return
+	}
+
+	if mode == CacheModeOff {
+		if filters == nil {
+			txns, err := _client.Transactions.List(accountID, expandMerchants, page)
+			if err != nil {
+				return err
+			}
+
+			return outputTransactions(cmd, txns)
+		}
+
+		txns := &monzo.TransactionList{}
+
+		err = _client.Transactions.ListPages(accountID, expandMerchants, page, func(list *monzo.TransactionList) error {
+			txns.Transactions = append(txns.Transactions, list.Transactions...)
+			return nil
+		})
 
-	if len(args) == 0 && (len(transactions) == 0 || noCache) {
-		liveTxs, err := _client.Transactions.List(accountID, expandMerchants, page)
 		if err != nil {
-			return err
+			return
 		}
 
-		transactions.UpsertMulti(accountID, *liveTxs)
+		return outputTransactions(cmd, &monzo.TransactionList{Transactions: filters.Apply(txns.Transactions)})
+	}
+
+	store, err := openCaches.Store()
+	if err != nil {
+		return
 	}
 
-	if len(args) == 0 {
-		data, err := json.MarshalIndent(transactions.FindMulti(accountID, page), "", "  ")
+	query, err := storeQuery(store, page)
+	if err != nil {
+		return
+	}
+
+	if err = ensureCached(cmd, mode, store, accountID, expandMerchants, page, query); err != nil {
+		return
+	}
+
+	txns, err := store.Range(accountID, query)
+	if err != nil {
+		return
+	}
+
+	if filters != nil {
+		txns = filters.Apply(txns)
+	}
+
+	return outputTransactions(cmd, &monzo.TransactionList{Transactions: txns})
+}
+
+// transactionsGetSingle gets a transaction by ID, from the cache if it has been cached (unless refreshing) or the API.
+func transactionsGetSingle(cmd *cobra.Command, mode, accountID, transactionID string, expandMerchants bool) (err error) {
+	if mode == CacheModeOff {
+		tx, err := _client.Transactions.Get(transactionID, expandMerchants)
 		if err != nil {
 			return err
 		}
 
-		fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
-		return nil
+		return outputTransactions(cmd, tx)
 	}
 
-	if len(transactions) == 0 || noCache {
-		tx, err := _client.Transactions.Get(args[0], expandMerchants)
+	store, err := openCaches.Store()
+	if err != nil {
+		return
+	}
+
+	if mode != CacheModeRefresh {
+		tx, err := store.Get(transactionID)
 		if err != nil {
 			return err
 		}
 
-		data, err := json.MarshalIndent(tx, "", "  ")
-		if err != nil {
-			return err
+		if tx != nil && (accountID == "" || tx.AccountID == accountID) {
+			return outputTransactions(cmd, &monzo.TransactionSingle{Transaction: *tx})
+		}
+
+		if mode == CacheModeOnly {
+			return fmt.Errorf("%w '%s'", ErrTransactionNotCached, transactionID)
 		}
+	}
+
+	tx, err := _client.Transactions.Get(transactionID, expandMerchants)
+	if err != nil {
+		return
+	}
 
-		fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+	if _, _, err = store.Upsert(tx.Transaction.AccountID, tx.Transaction); err != nil {
+		return
+	}
 
-		transactions.Upsert(tx.Transaction.AccountID, tx.Transaction)
+	return outputTransactions(cmd, tx)
+}
 
-		return nil
+// storeQuery converts pagination flags into a store query. As with the API, since may be a transaction ID, in which
+// case only transactions created after it are returned.
+func storeQuery(store Store, page *monzo.Pagination) (query StoreQuery, err error) {
+	if page == nil {
+		return
 	}
 
-	txSingle := transactions.Find(accountID, args[0])
+	query.Limit = page.Limit
 
-	data, err := json.MarshalIndent(txSingle, "", "  ")
-	if err != nil {
-		return err
+	if page.Before != "" {
+		query.Before = page.BeforeTime()
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+	if page.Since == "" {
+		return
+	}
+
+	if query.Since = page.SinceTime(); query.Since.IsZero() {
+		tx, err := store.Get(page.Since)
+		if err != nil || tx == nil {
+			return query, err
+		}
+
+		query.Since = tx.CreatedTime().Add(time.Nanosecond)
+	}
 
 	return
 }
@@ -207,9 +393,6 @@ func transactionAnnotatePreRunE(cmd *cobra.Command, args []string) (err error) {
 }
 
 func transactionAnnotateRunE(cmd *cobra.Command, args []string) (err error) {
-	transactions := Transactions{}
-	LoadCache(CacheFileTransactions, &transactions)
-
 	metadata := map[string]string{}
 
 	for _, argPairStr := range args[1:] {
@@ -226,14 +409,16 @@ func transactionAnnotateRunE(cmd *cobra.Command, args []string) (err error) {
 		return
 	}
 
-	transactions.Upsert(tx.Transaction.AccountID, tx.Transaction)
+	if mode, _ := CacheMode(); mode != CacheModeOff {
+		store, err := openCaches.Store()
+		if err != nil {
+			return err
+		}
 
-	data, err := json.MarshalIndent(tx, "", "  ")
-	if err != nil {
-		return err
+		if _, _, err = store.Upsert(tx.Transaction.AccountID, tx.Transaction); err != nil {
+			return err
+		}
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
-
-	return
+	return Output(cmd, tx)
 }
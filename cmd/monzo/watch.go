@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/arylatt/go-monzo/filter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	transactionsWatch = &cobra.Command{
+		Use:     "watch",
+		Short:   "Poll an account for new and updated transactions, and output each change as it happens",
+		PreRunE: transactionsWatchPreRunE,
+		RunE:    transactionsWatchRunE,
+		Args:    cobra.NoArgs,
+	}
+
+	ErrWatchIntervalInvalid = errors.New("--interval must be at least 1s")
+
+	ErrWatchFlagUnsupported = errors.New("cannot be used with transactions watch")
+
+	ErrWatchCacheOnly = errors.New("--cache only cannot be used with transactions watch, which polls the Monzo API")
+
+	// watchIgnoredFlags are the flags inherited from the transactions command that watch does not use.
+	watchIgnoredFlags = []string{"since", "before", "limit", "cache-ttl"}
+)
+
+func init() {
+	FlagSets["watch"] = pflag.NewFlagSet("watch", pflag.ContinueOnError)
+	FlagSets["watch"].Duration("interval", monzo.DefaultWatchInterval, "How often to poll for transactions")
+	FlagSets["watch"].Duration("update-window", monzo.DefaultWatchWindow, "How long after they are created transactions are re-fetched to pick up updates, such as notes (unsettled transactions are re-fetched until they settle)")
+	viper.BindPFlags(FlagSets["watch"])
+
+	transactionsWatch.Flags().AddFlagSet(FlagSets["watch"])
+	transactionsWatch.Flags().AddFlagSet(FlagSets["account"])
+	transactionsWatch.Flags().AddFlag(FlagSets["query"].Lookup("where"))
+	transactionsWatch.Flags().AddFlag(FlagSets["query"].Lookup("fields"))
+
+	transactionsWatch.RegisterFlagCompletionFunc("account-id", completeAccounts)
+	transactionsWatch.RegisterFlagCompletionFunc("fields", completeTransactionFields(false))
+
+	transactions.AddCommand(transactionsWatch)
+}
+
+// TransactionWatchEvent is a transaction event output by transactions watch, with only the transaction fields selected
+// with --fields if any are.
+type TransactionWatchEvent struct {
+	monzo.TransactionEvent
+
+	Fields []string
+}
+
+var watchColumns = []Column{
+	{"event", func(r any) string { return string(r.(TransactionWatchEvent).Type) }},
+	{"changes", func(r any) string { return strings.Join(r.(TransactionWatchEvent).Changes, ",") }},
+}
+
+// Table returns the table layout of the event: its type and changes, followed by the transaction's columns.
+func (e *TransactionWatchEvent) Table() *Table {
+	inner := TableFor(&monzo.TransactionSingle{Transaction: e.Transaction})
+	if len(e.Fields) != 0 {
+		inner = TableFor(&TransactionFields{Fields: e.Fields, Transactions: []monzo.Transaction{e.Transaction}})
+	}
+
+	table := &Table{Columns: append([]Column{}, watchColumns...), Defaults: append([]string{"event", "changes"}, inner.Defaults...), Rows: []any{*e}}
+
+	for _, col := range inner.Columns {
+		col := col
+
+		table.Columns = append(table.Columns, Column{col.Name, func(r any) string { return col.Value(r.(TransactionWatchEvent).Transaction) }})
+	}
+
+	return table
+}
+
+// MarshalJSON outputs the event, with only the selected fields of the transaction (and not the previous version of it)
+// if fields are selected.
+func (e *TransactionWatchEvent) MarshalJSON() ([]byte, error) {
+	if len(e.Fields) == 0 {
+		return json.Marshal(e.TransactionEvent)
+	}
+
+	tx, err := marshalFields(e.Transaction, e.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Type        monzo.TransactionEventType `json:"type"`
+		Changes     []string                   `json:"changes,omitempty"`
+		Transaction json.RawMessage            `json:"transaction"`
+	}{e.Type, e.Changes, tx})
+}
+
+func transactionsWatchPreRunE(cmd *cobra.Command, args []string) error {
+	if viper.GetDuration("interval") < time.Second {
+		return ErrWatchIntervalInvalid
+	}
+
+	for _, name := range watchIgnoredFlags {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return fmt.Errorf("--%s %w", name, ErrWatchFlagUnsupported)
+		}
+	}
+
+	mode, err := CacheMode()
+	if err != nil {
+		return err
+	}
+
+	if mode == CacheModeOnly {
+		return ErrWatchCacheOnly
+	}
+
+	if _, err := filter.Parse(viper.GetString("where")); err != nil {
+		return err
+	}
+
+	return validateFields()
+}
+
+func transactionsWatchRunE(cmd *cobra.Command, args []string) (err error) {
+	accountID, err := ResolveAccount(cmd)
+	if err != nil {
+		return
+	}
+
+	where, err := filter.Parse(viper.GetString("where"))
+	if err != nil {
+		return
+	}
+
+	opts := monzo.WatchOptions{
+		Interval:       viper.GetDuration("interval"),
+		Window:         viper.GetDuration("update-window"),
+		ExpandMerchant: viper.GetBool("expand-merchants"),
+	}
+
+	// Unless caching is off, changes are found by comparing with the transaction store, which is kept up to date with
+	// each change. Otherwise, only changes after the watch starts are output.
+	var store Store
+
+	if mode, _ := CacheMode(); mode != CacheModeOff {
+		if store, err = openCaches.Store(); err != nil {
+			return
+		}
+
+		if opts.Known, err = store.Range(accountID, StoreQuery{Since: time.Now().Add(-monzo.MaxPendingAge)}); err != nil {
+			return
+		}
+
+		if opts.Known == nil {
+			opts.Known = []monzo.Transaction{}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream := NewStream(cmd)
+
+	for event := range _client.Transactions.WatchWithOptions(ctx, accountID, opts) {
+		if event.Type == monzo.TransactionError {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to fetch transactions - %s\n", event.Err.Error())
+			continue
+		}
+
+		if store != nil {
+			if _, _, err = store.Upsert(accountID, event.Transaction); err != nil {
+				return
+			}
+
+			if err = openCaches.Flush(); err != nil {
+				return
+			}
+		}
+
+		if !where.Match(event.Transaction) {
+			continue
+		}
+
+		if err = stream.Write(&TransactionWatchEvent{TransactionEvent: event, Fields: viper.GetStringSlice("fields")}); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/arylatt/go-monzo"
 	"github.com/spf13/cobra"
 )
 
@@ -12,23 +13,72 @@ var whoami = &cobra.Command{
 	Short:   "Check auth status",
 	GroupID: "auth",
 	RunE:    whoamiRunE,
+
+	Annotations: map[string]string{annotationOffline: "true"},
 }
 
 func init() {
 	root.AddCommand(whoami)
 }
 
+// WhoamiStatus extends the API's whoami response with details of the cached token.
+type WhoamiStatus struct {
+	*monzo.Whoami
+
+	ClientType string     `json:"client_type"`
+	Expiry     *time.Time `json:"expiry,omitempty"`
+	Authorised bool       `json:"authorised"`
+}
+
 func whoamiRunE(cmd *cobra.Command, args []string) (err error) {
+	if Offline() {
+		return whoamiOffline(cmd)
+	}
+
 	who, err := _client.Whoami()
 	if err != nil {
 		return
 	}
 
-	data, err := json.MarshalIndent(who, "", "  ")
+	token, _ := LoadToken(CurrentProfile())
+
+	if !token.Authorised {
+		if _, err := _client.Accounts.List(); err == nil {
+			token.Authorised = true
+
+			if err := token.Save(); err != nil {
+				return fmt.Errorf("failed to save token - %w", err)
+			}
+		}
+	}
+
+	return Output(cmd, NewWhoamiStatus(who, token))
+}
+
+// NewWhoamiStatus combines the whoami response (which may be nil) with details of the cached token.
+func NewWhoamiStatus(who *monzo.Whoami, token *Token) *WhoamiStatus {
+	status := &WhoamiStatus{
+		Whoami:     who,
+		ClientType: token.ClientType(),
+		Authorised: token.Authorised,
+	}
+
+	if token.Token != nil && !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		status.Expiry = &expiry
+	}
+
+	return status
+}
+
+// whoamiOffline outputs the details of the cached token, without checking it with the API.
+func whoamiOffline(cmd *cobra.Command) (err error) {
+	token, err := LoadToken(CurrentProfile())
 	if err != nil {
-		return
+		return fmt.Errorf("not authenticated - %w", err)
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
-	return
+	fmt.Fprintln(cmd.ErrOrStderr(), "Offline: the token has not been checked with the Monzo API")
+
+	return Output(cmd, NewWhoamiStatus(nil, token))
 }
@@ -2,16 +2,38 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/arylatt/go-monzo"
 )
 
+// DefaultPort is the port the webhook server listens on, unless overridden with -port or MONZO_WEBHOOK_PORT.
+const DefaultPort = 54093
+
 func main() {
+	port := DefaultPort
+
+	if env := os.Getenv("MONZO_WEBHOOK_PORT"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: MONZO_WEBHOOK_PORT '%s' is not a valid port number\n", env)
+			os.Exit(1)
+		}
+
+		port = p
+	}
+
+	host := flag.String("host", "", "Host/IP address to listen on (default: all interfaces)")
+	flag.IntVar(&port, "port", port, "Port to listen on (env: MONZO_WEBHOOK_PORT)")
+	flag.Parse()
+
 	srv := &http.Server{
-		Addr: ":54093",
+		Addr: net.JoinHostPort(*host, strconv.Itoa(port)),
 		Handler: http.HandlerFunc(monzo.WebhookPayloadHandler(func(rw http.ResponseWriter, r *http.Request, payload *monzo.WebhookPayload) {
 			rw.WriteHeader(http.StatusOK)
 
@@ -0,0 +1,19 @@
+package monzo
+
+import "strings"
+
+// CurrencyExponents lists the currencies that do not have a minor unit, or have a minor unit other than 1/100th, by
+// ISO 4217 code. Amounts provided by the Monzo API are in minor units of the currency.
+var CurrencyExponents = map[string]int{
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "VND": 0,
+}
+
+// CurrencyExponent returns the number of decimal places in the currency's minor unit, e.g. 2 for GBP (pennies).
+func CurrencyExponent(currency string) int {
+	if exp, ok := CurrencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+
+	return 2
+}
@@ -0,0 +1,24 @@
+package monzo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencyExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		expected int
+	}{
+		{"GBP", 2},
+		{"eur", 2},
+		{"", 2},
+		{"JPY", 0},
+		{"kwd", 3},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, CurrencyExponent(test.currency), test.currency)
+	}
+}
@@ -3,10 +3,17 @@ package monzo
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 )
 
+const (
+	// ErrorCodeInsufficientPermissions is returned by the Monzo API when the access token has not been granted access to the
+	// user's data, e.g. while the user has not yet approved the client in the Monzo app (Strong Customer Authentication).
+	ErrorCodeInsufficientPermissions = "forbidden.insufficient_permissions"
+)
+
 type Error struct {
 	Response *http.Response
 
@@ -21,6 +28,10 @@ func (e *Error) Error() string {
 }
 
 func CheckResponse(r *http.Response) (err error) {
+	if r == nil {
+		return nil
+	}
+
 	if c := r.StatusCode; 200 >= c && c <= 299 {
 		return nil
 	}
@@ -36,3 +47,18 @@ func CheckResponse(r *http.Response) (err error) {
 
 	return
 }
+
+// IsInsufficientPermissions reports whether err is a Monzo API error caused by the access token not having been granted
+// access to the user's data (yet).
+func IsInsufficientPermissions(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	if apiErr.Code == ErrorCodeInsufficientPermissions {
+		return true
+	}
+
+	return apiErr.Code == "" && apiErr.Response != nil && apiErr.Response.StatusCode == http.StatusForbidden
+}
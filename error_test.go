@@ -0,0 +1,47 @@
+package monzo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResponseNil(t *testing.T) {
+	assert.NoError(t, CheckResponse(nil))
+}
+
+func TestIsInsufficientPermissions(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("test"), false},
+		{&Error{Code: ErrorCodeInsufficientPermissions}, true},
+		{fmt.Errorf("wrapped: %w", &Error{Code: ErrorCodeInsufficientPermissions}), true},
+		{&Error{Code: "unauthorized.bad_access_token"}, false},
+		{&Error{Response: &http.Response{StatusCode: http.StatusForbidden}}, true},
+		{&Error{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, IsInsufficientPermissions(test.err), test.err)
+	}
+}
+
+func TestIsInsufficientPermissionsResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Body:       io.NopCloser(strings.NewReader(`{"code":"forbidden.insufficient_permissions","message":"Access forbidden due to insufficient permissions"}`)),
+	}
+
+	err := CheckResponse(resp)
+
+	assert.True(t, IsInsufficientPermissions(err))
+	assert.Equal(t, "Access forbidden due to insufficient permissions", err.Error())
+}
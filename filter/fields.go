@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+// Kind is the type of a transaction field's values.
+type Kind int
+
+const (
+	// KindString fields are compared case-insensitively, and can be matched with regular expressions.
+	KindString Kind = iota
+
+	// KindAmount fields are amounts in minor units, which are compared with decimal values in major units (e.g. 12.34).
+	KindAmount
+
+	// KindBool fields are true or false.
+	KindBool
+
+	// KindTime fields are compared with dates (YYYY-MM-DD, in the local time zone) or RFC3339 date/times.
+	KindTime
+)
+
+// metadataPrefix is the prefix of the fields that select a metadata key, e.g. metadata.receipt_id.
+const metadataPrefix = "metadata."
+
+var (
+	// ErrFieldUnknown is returned when an expression, sort or selection refers to a field that does not exist.
+	ErrFieldUnknown = errors.New("field unknown")
+)
+
+// field describes a transaction field that can be filtered, sorted and selected.
+type field struct {
+	kind Kind
+
+	// value returns the field's value: a string, an int64 amount in minor units, a bool or a time.Time.
+	value func(tx monzo.Transaction) any
+
+	// currency returns the currency of an amount field.
+	currency func(tx monzo.Transaction) string
+}
+
+var fields = map[string]field{
+	"id":             stringField(func(tx monzo.Transaction) string { return tx.ID }),
+	"account":        stringField(func(tx monzo.Transaction) string { return tx.AccountID }),
+	"description":    stringField(func(tx monzo.Transaction) string { return tx.Description }),
+	"category":       stringField(func(tx monzo.Transaction) string { return tx.Category }),
+	"merchant":       stringField(func(tx monzo.Transaction) string { return tx.Merchant.Name }),
+	"merchant_id":    stringField(func(tx monzo.Transaction) string { return tx.Merchant.ID }),
+	"merchant_group": stringField(func(tx monzo.Transaction) string { return tx.Merchant.GroupID }),
+	"counterparty": stringField(func(tx monzo.Transaction) string {
+		c := tx.CounterpartyDetails()
+		if c.Name != "" {
+			return c.Name
+		}
+
+		return c.PreferredName
+	}),
+	"notes":          stringField(func(tx monzo.Transaction) string { return tx.Notes }),
+	"currency":       stringField(func(tx monzo.Transaction) string { return tx.Currency }),
+	"local_currency": stringField(func(tx monzo.Transaction) string { return tx.LocalCurrency }),
+	"scheme":         stringField(func(tx monzo.Transaction) string { return tx.Scheme }),
+	"decline_reason": stringField(func(tx monzo.Transaction) string { return tx.DeclineReason }),
+	"pot":            stringField(func(tx monzo.Transaction) string { return tx.PotID() }),
+
+	"amount": {
+		kind:     KindAmount,
+		value:    func(tx monzo.Transaction) any { return tx.Amount },
+		currency: func(tx monzo.Transaction) string { return tx.Currency },
+	},
+	"local_amount": {
+		kind:     KindAmount,
+		value:    func(tx monzo.Transaction) any { return tx.LocalAmount },
+		currency: func(tx monzo.Transaction) string { return tx.LocalCurrency },
+	},
+
+	"settled":             boolField(func(tx monzo.Transaction) bool { return tx.Settled != "" }),
+	"pending":             boolField(func(tx monzo.Transaction) bool { return tx.AmountIsPending }),
+	"declined":            boolField(func(tx monzo.Transaction) bool { return tx.Declined() }),
+	"load":                boolField(func(tx monzo.Transaction) bool { return tx.IsLoad }),
+	"include_in_spending": boolField(func(tx monzo.Transaction) bool { return tx.IncludeInSpending }),
+	"online":              boolField(func(tx monzo.Transaction) bool { return tx.Merchant.Online }),
+	"atm":                 boolField(func(tx monzo.Transaction) bool { return tx.Merchant.ATM }),
+
+	"created":    timeField(func(tx monzo.Transaction) time.Time { return tx.CreatedTime() }),
+	"updated":    timeField(func(tx monzo.Transaction) time.Time { return tx.UpdatedTime() }),
+	"settled_at": timeField(func(tx monzo.Transaction) time.Time { return tx.SettledTime() }),
+}
+
+func stringField(get func(tx monzo.Transaction) string) field {
+	return field{kind: KindString, value: func(tx monzo.Transaction) any { return get(tx) }}
+}
+
+func boolField(get func(tx monzo.Transaction) bool) field {
+	return field{kind: KindBool, value: func(tx monzo.Transaction) any { return get(tx) }}
+}
+
+func timeField(get func(tx monzo.Transaction) time.Time) field {
+	return field{kind: KindTime, value: func(tx monzo.Transaction) any { return get(tx) }}
+}
+
+// lookup returns the named field. Fields starting with metadata. select the value of a metadata key.
+func lookup(name string) (field, error) {
+	if f, ok := fields[name]; ok {
+		return f, nil
+	}
+
+	if key := strings.TrimPrefix(name, metadataPrefix); key != name && key != "" {
+		return stringField(func(tx monzo.Transaction) string { return tx.Metadata[key] }), nil
+	}
+
+	return field{}, fmt.Errorf("%w '%s'. valid fields [%s, %s<key>]", ErrFieldUnknown, name, strings.Join(Fields(), ", "), metadataPrefix)
+}
+
+// Fields returns the names of the transaction fields, in alphabetical order. Metadata keys are selected with
+// metadata.<key> fields, which are not included.
+func Fields() (names []string) {
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return
+}
+
+// FieldKind returns the kind of the named field.
+func FieldKind(name string) (Kind, error) {
+	f, err := lookup(name)
+	return f.kind, err
+}
+
+// Value returns the value of the named field of the transaction: a string, an int64 amount in minor units, a bool or a
+// time.Time.
+func Value(tx monzo.Transaction, name string) (any, error) {
+	f, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.value(tx), nil
+}
+
+// Text returns the value of the named field of the transaction as text. Amounts are decimals in major units (e.g.
+// -5.10), times are RFC3339 date/times (or empty if they are not set) and bools are true or false.
+func Text(tx monzo.Transaction, name string) (string, error) {
+	f, err := lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := f.value(tx).(type) {
+	case int64:
+		return majorUnits(v, f.currency(tx)).FloatString(monzo.CurrencyExponent(f.currency(tx))), nil
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+
+		return v.Format(time.RFC3339), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		return v.(string), nil
+	}
+}
+
+// Select returns the values of the named fields of the transaction, by name.
+func Select(tx monzo.Transaction, names []string) (map[string]any, error) {
+	values := map[string]any{}
+
+	for _, name := range names {
+		value, err := Value(tx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// majorUnits converts an amount in minor units into major units.
+func majorUnits(amount int64, currency string) *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(amount), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(monzo.CurrencyExponent(currency))), nil))
+}
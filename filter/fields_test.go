@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+	names := Fields()
+
+	assert.Contains(t, names, "amount")
+	assert.Contains(t, names, "merchant")
+	assert.IsIncreasing(t, names)
+}
+
+func TestFieldKind(t *testing.T) {
+	kind, err := FieldKind("amount")
+	assert.NoError(t, err)
+	assert.Equal(t, KindAmount, kind)
+
+	kind, err = FieldKind("metadata.anything")
+	assert.NoError(t, err)
+	assert.Equal(t, KindString, kind)
+
+	_, err = FieldKind("metadata.")
+	assert.ErrorIs(t, err, ErrFieldUnknown)
+}
+
+func TestValue(t *testing.T) {
+	tx := testTransactions[0]
+
+	value, err := Value(tx, "amount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1234), value)
+
+	value, err = Value(tx, "created")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC), value)
+
+	_, err = Value(tx, "nope")
+	assert.ErrorIs(t, err, ErrFieldUnknown)
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		tx       monzo.Transaction
+		field    string
+		expected string
+	}{
+		{testTransactions[0], "amount", "-12.34"},
+		{monzo.Transaction{Amount: 500, Currency: "JPY"}, "amount", "500"},
+		{testTransactions[1], "local_amount", "-6.00"},
+		{testTransactions[0], "settled_at", "2026-01-03T10:00:00Z"},
+		{testTransactions[1], "settled_at", ""},
+		{testTransactions[1], "pending", "true"},
+		{testTransactions[0], "metadata.receipt_id", "r_1"},
+	}
+
+	for _, test := range tests {
+		text, err := Text(test.tx, test.field)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, text, test.field)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	values, err := Select(testTransactions[1], []string{"id", "amount", "pending"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"id": "tx_2", "amount": int64(-500), "pending": true}, values)
+
+	_, err = Select(testTransactions[1], []string{"id", "nope"})
+	assert.ErrorIs(t, err, ErrFieldUnknown)
+}
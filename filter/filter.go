@@ -0,0 +1,551 @@
+// Package filter selects and orders Monzo transactions with a small expression language, e.g.
+//
+//	amount < -20 and category in (groceries, eating_out) and not pending
+//	merchant ~ "^tesco" or notes contains "refund"
+//	created in 2026-01-01..2026-01-31 and metadata.receipt_id
+//
+// Expressions compare transaction fields (see Fields) with values, and are combined with and, or, not and parentheses
+// (&&, || and ! are accepted too). The comparison operators are:
+//
+//	=, !=, <, <=, >, >=  compare the field with a value (strings are compared case-insensitively)
+//	~, !~                match a string field with a regular expression (case-insensitively)
+//	contains             match a string field containing the value (case-insensitively)
+//	in                   match a field equal to one of a list of values, e.g. (a, b), or in an inclusive range, e.g. 1..5
+//
+// Amounts are compared in major units (e.g. 12.34), and are negative for money spent. Times are compared with dates
+// (YYYY-MM-DD, in the local time zone, covering the whole day) or RFC3339 date/times. A field on its own is true if it
+// is set: a true bool, a non-empty string, a non-zero amount or a set time. Values containing spaces or operator
+// characters are quoted with double or single quotes.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+var (
+	// ErrSyntax is returned when an expression cannot be parsed.
+	ErrSyntax = errors.New("filter syntax error")
+
+	// ErrOperatorInvalid is returned when an operator cannot be used with a field, e.g. a regular expression match on
+	// an amount.
+	ErrOperatorInvalid = errors.New("operator invalid for field")
+
+	// ErrValueInvalid is returned when a value cannot be compared with a field, e.g. a word compared with an amount.
+	ErrValueInvalid = errors.New("value invalid for field")
+)
+
+// Expr is a parsed filter expression.
+type Expr interface {
+	// Match reports whether the transaction matches the expression.
+	Match(tx monzo.Transaction) bool
+}
+
+// matchFunc is an expression implemented by a function.
+type matchFunc func(tx monzo.Transaction) bool
+
+func (f matchFunc) Match(tx monzo.Transaction) bool {
+	return f(tx)
+}
+
+// All is an expression that matches every transaction. It is the result of parsing an empty expression.
+var All Expr = matchFunc(func(monzo.Transaction) bool { return true })
+
+// Parse parses a filter expression. An empty expression matches every transaction.
+func Parse(expression string) (Expr, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return All, nil
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected '%s'", tok.text)
+	}
+
+	return expr, nil
+}
+
+// MustParse parses a filter expression, and panics if it is invalid. It simplifies initialising variables with fixed
+// expressions.
+func MustParse(expression string) Expr {
+	expr, err := Parse(expression)
+	if err != nil {
+		panic(err)
+	}
+
+	return expr
+}
+
+// Apply returns the transactions that match the expression, in the same order.
+func Apply(txs []monzo.Transaction, expr Expr) []monzo.Transaction {
+	matched := []monzo.Transaction{}
+
+	for _, tx := range txs {
+		if expr.Match(tx) {
+			matched = append(matched, tx)
+		}
+	}
+
+	return matched
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators are the symbolic operators, longest first so that they are matched greedily.
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "=", "<", ">", "~", "!"}
+
+// wordBreaks are the characters that end a bare word.
+const wordBreaks = "()=!<>~,\"'&|"
+
+func lex(s string) (tokens []token, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+			continue
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+			continue
+		case c == '"' || c == '\'':
+			b := &strings.Builder{}
+			j := i + 1
+
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+
+				b.WriteByte(s[j])
+			}
+
+			if j >= len(s) {
+				return nil, fmt.Errorf("%w at position %d: unterminated string", ErrSyntax, i+1)
+			}
+
+			tokens = append(tokens, token{tokenString, b.String(), i})
+			i = j + 1
+			continue
+		}
+
+		if op := matchOperator(s[i:]); op != "" {
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+			continue
+		}
+
+		j := i
+		for j < len(s) && !strings.ContainsRune(wordBreaks+" \t\r\n", rune(s[j])) {
+			j++
+		}
+
+		if j == i {
+			return nil, fmt.Errorf("%w at position %d: unexpected '%c'", ErrSyntax, i+1, c)
+		}
+
+		tokens = append(tokens, token{tokenWord, s[i:j], i})
+		i = j
+	}
+
+	return
+}
+
+func matchOperator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		end := 0
+		if len(p.tokens) != 0 {
+			last := p.tokens[len(p.tokens)-1]
+			end = last.pos + len(last.text)
+		}
+
+		return token{kind: tokenEOF, text: "end of expression", pos: end}
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.peek()
+	p.pos++
+
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("%w at position %d: %s", ErrSyntax, tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// keyword reports whether the next token is one of the keywords or symbolic operators, and consumes it if it is.
+func (p *parser) keyword(words ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokenWord && tok.kind != tokenOperator {
+		return false
+	}
+
+	for _, word := range words {
+		if strings.EqualFold(tok.text, word) {
+			p.pos++
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or", "||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = matchFunc(func(tx monzo.Transaction) bool { return a.Match(tx) || b.Match(tx) })
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and", "&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = matchFunc(func(tx monzo.Transaction) bool { return a.Match(tx) && b.Match(tx) })
+	}
+
+	return left, nil
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.keyword("not", "!") {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return matchFunc(func(tx monzo.Transaction) bool { return !expr.Match(tx) }), nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, "expected ')', found '%s'", closing.text)
+		}
+
+		return expr, nil
+	case tokenWord:
+	default:
+		return nil, p.errorf(tok, "expected a field, found '%s'", tok.text)
+	}
+
+	f, err := lookup(tok.text)
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+
+	switch {
+	case op.kind == tokenOperator && op.text != "&&" && op.text != "||" && op.text != "!":
+	case op.kind == tokenWord && (strings.EqualFold(op.text, "contains") || strings.EqualFold(op.text, "in")):
+		op.text = strings.ToLower(op.text)
+	default:
+		return matchFunc(func(tx monzo.Transaction) bool { return truthy(f.value(tx)) }), nil
+	}
+
+	p.pos++
+
+	if op.text == "in" {
+		return p.in(tok.text, f)
+	}
+
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, p.errorf(value, "expected a value after '%s', found '%s'", op.text, value.text)
+	}
+
+	return compare(tok.text, f, op.text, value.text)
+}
+
+// in parses the values of an in comparison: a parenthesised list of values, or a range.
+func (p *parser) in(name string, f field) (Expr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenWord, tokenString:
+		low, high, ok := strings.Cut(tok.text, "..")
+		if !ok {
+			return nil, p.errorf(tok, "expected a list of values or a range after 'in', found '%s'", tok.text)
+		}
+
+		lower, err := compare(name, f, ">=", low)
+		if err != nil {
+			return nil, err
+		}
+
+		upper, err := compare(name, f, "<=", high)
+		if err != nil {
+			return nil, err
+		}
+
+		return matchFunc(func(tx monzo.Transaction) bool { return lower.Match(tx) && upper.Match(tx) }), nil
+	case tokenLParen:
+	default:
+		return nil, p.errorf(tok, "expected a list of values or a range after 'in', found '%s'", tok.text)
+	}
+
+	options := []Expr{}
+
+	for {
+		value := p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return nil, p.errorf(value, "expected a value, found '%s'", value.text)
+		}
+
+		option, err := compare(name, f, "=", value.text)
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, option)
+
+		if sep := p.next(); sep.kind == tokenRParen {
+			break
+		} else if sep.kind != tokenComma {
+			return nil, p.errorf(sep, "expected ',' or ')', found '%s'", sep.text)
+		}
+	}
+
+	return matchFunc(func(tx monzo.Transaction) bool {
+		for _, option := range options {
+			if option.Match(tx) {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+// compare returns the expression comparing the field with the value.
+func compare(name string, f field, op, value string) (Expr, error) {
+	invalidOp := fmt.Errorf("%w '%s': %s", ErrOperatorInvalid, name, op)
+	invalidValue := fmt.Errorf("%w '%s': '%s'", ErrValueInvalid, name, value)
+
+	if op == "==" {
+		op = "="
+	}
+
+	switch f.kind {
+	case KindString:
+		switch op {
+		case "~", "!~":
+			re, err := regexp.Compile("(?i)" + value)
+			if err != nil {
+				return nil, fmt.Errorf("%w '%s': %s", ErrValueInvalid, name, err.Error())
+			}
+
+			return matchFunc(func(tx monzo.Transaction) bool {
+				return re.MatchString(f.value(tx).(string)) == (op == "~")
+			}), nil
+		case "contains":
+			value = strings.ToLower(value)
+
+			return matchFunc(func(tx monzo.Transaction) bool {
+				return strings.Contains(strings.ToLower(f.value(tx).(string)), value)
+			}), nil
+		}
+
+		value = strings.ToLower(value)
+
+		return ordered(op, invalidOp, func(tx monzo.Transaction) int {
+			return strings.Compare(strings.ToLower(f.value(tx).(string)), value)
+		})
+	case KindAmount:
+		amount, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return nil, invalidValue
+		}
+
+		return ordered(op, invalidOp, func(tx monzo.Transaction) int {
+			return majorUnits(f.value(tx).(int64), f.currency(tx)).Cmp(amount)
+		})
+	case KindBool:
+		var want bool
+
+		switch strings.ToLower(value) {
+		case "true", "yes":
+			want = true
+		case "false", "no":
+		default:
+			return nil, invalidValue
+		}
+
+		if op != "=" && op != "!=" {
+			return nil, invalidOp
+		}
+
+		return matchFunc(func(tx monzo.Transaction) bool { return (f.value(tx).(bool) == want) == (op == "=") }), nil
+	}
+
+	t, date, err := parseTime(value)
+	if err != nil {
+		return nil, invalidValue
+	}
+
+	// A date covers the whole day, so comparisons with its end are made against the start of the next day.
+	switch {
+	case date && op == "<=":
+		op, t = "<", t.AddDate(0, 0, 1)
+	case date && op == ">":
+		op, t = ">=", t.AddDate(0, 0, 1)
+	case date && (op == "=" || op == "!="):
+		start, end := t, t.AddDate(0, 0, 1)
+
+		return matchFunc(func(tx monzo.Transaction) bool {
+			v := f.value(tx).(time.Time)
+			return (!v.Before(start) && v.Before(end)) == (op == "=")
+		}), nil
+	}
+
+	return ordered(op, invalidOp, func(tx monzo.Transaction) int {
+		v := f.value(tx).(time.Time)
+
+		switch {
+		case v.Before(t):
+			return -1
+		case v.After(t):
+			return 1
+		}
+
+		return 0
+	})
+}
+
+// ordered returns the expression applying an equality or ordering operator to the result of cmp.
+func ordered(op string, invalid error, cmp func(tx monzo.Transaction) int) (Expr, error) {
+	test, ok := map[string]func(int) bool{
+		"=":  func(c int) bool { return c == 0 },
+		"!=": func(c int) bool { return c != 0 },
+		"<":  func(c int) bool { return c < 0 },
+		"<=": func(c int) bool { return c <= 0 },
+		">":  func(c int) bool { return c > 0 },
+		">=": func(c int) bool { return c >= 0 },
+	}[op]
+
+	if !ok {
+		return nil, invalid
+	}
+
+	return matchFunc(func(tx monzo.Transaction) bool { return test(cmp(tx)) }), nil
+}
+
+// parseTime parses a date (in the local time zone) or an RFC3339 date/time, and reports whether it was a date.
+func parseTime(value string) (t time.Time, date bool, err error) {
+	if t, err = time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+
+	t, err = time.Parse(time.RFC3339Nano, value)
+
+	return
+}
+
+// truthy reports whether a field value is set.
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int64:
+		return v != 0
+	case time.Time:
+		return !v.IsZero()
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTransactions = []monzo.Transaction{
+	{
+		ID:                "tx_1",
+		Amount:            -1234,
+		Currency:          "GBP",
+		Category:          "groceries",
+		Created:           "2026-01-02T10:00:00Z",
+		Settled:           "2026-01-03T10:00:00Z",
+		Description:       "TESCO STORES 1234",
+		Merchant:          monzo.Merchant{ID: "merch_1", GroupID: "grp_tesco", Name: "Tesco"},
+		IncludeInSpending: true,
+		Metadata:          map[string]string{"receipt_id": "r_1"},
+	},
+	{
+		ID:                "tx_2",
+		Amount:            -500,
+		Currency:          "GBP",
+		LocalAmount:       -600,
+		LocalCurrency:     "EUR",
+		Category:          "eating_out",
+		Created:           "2026-01-15T12:00:00Z",
+		AmountIsPending:   true,
+		Description:       "CAFE",
+		Merchant:          monzo.Merchant{ID: "merch_2", Name: "Café Rouge"},
+		Notes:             "Lunch with Sam",
+		IncludeInSpending: true,
+	},
+	{
+		ID:           "tx_3",
+		Amount:       150000,
+		Currency:     "GBP",
+		Category:     "income",
+		Created:      "2026-01-31T09:00:00Z",
+		Settled:      "2026-01-31T09:00:00Z",
+		Description:  "SALARY",
+		Counterparty: map[string]interface{}{"name": "ACME Ltd"},
+	},
+	{
+		ID:            "tx_4",
+		Amount:        -999,
+		Currency:      "GBP",
+		Category:      "shopping",
+		Created:       "2026-02-01T09:00:00Z",
+		Description:   "DECLINED",
+		DeclineReason: "INSUFFICIENT_FUNDS",
+	},
+}
+
+func ids(txs []monzo.Transaction) (result []string) {
+	result = []string{}
+
+	for _, tx := range txs {
+		result = append(result, tx.ID)
+	}
+
+	return
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string][]string{
+		"":                                      {"tx_1", "tx_2", "tx_3", "tx_4"},
+		"amount < 0":                            {"tx_1", "tx_2", "tx_4"},
+		"amount <= -12.34":                      {"tx_1"},
+		"amount = -5":                           {"tx_2"},
+		"amount in -10..-5":                     {"tx_2", "tx_4"},
+		"local_amount < -5.50":                  {"tx_2"},
+		"category = GROCERIES":                  {"tx_1"},
+		"category != groceries":                 {"tx_2", "tx_3", "tx_4"},
+		"category in (groceries, 'eating_out')": {"tx_1", "tx_2"},
+		`merchant ~ "^tes"`:                     {"tx_1"},
+		"merchant !~ tesco":                     {"tx_2", "tx_3", "tx_4"},
+		"merchant_group = grp_tesco":            {"tx_1"},
+		`notes contains "with sam"`:             {"tx_2"},
+		"counterparty = 'acme ltd'":             {"tx_3"},
+		"settled":                               {"tx_1", "tx_3"},
+		"not settled":                           {"tx_2", "tx_4"},
+		"pending":                               {"tx_2"},
+		"!pending && !declined":                 {"tx_1", "tx_3"},
+		"declined = true":                       {"tx_4"},
+		"decline_reason = insufficient_funds":   {"tx_4"},
+		"metadata.receipt_id":                   {"tx_1"},
+		"metadata.receipt_id = r_1":             {"tx_1"},
+		"local_currency = eur":                  {"tx_2"},
+		"created = 2026-01-15":                  {"tx_2"},
+		"created <= 2026-01-15":                 {"tx_1", "tx_2"},
+		"created > 2026-01-15":                  {"tx_3", "tx_4"},
+		"created in 2026-01-01..2026-01-31":     {"tx_1", "tx_2", "tx_3"},
+		"created < 2026-01-15T12:00:00Z":        {"tx_1"},
+		"amount < 0 and (category = groceries or pending)":         {"tx_1", "tx_2"},
+		"amount < 0 and category = groceries or pending":           {"tx_1", "tx_2"},
+		"not (amount < 0 || declined)":                             {"tx_3"},
+		"category = groceries or category = shopping and declined": {"tx_1", "tx_4"},
+	}
+
+	for expression, expected := range tests {
+		expr, err := Parse(expression)
+		if assert.NoError(t, err, expression) {
+			assert.Equal(t, expected, ids(Apply(testTransactions, expr)), expression)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]error{
+		"amount <":              ErrSyntax,
+		"(amount < 0":           ErrSyntax,
+		"amount < 0 )":          ErrSyntax,
+		"notes = 'unterminated": ErrSyntax,
+		"category in groceries": ErrSyntax,
+		"category in (a b)":     ErrSyntax,
+		"and":                   ErrFieldUnknown,
+		"colour = red":          ErrFieldUnknown,
+		"amount ~ 5":            ErrOperatorInvalid,
+		"pending < true":        ErrOperatorInvalid,
+		"amount < lots":         ErrValueInvalid,
+		"pending = maybe":       ErrValueInvalid,
+		"created < yesterday":   ErrValueInvalid,
+		"merchant ~ '('":        ErrValueInvalid,
+	}
+
+	for expression, expected := range tests {
+		_, err := Parse(expression)
+		assert.ErrorIs(t, err, expected, expression)
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	assert.NotPanics(t, func() { MustParse("pending") })
+	assert.Panics(t, func() { MustParse("amount <") })
+}
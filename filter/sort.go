@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arylatt/go-monzo"
+)
+
+// SortKey orders transactions by a field.
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// Sort orders transactions by each key in turn.
+type Sort []SortKey
+
+// ParseSort parses a comma-separated list of fields to sort by. Fields prefixed with - are sorted in descending order,
+// e.g. -amount,created.
+func ParseSort(spec string) (s Sort, err error) {
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		key := SortKey{Field: strings.TrimPrefix(name, "+")}
+		if strings.HasPrefix(name, "-") {
+			key = SortKey{Field: name[1:], Descending: true}
+		}
+
+		if _, err = lookup(key.Field); err != nil {
+			return nil, err
+		}
+
+		s = append(s, key)
+	}
+
+	return
+}
+
+// Apply sorts the transactions in place. Transactions that are equal by every key keep their order.
+func (s Sort) Apply(txs []monzo.Transaction) {
+	if len(s) == 0 {
+		return
+	}
+
+	keys := make([]field, len(s))
+	for n, key := range s {
+		keys[n], _ = lookup(key.Field)
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool {
+		for n, key := range s {
+			c := compareValues(keys[n], txs[i], txs[j])
+			if key.Descending {
+				c = -c
+			}
+
+			if c != 0 {
+				return c < 0
+			}
+		}
+
+		return false
+	})
+}
+
+// compareValues compares the field's values for two transactions. Strings are compared case-insensitively, and false
+// is ordered before true.
+func compareValues(f field, a, b monzo.Transaction) int {
+	switch va := f.value(a).(type) {
+	case string:
+		return strings.Compare(strings.ToLower(va), strings.ToLower(f.value(b).(string)))
+	case int64:
+		return majorUnits(va, f.currency(a)).Cmp(majorUnits(f.value(b).(int64), f.currency(b)))
+	case bool:
+		vb := f.value(b).(bool)
+
+		switch {
+		case va == vb:
+			return 0
+		case vb:
+			return -1
+		}
+
+		return 1
+	case time.Time:
+		vb := f.value(b).(time.Time)
+
+		switch {
+		case va.Before(vb):
+			return -1
+		case va.After(vb):
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Query filters, sorts and limits transactions.
+type Query struct {
+	// Where selects the transactions. If it is nil, every transaction is selected.
+	Where Expr
+
+	Sort Sort
+
+	// Limit is the maximum number of transactions to return, after sorting, or 0 for no limit.
+	Limit int
+}
+
+// ParseQuery parses a filter expression and sort specification into a query.
+func ParseQuery(where, sort string) (q *Query, err error) {
+	q = &Query{}
+
+	if q.Where, err = Parse(where); err != nil {
+		return nil, err
+	}
+
+	if q.Sort, err = ParseSort(sort); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Apply returns the transactions selected by the query, in order. The transactions passed in are not modified.
+func (q *Query) Apply(txs []monzo.Transaction) []monzo.Transaction {
+	where := q.Where
+	if where == nil {
+		where = All
+	}
+
+	selected := Apply(txs, where)
+
+	q.Sort.Apply(selected)
+
+	if q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[:q.Limit]
+	}
+
+	return selected
+}
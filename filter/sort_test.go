@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/arylatt/go-monzo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSort(t *testing.T) {
+	s, err := ParseSort("-amount, +created,pending")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Sort{{"amount", true}, {"created", false}, {"pending", false}}, s)
+
+	_, err = ParseSort("-nope")
+	assert.ErrorIs(t, err, ErrFieldUnknown)
+}
+
+func TestSortApply(t *testing.T) {
+	tests := map[string][]string{
+		"":                  {"tx_1", "tx_2", "tx_3", "tx_4"},
+		"amount":            {"tx_1", "tx_4", "tx_2", "tx_3"},
+		"-amount":           {"tx_3", "tx_2", "tx_4", "tx_1"},
+		"-created":          {"tx_4", "tx_3", "tx_2", "tx_1"},
+		"merchant,-amount":  {"tx_3", "tx_4", "tx_2", "tx_1"},
+		"settled,-category": {"tx_4", "tx_2", "tx_3", "tx_1"},
+	}
+
+	for spec, expected := range tests {
+		s, err := ParseSort(spec)
+		assert.NoError(t, err)
+
+		txs := append([]monzo.Transaction{}, testTransactions...)
+		s.Apply(txs)
+
+		assert.Equal(t, expected, ids(txs), spec)
+	}
+}
+
+func TestQueryApply(t *testing.T) {
+	q, err := ParseQuery("amount < 0 and not declined", "amount")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"tx_1", "tx_2"}, ids(q.Apply(testTransactions)))
+
+	q.Limit = 1
+	assert.Equal(t, []string{"tx_1"}, ids(q.Apply(testTransactions)))
+	assert.Equal(t, "tx_1", testTransactions[0].ID)
+
+	assert.Len(t, (&Query{}).Apply(testTransactions), len(testTransactions))
+
+	_, err = ParseQuery("amount <", "")
+	assert.ErrorIs(t, err, ErrSyntax)
+
+	_, err = ParseQuery("", "nope")
+	assert.ErrorIs(t, err, ErrFieldUnknown)
+}
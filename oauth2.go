@@ -9,12 +9,34 @@ var OAuth2Endpoint = oauth2.Endpoint{
 	AuthStyle: oauth2.AuthStyleInParams,
 }
 
+// NewOAuth2Endpoint creates an OAuth2 endpoint with the provided auth and token URLs, for example to use a local stand-in
+// for the Monzo API. An empty URL is replaced with the URL from the default OAuth2Endpoint.
+func NewOAuth2Endpoint(authURL, tokenURL string) oauth2.Endpoint {
+	endpoint := OAuth2Endpoint
+
+	if authURL != "" {
+		endpoint.AuthURL = authURL
+	}
+
+	if tokenURL != "" {
+		endpoint.TokenURL = tokenURL
+	}
+
+	return endpoint
+}
+
 // OAuth2Config creates an OAuth2 config based on the provided client ID, client secret, and redirect URL.
 func OAuth2Config(clientID, clientSecret, redirectURL string) *oauth2.Config {
+	return OAuth2ConfigWithEndpoint(clientID, clientSecret, redirectURL, OAuth2Endpoint)
+}
+
+// OAuth2ConfigWithEndpoint creates an OAuth2 config based on the provided client ID, client secret, redirect URL, and
+// OAuth2 endpoint.
+func OAuth2ConfigWithEndpoint(clientID, clientSecret, redirectURL string, endpoint oauth2.Endpoint) *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		Endpoint:     OAuth2Endpoint,
+		Endpoint:     endpoint,
 		RedirectURL:  redirectURL,
 	}
 }
@@ -19,3 +19,31 @@ func TestOAuth2Config(t *testing.T) {
 
 	assert.Equal(t, expected, OAuth2Config(id, secret, redirect))
 }
+
+func TestNewOAuth2Endpoint(t *testing.T) {
+	assert.Equal(t, OAuth2Endpoint, NewOAuth2Endpoint("", ""))
+
+	endpoint := NewOAuth2Endpoint("http://127.0.0.1:8080/auth", "http://127.0.0.1:8080/oauth2/token")
+
+	assert.Equal(t, "http://127.0.0.1:8080/auth", endpoint.AuthURL)
+	assert.Equal(t, "http://127.0.0.1:8080/oauth2/token", endpoint.TokenURL)
+	assert.Equal(t, OAuth2Endpoint.AuthStyle, endpoint.AuthStyle)
+
+	endpoint = NewOAuth2Endpoint("", "http://127.0.0.1:8080/oauth2/token")
+
+	assert.Equal(t, OAuth2Endpoint.AuthURL, endpoint.AuthURL)
+}
+
+func TestOAuth2ConfigWithEndpoint(t *testing.T) {
+	id, secret, redirect := "id", "secret", "redirect"
+	endpoint := NewOAuth2Endpoint("http://127.0.0.1:8080/auth", "http://127.0.0.1:8080/oauth2/token")
+
+	expected := &oauth2.Config{
+		ClientID:     id,
+		ClientSecret: secret,
+		Endpoint:     endpoint,
+		RedirectURL:  redirect,
+	}
+
+	assert.Equal(t, expected, OAuth2ConfigWithEndpoint(id, secret, redirect, endpoint))
+}
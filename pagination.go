@@ -6,6 +6,9 @@ import (
 	"time"
 )
 
+// MaxPageLimit is the largest number of results the Monzo API will return for a single paginated request.
+const MaxPageLimit = 100
+
 type Pagination struct {
 	Limit  int
 	Since  string
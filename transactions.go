@@ -1,9 +1,11 @@
 package monzo
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -66,6 +68,7 @@ type Transaction struct {
 	Counterparty                         interface{}       `json:"counterparty"`
 	Created                              string            `json:"created"`
 	Currency                             string            `json:"currency"`
+	DeclineReason                        string            `json:"decline_reason,omitempty"`
 	DedupeID                             string            `json:"dedupe_id"`
 	Description                          string            `json:"description"`
 	Fees                                 interface{}       `json:"fees"`
@@ -101,6 +104,58 @@ func (t Transaction) UpdatedTime() time.Time {
 	return tme
 }
 
+// SettledTime converts the RFC3339 string time into a Time object. It is the zero time if the transaction has not settled.
+func (t Transaction) SettledTime() time.Time {
+	tme, _ := time.Parse(time.RFC3339Nano, t.Settled)
+	return tme
+}
+
+// Declined reports whether the transaction was declined, in which case it did not move any money.
+func (t Transaction) Declined() bool {
+	return t.DeclineReason != ""
+}
+
+// Counterparty represents the other party of a transfer (e.g. a bank transfer, or a payment to another Monzo user)
+// provided by the Monzo API.
+type Counterparty struct {
+	AccountID              string `json:"account_id"`
+	AccountNumber          string `json:"account_number"`
+	SortCode               string `json:"sort_code"`
+	Name                   string `json:"name"`
+	PreferredName          string `json:"preferred_name"`
+	UserID                 string `json:"user_id"`
+	BeneficiaryAccountType string `json:"beneficiary_account_type"`
+	ServiceUserNumber      string `json:"service_user_number"`
+}
+
+// CounterpartyDetails decodes the transaction's counterparty. It is empty if the transaction does not have one (e.g. card
+// payments).
+func (t Transaction) CounterpartyDetails() (c Counterparty) {
+	if t.Counterparty == nil {
+		return
+	}
+
+	if data, err := json.Marshal(t.Counterparty); err == nil {
+		json.Unmarshal(data, &c)
+	}
+
+	return
+}
+
+// PotID returns the ID of the pot the transaction moved money into or out of, or an empty string if it is not a pot
+// transfer.
+func (t Transaction) PotID() string {
+	if potID := t.Metadata["pot_id"]; potID != "" {
+		return potID
+	}
+
+	if strings.HasPrefix(t.Description, "pot_") && !strings.Contains(t.Description, " ") {
+		return t.Description
+	}
+
+	return ""
+}
+
 // TransactionList represents the response from the Monzo API for a list of transactions.
 type TransactionList struct {
 	Transactions []Transaction `json:"transactions"`
@@ -220,6 +275,59 @@ func (s *TransactionsService) List(accountID string, expandMerchant bool, paging
 	return
 }
 
+// ListPages walks every page of transactions on the user's account, calling fn with each page in turn.
+//
+// Paging starts from paging.Since (or the beginning of the account's history if it is empty), and continues until the API
+// returns a partial page, or paging.Before is reached. paging.Limit is used as the page size, up to MaxPageLimit.
+//
+// If fn returns an error, paging stops and the error is returned.
+func (s *TransactionsService) ListPages(accountID string, expandMerchant bool, paging *Pagination, fn func(page *TransactionList) error) (err error) {
+	page := &Pagination{Limit: MaxPageLimit}
+
+	if paging != nil {
+		page.Since, page.Before = paging.Since, paging.Before
+
+		if paging.Limit > 0 && paging.Limit < MaxPageLimit {
+			page.Limit = paging.Limit
+		}
+	}
+
+	for {
+		list, err := s.List(accountID, expandMerchant, page)
+		if err != nil {
+			return err
+		}
+
+		if len(list.Transactions) == 0 {
+			return nil
+		}
+
+		if err = fn(list); err != nil {
+			return err
+		}
+
+		if len(list.Transactions) < page.Limit {
+			return nil
+		}
+
+		page.Since = list.Transactions[len(list.Transactions)-1].ID
+	}
+}
+
+// ListAll returns every transaction on the user's account, following pagination until the end of the results.
+//
+// See ListPages for how the paging argument is used.
+func (s *TransactionsService) ListAll(accountID string, expandMerchant bool, paging *Pagination) (list *TransactionList, err error) {
+	list = &TransactionList{}
+
+	err = s.ListPages(accountID, expandMerchant, paging, func(page *TransactionList) error {
+		list.Transactions = append(list.Transactions, page.Transactions...)
+		return nil
+	})
+
+	return
+}
+
 // Returns an individual transaction, fetched by its id.
 func (s *TransactionsService) Get(transactionID string, expandMerchant bool) (tx *TransactionSingle, err error) {
 	var out any
@@ -1,11 +1,15 @@
 package monzo
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -206,3 +210,120 @@ func TestTransactionsAnnotate(t *testing.T) {
 	assert.Equal(t, expected, tx)
 	assert.NoError(t, err)
 }
+
+// pagedRoundTripper serves the transactions list endpoint from a fixed set of transactions, honouring the limit and since parameters.
+type pagedRoundTripper struct {
+	transactions []map[string]interface{}
+	requests     []string
+}
+
+func (p *pagedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.requests = append(p.requests, req.URL.RawQuery)
+
+	limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	since := req.URL.Query().Get("since")
+
+	start := 0
+	for i, tx := range p.transactions {
+		if tx["id"] == since {
+			start = i + 1
+		}
+	}
+
+	end := start + limit
+	if end > len(p.transactions) {
+		end = len(p.transactions)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"transactions": p.transactions[start:end]})
+
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestTransactionsListPages(t *testing.T) {
+	rt := &pagedRoundTripper{}
+	for i := 0; i < 5; i++ {
+		rt.transactions = append(rt.transactions, map[string]interface{}{"id": fmt.Sprintf("tx_%d", i)})
+	}
+
+	c := New(&http.Client{Transport: rt})
+
+	pages := [][]string{}
+
+	err := c.Transactions.ListPages("test", true, &Pagination{Limit: 2}, func(page *TransactionList) error {
+		ids := []string{}
+		for _, tx := range page.Transactions {
+			ids = append(ids, tx.ID)
+		}
+
+		pages = append(pages, ids)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"tx_0", "tx_1"}, {"tx_2", "tx_3"}, {"tx_4"}}, pages)
+	assert.Len(t, rt.requests, 3)
+}
+
+func TestTransactionsListPagesStop(t *testing.T) {
+	rt := &pagedRoundTripper{}
+	for i := 0; i < 5; i++ {
+		rt.transactions = append(rt.transactions, map[string]interface{}{"id": fmt.Sprintf("tx_%d", i)})
+	}
+
+	c := New(&http.Client{Transport: rt})
+	stop := errors.New("stop")
+
+	err := c.Transactions.ListPages("test", true, &Pagination{Limit: 2}, func(page *TransactionList) error {
+		return stop
+	})
+
+	assert.Equal(t, stop, err)
+	assert.Len(t, rt.requests, 1)
+}
+
+func TestTransactionsListAll(t *testing.T) {
+	rt := &pagedRoundTripper{}
+	for i := 0; i < MaxPageLimit+1; i++ {
+		rt.transactions = append(rt.transactions, map[string]interface{}{"id": fmt.Sprintf("tx_%d", i)})
+	}
+
+	c := New(&http.Client{Transport: rt})
+
+	list, err := c.Transactions.ListAll("test", true, &Pagination{Since: "tx_0"})
+
+	assert.NoError(t, err)
+	assert.Len(t, list.Transactions, MaxPageLimit)
+	assert.Equal(t, "tx_1", list.Transactions[0].ID)
+	assert.Equal(t, []string{
+		"account_id=test&expand%5B%5D=merchant&limit=100&since=tx_0",
+		"account_id=test&expand%5B%5D=merchant&limit=100&since=tx_100",
+	}, rt.requests)
+}
+
+func TestTransactionCounterpartyDetails(t *testing.T) {
+	tx := Transaction{}
+
+	data := []byte(`{"counterparty": {"account_number": "12345678", "sort_code": "040004", "name": "Jane Doe", "user_id": "user_1"}}`)
+	assert.NoError(t, json.Unmarshal(data, &tx))
+
+	assert.Equal(t, Counterparty{AccountNumber: "12345678", SortCode: "040004", Name: "Jane Doe", UserID: "user_1"}, tx.CounterpartyDetails())
+	assert.Equal(t, Counterparty{}, Transaction{}.CounterpartyDetails())
+}
+
+func TestTransactionPotID(t *testing.T) {
+	assert.Equal(t, "pot_1", Transaction{Metadata: map[string]string{"pot_id": "pot_1"}}.PotID())
+	assert.Equal(t, "pot_2", Transaction{Description: "pot_2"}.PotID())
+	assert.Equal(t, "", Transaction{Description: "pot_luck cafe"}.PotID())
+	assert.Equal(t, "", Transaction{Description: "TESCO"}.PotID())
+}
+
+func TestTransactionDeclined(t *testing.T) {
+	tx := Transaction{}
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"decline_reason": "INSUFFICIENT_FUNDS", "settled": ""}`), &tx))
+	assert.True(t, tx.Declined())
+	assert.True(t, tx.SettledTime().IsZero())
+	assert.False(t, Transaction{Settled: "2015-08-23T12:20:18Z"}.Declined())
+	assert.Equal(t, time.Date(2015, 8, 23, 12, 20, 18, 0, time.UTC), Transaction{Settled: "2015-08-23T12:20:18Z"}.SettledTime())
+}
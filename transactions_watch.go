@@ -0,0 +1,314 @@
+package monzo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultWatchInterval is how often a watch polls for transactions, if its interval is not set.
+	DefaultWatchInterval = time.Second * 30
+
+	// DefaultWatchWindow is how long after they are created Watch re-fetches transactions, to pick up updates such as
+	// notes being added.
+	DefaultWatchWindow = time.Hour
+
+	// MaxPendingAge is how long after they are created unsettled transactions are re-fetched, waiting for them to settle.
+	MaxPendingAge = time.Hour * 24 * 14
+)
+
+// TransactionEventType is the kind of change a TransactionEvent reports.
+type TransactionEventType string
+
+const (
+	// TransactionCreated events report a transaction that has not been seen before.
+	TransactionCreated TransactionEventType = "created"
+
+	// TransactionUpdated events report a change to a transaction that has been seen before.
+	TransactionUpdated TransactionEventType = "updated"
+
+	// TransactionError events report a failure to fetch transactions. Watching continues at the next interval.
+	TransactionError TransactionEventType = "error"
+)
+
+// TransactionEvent is a transaction that has been created or updated, sent by a watch.
+type TransactionEvent struct {
+	Type        TransactionEventType `json:"type"`
+	Transaction Transaction          `json:"transaction"`
+
+	// Previous is the transaction as it was last seen, for updated events.
+	Previous *Transaction `json:"previous,omitempty"`
+
+	// Changes lists what changed in updated events: settled, amount, pending, declined, category, notes or metadata.
+	Changes []string `json:"changes,omitempty"`
+
+	// Err is the error that occurred, for error events.
+	Err error `json:"-"`
+}
+
+// WatchOptions configures a watch started by WatchWithOptions.
+type WatchOptions struct {
+	// Interval is how often to poll for transactions (default: DefaultWatchInterval).
+	Interval time.Duration
+
+	// Window is how long after they are created transactions are re-fetched, to pick up updates. Unsettled
+	// transactions are re-fetched until they settle (or for up to MaxPendingAge) regardless.
+	Window time.Duration
+
+	ExpandMerchant bool
+
+	// Known are transactions that have already been seen, such as those stored by a previous sync. Changes to them are
+	// sent as updated events, and polling starts from the earliest of them that could still be updated (or after the
+	// latest of them).
+	//
+	// If Known is nil, the first poll fetches the transactions in the window without sending events for them.
+	Known []Transaction
+}
+
+// Watch polls the account for transactions every interval, sending an event each time a transaction is created or
+// updated, until the context is cancelled. The channel is closed when the watch stops.
+//
+// Transactions created before the watch started are not sent, but updates to them are (such as pending transactions
+// settling). It is the same as calling WatchWithOptions with a DefaultWatchWindow window and merchants expanded.
+func (s *TransactionsService) Watch(ctx context.Context, accountID string, interval time.Duration) <-chan TransactionEvent {
+	return s.WatchWithOptions(ctx, accountID, WatchOptions{Interval: interval, Window: DefaultWatchWindow, ExpandMerchant: true})
+}
+
+// WatchWithOptions polls the account for transactions, sending an event each time a transaction is created or
+// updated, until the context is cancelled. The channel is closed when the watch stops.
+//
+// Each poll lists the transactions created since the earliest one seen that could still be updated (one created within
+// the window, or an unsettled transaction), or since the latest one seen if none could be, and compares them with the
+// versions seen before.
+func (s *TransactionsService) WatchWithOptions(ctx context.Context, accountID string, opts WatchOptions) <-chan TransactionEvent {
+	events := make(chan TransactionEvent)
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+
+	w := &transactionWatch{
+		service:  s,
+		ctx:      ctx,
+		events:   events,
+		opts:     opts,
+		seen:     map[string]Transaction{},
+		started:  time.Now(),
+		baseline: opts.Known == nil,
+	}
+
+	for _, tx := range opts.Known {
+		w.seen[tx.ID] = tx
+		w.advance(tx)
+	}
+
+	go w.run(accountID)
+
+	return events
+}
+
+// transactionWatch is the state of a running watch.
+type transactionWatch struct {
+	service *TransactionsService
+	ctx     context.Context
+	events  chan<- TransactionEvent
+	opts    WatchOptions
+
+	// seen holds the latest version of each transaction seen that could still be updated, by ID.
+	seen map[string]Transaction
+
+	// latest is the most recently created transaction seen.
+	latest *Transaction
+
+	started time.Time
+
+	// baseline is set until the first successful poll, if its transactions should not be sent.
+	baseline bool
+}
+
+func (w *transactionWatch) run(accountID string) {
+	defer close(w.events)
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(accountID); err != nil && !w.send(TransactionEvent{Type: TransactionError, Err: err}) {
+			return
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists the transactions that may have been created or updated since the last poll, and sends their events.
+func (w *transactionWatch) poll(accountID string) error {
+	now := time.Now()
+	baseline := w.baseline
+
+	err := w.service.ListPages(accountID, w.opts.ExpandMerchant, &Pagination{Since: w.since(now)}, func(list *TransactionList) error {
+		for _, tx := range list.Transactions {
+			// Transactions created before the latest one seen have been seen before, even if they have since been
+			// forgotten, so are only updated if they are still remembered.
+			if _, ok := w.seen[tx.ID]; !ok && w.latest != nil && tx.CreatedTime().Before(w.latest.CreatedTime()) {
+				continue
+			}
+
+			if !w.update(tx, baseline) {
+				return w.ctx.Err()
+			}
+
+			w.advance(tx)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	w.baseline = false
+	w.prune(now)
+
+	return nil
+}
+
+// since returns where the next poll lists transactions from: when the earliest transaction seen that could still be
+// updated was created, so it is listed again, or the latest transaction seen. Before any have been seen, it is the start
+// of the window for the first poll (or when the watch started).
+func (w *transactionWatch) since(now time.Time) string {
+	if updatable := w.updatable(now); len(updatable) != 0 {
+		return updatable[0].CreatedTime().UTC().Format(time.RFC3339)
+	}
+
+	if w.latest != nil {
+		return w.latest.ID
+	}
+
+	from := w.started
+	if w.baseline && w.opts.Window > 0 {
+		from = now.Add(-w.opts.Window)
+	}
+
+	return from.UTC().Format(time.RFC3339)
+}
+
+// canUpdate reports whether the transaction could still be updated: it was created within the window, or it is
+// unsettled (and not too old to settle).
+func (w *transactionWatch) canUpdate(tx Transaction, now time.Time) bool {
+	age := now.Sub(tx.CreatedTime())
+
+	if w.opts.Window > 0 && age < w.opts.Window {
+		return true
+	}
+
+	return tx.Settled == "" && !tx.Declined() && age < MaxPendingAge
+}
+
+// updatable returns the transactions seen that could still be updated, ordered by the time they were created.
+func (w *transactionWatch) updatable(now time.Time) (txs []Transaction) {
+	for _, tx := range w.seen {
+		if w.canUpdate(tx, now) {
+			txs = append(txs, tx)
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool { return txs[i].CreatedTime().Before(txs[j].CreatedTime()) })
+
+	return
+}
+
+// prune forgets the transactions seen that can no longer be updated. Later polls skip them if they are listed again,
+// as they were created before the latest one seen.
+func (w *transactionWatch) prune(now time.Time) {
+	for id, tx := range w.seen {
+		if !w.canUpdate(tx, now) {
+			delete(w.seen, id)
+		}
+	}
+}
+
+// update records the latest version of the transaction, and sends its event if it is new or has changed (unless it is
+// part of the baseline). It returns false if the context was cancelled first.
+func (w *transactionWatch) update(tx Transaction, baseline bool) bool {
+	event, ok := w.diff(tx)
+	w.seen[tx.ID] = tx
+
+	return !ok || baseline || w.send(event)
+}
+
+// advance records the transaction as the latest one seen, if it was created after (or with) the latest so far. Only
+// listed transactions advance it, so the next poll lists after the last transaction of the last poll.
+func (w *transactionWatch) advance(tx Transaction) {
+	if w.latest == nil || !tx.CreatedTime().Before(w.latest.CreatedTime()) {
+		w.latest = &tx
+	}
+}
+
+// diff returns the event for the transaction, if it is new or has changed since it was last seen.
+func (w *transactionWatch) diff(tx Transaction) (TransactionEvent, bool) {
+	previous, ok := w.seen[tx.ID]
+	if !ok {
+		return TransactionEvent{Type: TransactionCreated, Transaction: tx}, true
+	}
+
+	changes := TransactionChanges(previous, tx)
+	if len(changes) == 0 {
+		return TransactionEvent{}, false
+	}
+
+	return TransactionEvent{Type: TransactionUpdated, Transaction: tx, Previous: &previous, Changes: changes}, true
+}
+
+// send sends the event, returning false if the context was cancelled first.
+func (w *transactionWatch) send(event TransactionEvent) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case w.events <- event:
+		return true
+	}
+}
+
+// TransactionChanges lists what changed between two versions of a transaction: settled, amount, pending, declined,
+// category, notes or metadata.
+func TransactionChanges(previous, current Transaction) (changes []string) {
+	if previous.Settled != current.Settled {
+		changes = append(changes, "settled")
+	}
+
+	if previous.Amount != current.Amount || previous.LocalAmount != current.LocalAmount {
+		changes = append(changes, "amount")
+	}
+
+	if previous.AmountIsPending != current.AmountIsPending {
+		changes = append(changes, "pending")
+	}
+
+	if previous.DeclineReason != current.DeclineReason {
+		changes = append(changes, "declined")
+	}
+
+	if previous.Category != current.Category || !reflect.DeepEqual(previous.Categories, current.Categories) {
+		changes = append(changes, "category")
+	}
+
+	if previous.Notes != current.Notes {
+		changes = append(changes, "notes")
+	}
+
+	if len(previous.Metadata) != 0 || len(current.Metadata) != 0 {
+		if !reflect.DeepEqual(previous.Metadata, current.Metadata) {
+			changes = append(changes, "metadata")
+		}
+	}
+
+	return
+}
@@ -0,0 +1,203 @@
+package monzo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pollRoundTripper serves the transactions list endpoint with the next set of transactions on each request, repeating the
+// last set once they run out. It records the since parameter of each list request, and the path of any other request.
+type pollRoundTripper struct {
+	mu       sync.Mutex
+	polls    [][]map[string]interface{}
+	lists    int
+	requests []string
+	err      error
+}
+
+func (p *pollRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		p.requests = append(p.requests, req.URL.Query().Get("since"))
+		return nil, p.err
+	}
+
+	if req.URL.Path != "/transactions" {
+		p.requests = append(p.requests, req.URL.Path)
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	}
+
+	p.requests = append(p.requests, req.URL.Query().Get("since"))
+
+	n := p.lists
+	if n >= len(p.polls) {
+		n = len(p.polls) - 1
+	}
+
+	p.lists++
+
+	data, _ := json.Marshal(map[string]interface{}{"transactions": p.polls[n]})
+
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (p *pollRoundTripper) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string{}, p.requests...)
+}
+
+// receive returns the next n events from the watch, failing the test if they do not arrive in time.
+func receive(t *testing.T, events <-chan TransactionEvent, n int) (received []TransactionEvent) {
+	t.Helper()
+
+	for len(received) < n {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d events", len(received), n)
+		}
+	}
+
+	return
+}
+
+func TestTransactionsWatch(t *testing.T) {
+	created := time.Now().Add(-time.Minute * 2).UTC().Format(time.RFC3339)
+	later := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	pending := map[string]interface{}{"id": "tx_0", "created": created, "amount": -500}
+	settled := map[string]interface{}{"id": "tx_0", "created": created, "amount": -510, "settled": later}
+	added := map[string]interface{}{"id": "tx_1", "created": later, "amount": -100}
+
+	rt := &pollRoundTripper{polls: [][]map[string]interface{}{{pending}, {settled, added}}}
+
+	c := New(&http.Client{Transport: rt})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := c.Transactions.Watch(ctx, "test", time.Millisecond*10)
+
+	received := receive(t, events, 2)
+
+	assert.Equal(t, TransactionUpdated, received[0].Type)
+	assert.Equal(t, "tx_0", received[0].Transaction.ID)
+	assert.Equal(t, int64(-500), received[0].Previous.Amount)
+	assert.Equal(t, []string{"settled", "amount"}, received[0].Changes)
+
+	assert.Equal(t, TransactionCreated, received[1].Type)
+	assert.Equal(t, "tx_1", received[1].Transaction.ID)
+	assert.Nil(t, received[1].Previous)
+
+	for len(rt.Requests()) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	for event := range events {
+		assert.Fail(t, "unexpected event", event)
+	}
+
+	// The first poll lists the window, and later polls list again from the earliest transaction that could still be
+	// updated, without fetching transactions individually.
+	requests := rt.Requests()
+
+	assert.NotEqual(t, created, requests[0])
+	assert.Equal(t, []string{created, created}, requests[1:3])
+}
+
+func TestTransactionsWatchKnown(t *testing.T) {
+	known := Transaction{ID: "tx_0", Created: "2015-08-22T12:20:18Z", Settled: "2015-08-23T12:20:18Z"}
+	added := map[string]interface{}{"id": "tx_1", "created": "2015-08-24T12:20:18Z", "settled": "2015-08-24T12:20:18Z"}
+
+	rt := &pollRoundTripper{polls: [][]map[string]interface{}{{added}}}
+	c := New(&http.Client{Transport: rt})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := c.Transactions.WatchWithOptions(ctx, "test", WatchOptions{Interval: time.Millisecond * 10, Known: []Transaction{known}})
+
+	received := receive(t, events, 1)
+
+	assert.Equal(t, TransactionCreated, received[0].Type)
+	assert.Equal(t, "tx_1", received[0].Transaction.ID)
+
+	for len(rt.Requests()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The known transaction is settled and too old to be updated, so it is not re-fetched.
+	assert.Equal(t, []string{"tx_0", "tx_1"}, rt.Requests()[:2])
+}
+
+func TestTransactionsWatchError(t *testing.T) {
+	rt := &pollRoundTripper{err: errors.New("unavailable")}
+	c := New(&http.Client{Transport: rt})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := c.Transactions.Watch(ctx, "test", time.Millisecond*10)
+
+	received := receive(t, events, 2)
+
+	assert.Equal(t, TransactionError, received[0].Type)
+	assert.ErrorContains(t, received[0].Err, "unavailable")
+	assert.Equal(t, TransactionError, received[1].Type)
+}
+
+func TestTransactionWatchPrune(t *testing.T) {
+	now := time.Now()
+	at := func(age time.Duration) string { return now.Add(-age).UTC().Format(time.RFC3339) }
+
+	w := &transactionWatch{opts: WatchOptions{Window: time.Hour}, seen: map[string]Transaction{}}
+
+	for _, tx := range []Transaction{
+		{ID: "recent", Created: at(time.Minute), Settled: at(time.Minute)},
+		{ID: "pending", Created: at(time.Hour * 24 * 3)},
+		{ID: "settled", Created: at(time.Hour * 2), Settled: at(time.Hour)},
+		{ID: "declined", Created: at(time.Hour * 2), DeclineReason: "INSUFFICIENT_FUNDS"},
+		{ID: "expired", Created: at(MaxPendingAge + time.Hour)},
+	} {
+		w.seen[tx.ID] = tx
+		w.advance(tx)
+	}
+
+	updatable := []string{}
+	for _, tx := range w.updatable(now) {
+		updatable = append(updatable, tx.ID)
+	}
+
+	assert.Equal(t, []string{"pending", "recent"}, updatable)
+
+	w.prune(now)
+
+	assert.Len(t, w.seen, 2)
+	assert.Contains(t, w.seen, "recent")
+	assert.Contains(t, w.seen, "pending")
+	assert.Equal(t, "recent", w.latest.ID)
+}
+
+func TestTransactionChanges(t *testing.T) {
+	previous := Transaction{Amount: -500, AmountIsPending: true, Category: "general"}
+	current := Transaction{Amount: -500, Settled: "2015-08-23T12:20:18Z", Category: "groceries", Notes: "Lunch", Metadata: map[string]string{"a": "b"}}
+
+	assert.Equal(t, []string{"settled", "pending", "category", "notes", "metadata"}, TransactionChanges(previous, current))
+	assert.Empty(t, TransactionChanges(current, current))
+	assert.Empty(t, TransactionChanges(Transaction{Metadata: map[string]string{}}, Transaction{}))
+}